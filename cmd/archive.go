@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the archive formats
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// the size an archive entry is held in memory up to, a larger entry is spooled to a temporary
+// file. The memory used by the entries is at most this size times the entries written at once
+var archiveSpoolSize = 4 << 20
+
+// the characters kept in archive names, the others are replaced with -
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Archive writes files to a tar.gz or zip archive. The entries are written one at a time so
+// the workers fetching logs can share it, and their paths start with the root folder so the
+// archive extracts into a single folder
+type Archive struct {
+	// the file the archive is written to, empty when it is written to a writer
+	Path string
+
+	format string
+	root   string
+
+	mu     sync.Mutex
+	file   *os.File
+	gzw    *gzip.Writer
+	tw     *tar.Writer
+	zw     *zip.Writer
+	closed bool
+}
+
+// Returns the name of an archive, cnvrg-<kind>-<cluster>-<namespaces>-<time> without the extension.
+// With more than 3 namespaces only the first one is named, <namespace>-and-<n>-more
+func ArchiveName(kind string, cluster string, namespaces []string, t time.Time) string {
+	names := strings.Join(namespaces, "_")
+	if len(namespaces) > 3 {
+		names = fmt.Sprintf("%s-and-%d-more", namespaces[0], len(namespaces)-1)
+	}
+	name := fmt.Sprintf("cnvrg-%s-%s-%s-%s", kind, cluster, names, t.UTC().Format("20060102-150405"))
+	return strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-")
+}
+
+// Creates the archive <dir>/<name>.<format>, its entries are under the folder <name>/
+func CreateArchive(dir string, name string, format string) (*Archive, error) {
+	log.Println("CreateArchive function called.")
+
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return nil, fmt.Errorf("unknown archive format %v, use %v or %v", format, ArchiveTarGz, ArchiveZip)
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the folder %v. %w", dir, err)
+	}
+	target := filepath.Join(dir, name+"."+format)
+	file, err := os.Create(target)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the archive %v. %w", target, err)
+	}
+
+	a, _ := NewArchive(file, name, format)
+	a.Path = target
+	a.file = file
+	return a, nil
+}
+
+// Returns an archive written to "w" with its entries under the folder "root", no folder when empty
+func NewArchive(w io.Writer, root string, format string) (*Archive, error) {
+	a := &Archive{format: format, root: root}
+	switch format {
+	case ArchiveTarGz:
+		a.gzw = gzip.NewWriter(w)
+		a.tw = tar.NewWriter(a.gzw)
+	case ArchiveZip:
+		a.zw = zip.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown archive format %v, use %v or %v", format, ArchiveTarGz, ArchiveZip)
+	}
+	return a, nil
+}
+
+// the path of the entry "name" in the archive, with / separators under the root folder
+func (a *Archive) entryName(name string) string {
+	return path.Join(a.root, filepath.ToSlash(name))
+}
+
+// Adds the file "name" with the content read from "r", "size" bytes long
+func (a *Archive) add(name string, size int64, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return fmt.Errorf("error adding %v, the archive is closed", name)
+	}
+
+	var w io.Writer
+	if a.tw != nil {
+		hdr := &tar.Header{Name: a.entryName(name), Size: size, Mode: int64(mode.Perm()), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := a.tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error adding %v to the archive. %w", name, err)
+		}
+		w = a.tw
+	} else {
+		hdr := &zip.FileHeader{Name: a.entryName(name), Method: zip.Deflate, Modified: modTime}
+		hdr.SetMode(mode)
+		var err error
+		w, err = a.zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("error adding %v to the archive. %w", name, err)
+		}
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("error adding %v to the archive. %w", name, err)
+	}
+	return nil
+}
+
+// Adds the file "name" with the content "data"
+func (a *Archive) WriteFile(name string, data []byte) error {
+	return a.add(name, int64(len(data)), 0644, time.Now(), bytes.NewReader(data))
+}
+
+// Adds every file under the folder "dir", named by their path in the folder
+func (a *Archive) AddDir(dir string) error {
+	log.Println("AddDir function called.")
+
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking the file path. %w", err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("error opening the file %v. %w", p, err)
+		}
+		defer f.Close()
+		return a.add(name, fi.Size(), fi.Mode(), fi.ModTime(), f)
+	})
+}
+
+// Returns a writer of the file "name", added to the archive when it is closed. The content is held
+// until then since a tar entry needs its size first, in memory up to 4MiB and in a temporary file
+// above that, so a large log doesn't have to fit in memory
+func (a *Archive) Create(name string) *ArchiveEntry {
+	return &ArchiveEntry{archive: a, name: name}
+}
+
+// Finishes the archive and closes its file, the archive is incomplete until it is closed
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	var err error
+	if a.tw != nil {
+		// close in order so the archive is complete before the file is closed
+		err = a.tw.Close()
+		if err == nil {
+			err = a.gzw.Close()
+		}
+	} else {
+		err = a.zw.Close()
+	}
+	if a.file != nil {
+		if cerr := a.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error writing the archive %v. %w", a.Path, err)
+	}
+	return nil
+}
+
+// ArchiveEntry is a file being written to an archive, see Archive.Create
+type ArchiveEntry struct {
+	archive *Archive
+	name    string
+	buf     bytes.Buffer
+	// the temporary file the content is moved to once it is larger than archiveSpoolSize
+	spool *os.File
+	size  int64
+	done  bool
+}
+
+func (e *ArchiveEntry) Write(p []byte) (int, error) {
+	if e.spool == nil && e.buf.Len()+len(p) > archiveSpoolSize {
+		spool, err := os.CreateTemp("", "cnvrgctl-archive-*")
+		if err != nil {
+			return 0, fmt.Errorf("error creating a temporary file for %v. %w", e.name, err)
+		}
+		e.spool = spool
+		e.size, err = e.buf.WriteTo(spool)
+		if err != nil {
+			return 0, fmt.Errorf("error writing the temporary file of %v. %w", e.name, err)
+		}
+	}
+	if e.spool == nil {
+		return e.buf.Write(p)
+	}
+	n, err := e.spool.Write(p)
+	e.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("error writing the temporary file of %v. %w", e.name, err)
+	}
+	return n, nil
+}
+
+// Adds the file to the archive
+func (e *ArchiveEntry) Close() error {
+	if e.done {
+		return nil
+	}
+	e.done = true
+	if e.spool == nil {
+		return e.archive.WriteFile(e.name, e.buf.Bytes())
+	}
+
+	defer e.removeSpool()
+	if _, err := e.spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading the temporary file of %v. %w", e.name, err)
+	}
+	return e.archive.add(e.name, e.size, 0644, time.Now(), e.spool)
+}
+
+// Drops the file, it isn't added to the archive
+func (e *ArchiveEntry) Discard() {
+	e.done = true
+	e.buf.Reset()
+	e.removeSpool()
+}
+
+// Deletes the temporary file of the entry
+func (e *ArchiveEntry) removeSpool() {
+	if e.spool == nil {
+		return
+	}
+	e.spool.Close()
+	os.Remove(e.spool.Name())
+	e.spool = nil
+}
+
+// The name of the cluster of the current kubeconfig context, or the one set with --context, used
+// to name archives. "cluster" when it can't be read, like in a pod. An ARN like the EKS ones is
+// shortened to the name after the last /
+func ClusterName() string {
+	config, err := RESTClientGetter().ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return "cluster"
+	}
+	contextName := config.CurrentContext
+	if kubeConfigFlags.Context != nil && *kubeConfigFlags.Context != "" {
+		contextName = *kubeConfigFlags.Context
+	}
+	ctx, ok := config.Contexts[contextName]
+	if !ok || ctx.Cluster == "" {
+		return "cluster"
+	}
+	name := ctx.Cluster
+	if i := strings.LastIndex(name, "/"); i >= 0 && i < len(name)-1 {
+		name = name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveName(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		cluster    string
+		namespaces []string
+		expected   string
+	}{
+		{"one_namespace", "kind-dev", []string{"cnvrg"}, "cnvrg-logs-kind-dev-cnvrg-20240601-100000"},
+		{"namespaces", "kind-dev", []string{"cnvrg", "cnvrg-jobs"}, "cnvrg-logs-kind-dev-cnvrg_cnvrg-jobs-20240601-100000"},
+		{"many_namespaces", "kind-dev", []string{"a", "b", "c", "d"}, "cnvrg-logs-kind-dev-a-and-3-more-20240601-100000"},
+		{"unsafe_characters", "gke_project_us-east1_prod:1", []string{"cnvrg"}, "cnvrg-logs-gke_project_us-east1_prod-1-cnvrg-20240601-100000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if name := ArchiveName("logs", tc.cluster, tc.namespaces, now); name != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, name)
+			}
+		})
+	}
+}
+
+func TestArchive(t *testing.T) {
+	// a folder with a nested file, archived with the files written from memory
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "cnvrg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "cnvrg", "app-1_app.txt"), []byte("started"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{ArchiveTarGz, ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "nested", "out")
+			a, err := CreateArchive(dir, "cnvrg-logs-test", format)
+			if err != nil {
+				t.Fatalf("archive not created: %v", err)
+			}
+			if a.Path != filepath.Join(dir, "cnvrg-logs-test."+format) {
+				t.Fatalf("unexpected archive path %v", a.Path)
+			}
+			if err := a.AddDir(src); err != nil {
+				t.Fatalf("folder not archived: %v", err)
+			}
+
+			entry := a.Create("other/job-1_main.txt")
+			io.WriteString(entry, "done")
+			if err := entry.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// a large entry is spooled to a temporary file
+			large := a.Create("other/large.txt")
+			for i := 0; i < 3; i++ {
+				io.WriteString(large, strings.Repeat("x", archiveSpoolSize/2))
+			}
+			if large.spool == nil {
+				t.Fatal("expected the large entry to be spooled to a file")
+			}
+			spooled := large.spool.Name()
+			if err := large.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+				t.Errorf("expected the temporary file to be removed, got %v", err)
+			}
+
+			// a discarded entry isn't added
+			dropped := a.Create("other/empty.txt")
+			dropped.Discard()
+			dropped.Close()
+
+			if err := a.Close(); err != nil {
+				t.Fatalf("archive not closed: %v", err)
+			}
+			if err := a.WriteFile("late.txt", nil); err == nil {
+				t.Error("expected an error adding to a closed archive")
+			}
+
+			files := readTestArchive(t, a.Path, format)
+			expected := map[string]string{
+				"cnvrg-logs-test/cnvrg/app-1_app.txt":  "started",
+				"cnvrg-logs-test/other/job-1_main.txt": "done",
+				"cnvrg-logs-test/other/large.txt":      strings.Repeat("x", archiveSpoolSize/2*3),
+			}
+			if len(files) != len(expected) {
+				t.Fatalf("expected the files %v, got %v", expected, files)
+			}
+			for name, content := range expected {
+				if files[name] != content {
+					t.Errorf("expected %s to hold %q, got %q", name, content, files[name])
+				}
+			}
+		})
+	}
+
+	if _, err := CreateArchive(t.TempDir(), "x", "rar"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// reads the names and contents of the files in the archive
+func readTestArchive(t *testing.T, path string, format string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("archive not written: %v", err)
+	}
+
+	files := map[string]string{}
+	if format == ArchiveZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("archive not valid: %v", err)
+		}
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(r)
+			r.Close()
+			files[f.Name] = string(b)
+		}
+		return files
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("archive is not gzipped: %v", err)
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("error reading the archive: %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
@@ -25,7 +25,10 @@ Examples:
   cnvrgctl backup postgres --target postgres-ha --label app.kubernetes.io/name -n cnvrg
   
 # Backups the default object storage bucket in the cnvrg namespace.
-  cnvrgctl backup files -n cnvrg`,
+  cnvrgctl backup files -n cnvrg
+
+# Show what a postgres backup would scale, execute and copy without changing anything.
+  cnvrgctl backup postgres -n cnvrg --dry-run`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("called the backup command")
 	},
@@ -33,4 +36,7 @@ Examples:
 
 func init() {
 	root.RootCmd.AddCommand(backupCmd)
+
+	// flag to print the plan of the backup without changing anything
+	backupCmd.PersistentFlags().BoolP("dry-run", "", false, "Print the steps of the backup without scaling, executing or copying anything.")
 }
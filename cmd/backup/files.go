@@ -67,22 +67,39 @@ Examples:
 		// connect to kubernetes and define clientset and rest client
 		api, err := root.ConnectToK8s()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error connecting to the cluster, check your connectivity. %v", err)
-			log.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
 		}
 
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planFilesBackup(api, nsFlag, s3SecretName)
+			if err != nil {
+				root.Fatalf("error planning the backup. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
 		// scale down the application pods to prepare for backups
 		err = root.ScaleDeployDown(api, nsFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error scaling the deployment. %v", err)
-			log.Fatalf("error scaling the deployment. %v\n", err)
+			root.Fatalf("error scaling the deployment. %v", err)
 		}
 
 		// get the object data and store in the ObjectStorage struct
 		objectData, err := root.GetObjectSecret(api, s3SecretName, nsFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to get the S3 secret. %v", err)
-			log.Fatalf("failed to get the S3 secret. %v\n", err)
+			root.Fatalf("failed to get the S3 secret. %v", err)
 		}
 
 		// determines the bucket type, then runs the corrispoding functions
@@ -96,7 +113,7 @@ Examples:
 			}
 
 			// backup the files from minio to the local drive
-			result, err = backupMinioBucketLocal(objectData)
+			result, err = backupMinioBucketLocal(api.Context(), objectData)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error backing up the bucket, check the logs. %v", err)
 				log.Printf("error backing up the bucket, check the logs. %v\n", err)
@@ -123,8 +140,9 @@ Examples:
 		if result {
 			root.ScaleDeployUp(api, o.Namespace)
 		} else {
+			// the cleanup stack scales the pods back up
 			log.Println("backup result was set to false, there was a problem. result value: ", result)
-			fmt.Println("there was a problem with the backup, check the logs.")
+			root.Fatalf("there was a problem with the backup, check the logs.")
 		}
 	},
 }
@@ -199,7 +217,8 @@ func listS3Bucket(o root.ObjectStorage) {
 }
 
 // TODO: check if useSSL = false, conslidate with get bucket function
-func backupMinioBucketLocal(o *root.ObjectStorage) (bool, error) {
+// Copies the objects in the bucket to ./cnvrg-storage, stops when "ctx" is cancelled
+func backupMinioBucketLocal(ctx context.Context, o *root.ObjectStorage) (bool, error) {
 	log.Println("backupMinioBucketLocal function called.")
 
 	// Initialize a new MinIO client
@@ -219,11 +238,16 @@ func backupMinioBucketLocal(o *root.ObjectStorage) (bool, error) {
 	}
 
 	// grabs all the objects and copies them to the local folder ./cnvrg-storage
-	allObjects := minioClient.ListObjects(context.Background(), o.BucketName, minio.ListObjectsOptions{Recursive: true})
+	allObjects := minioClient.ListObjects(ctx, o.BucketName, minio.ListObjectsOptions{Recursive: true})
 	for object := range allObjects {
 		log.Println(object.Key)
 		fmt.Println(object.Key)
-		minioClient.FGetObject(context.Background(), o.BucketName, object.Key, "./cnvrg-storage/"+object.Key, minio.GetObjectOptions{})
+		minioClient.FGetObject(ctx, o.BucketName, object.Key, "./cnvrg-storage/"+object.Key, minio.GetObjectOptions{})
+	}
+
+	// the listing stops early when the copy is interrupted
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("the copy of bucket %v was interrupted. %w", o.BucketName, err)
 	}
 
 	fmt.Println("Successfully copied objects!")
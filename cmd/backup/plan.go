@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Resolves the postgres pod, the workloads to scale and the local file for a dry run of backup postgres
+func planPostgresBackup(api *root.KubernetesAPI, ns string, target string, label string, disableScale bool, l string, f string) (*root.Plan, error) {
+	log.Println("planPostgresBackup function called.")
+
+	plan := root.NewPlan("Plan for backup postgres in namespace %s", ns)
+
+	podName, err := root.GetDeployPod(api, target, ns, label)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the postgres pod. %w", err)
+	}
+
+	if !disableScale {
+		err = root.PlanScaleDown(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plan.Step("run pg_dump of the cnvrg_production database in pod %s", podName).
+		Detail("writes cnvrg-db-backup.sql in the pod working directory")
+
+	plan.Step("copy %s from pod %s to %s", f, podName, filepath.Join(l, f)).
+		Detail(describeLocalFile(filepath.Join(l, f)))
+
+	if !disableScale {
+		err = root.PlanScaleUp(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// Resolves the storage secret, counts the objects in the bucket and the workloads to scale for a dry run of backup files
+func planFilesBackup(api *root.KubernetesAPI, ns string, secretName string) (*root.Plan, error) {
+	log.Println("planFilesBackup function called.")
+
+	plan := root.NewPlan("Plan for backup files in namespace %s", ns)
+
+	objectData, err := root.GetObjectSecret(api, secretName, ns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the S3 secret. %w", err)
+	}
+
+	err = root.PlanScaleDown(api, ns, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	step := plan.Step("copy the %s bucket %s from %s to ./cnvrg-storage/", objectData.Type, objectData.BucketName, objectData.Endpoint)
+	switch objectData.Type {
+	case "minio":
+		count, size, err := countMinioObjects(api.Context(), objectData)
+		if err != nil {
+			return nil, err
+		}
+		step.Detail("%d object(s), %s", count, root.HumanBytes(size))
+	default:
+		step.Detail("backing up %s buckets is not supported yet, nothing would be copied", objectData.Type)
+	}
+
+	err = root.PlanScaleUp(api, ns, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Resolves the redis pod and its data files, the key count and the workloads to scale for a dry run of backup redis
+func planRedisBackup(api *root.KubernetesAPI, ns string, target string, label string, secretName string, disableScale bool, mode string, port int, l string, f string) (*root.Plan, error) {
+	log.Println("planRedisBackup function called.")
+
+	plan := root.NewPlan("Plan for backup redis in namespace %s", ns)
+
+	// the password is checked but never printed
+	password, err := root.GetRedisPassword(api, secretName, ns)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing the redis password. %w", err)
+	}
+
+	podName, err := root.GetDeployPod(api, target, ns, label)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the redis pod. %w", err)
+	}
+
+	if !disableScale {
+		err = root.PlanScaleDown(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backupFile := filepath.Join(l, f)
+	switch mode {
+	case "sync":
+		plan.Step("forward port %d of pod %s and pull the RDB snapshot with SYNC", port, podName).
+			Detail("saves the snapshot to %s", backupFile).
+			Detail(describeLocalFile(backupFile))
+
+	default:
+		keys, err := root.RedisKeyCount(api, ns, podName, password)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := root.RedisConfigGet(api, ns, podName, password, "dir")
+		if err != nil {
+			return nil, err
+		}
+		dbfilename, err := root.RedisConfigGet(api, ns, podName, password, "dbfilename")
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Step("run BGSAVE in pod %s and wait for LASTSAVE to change", podName).
+			Detail("redis currently holds %d key(s)", keys)
+		plan.Step("record the key count in %s", root.RedisKeyCountFile(backupFile))
+		plan.Step("copy %s/%s from pod %s to %s", dir, dbfilename, podName, backupFile).
+			Detail(describeLocalFile(backupFile))
+	}
+
+	if !disableScale {
+		err = root.PlanScaleUp(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// counts the objects and their total size in the minio bucket
+func countMinioObjects(ctx context.Context, o *root.ObjectStorage) (int, int64, error) {
+	log.Println("countMinioObjects function called.")
+
+	minioClient, err := minio.New(strings.Replace(o.Endpoint, "http://", "", 1), &minio.Options{
+		Creds:  credentials.NewStaticV4(o.AccessKey, o.SecretKey, ""),
+		Secure: false,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error connecting to minio. %w", err)
+	}
+
+	var (
+		count int
+		size  int64
+	)
+	for object := range minioClient.ListObjects(ctx, o.BucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return 0, 0, fmt.Errorf("error listing the objects in bucket %v. %w", o.BucketName, object.Err)
+		}
+		count++
+		size += object.Size
+	}
+	return count, size, nil
+}
+
+// describes what would happen to the local file "f"
+func describeLocalFile(f string) string {
+	info, err := os.Stat(f)
+	if err != nil {
+		return fmt.Sprintf("%s does not exist yet", f)
+	}
+	return fmt.Sprintf("%s exists (%s) and would be replaced", f, root.HumanBytes(info.Size()))
+}
@@ -4,18 +4,12 @@ Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
 package backup
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"io"
 	"log"
 	"os"
 
 	root "github.com/dilerous/cnvrgctl/cmd"
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/remotecommand"
 )
 
 // databaseCmd represents the database command
@@ -57,38 +51,53 @@ Examples:
 		// connect to kubernetes and define clientset and rest client
 		api, err := root.ConnectToK8s()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error connecting to the cluster, check your connectivity. %v", err)
-			log.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
 		}
 
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planPostgresBackup(api, nsFlag, targetFlag, labelFlag, disableScaleFlag, fileLocationFlag, fileNameFlag)
+			if err != nil {
+				root.Fatalf("error planning the backup. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
 		// scale down the application pods to prepare for backups
 		if !disableScaleFlag {
 			err = root.ScaleDeployDown(api, nsFlag)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error scaling the deployment. %v", err)
-				log.Fatalf("error scaling the deployment. %v\n", err)
+				root.Fatalf("error scaling the deployment. %v", err)
 			}
 		}
 
 		// get the pod name from the deployment defined
 		podName, err := root.GetDeployPod(api, targetFlag, nsFlag, labelFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting the pod name check the deployment label, namespace and target. %v", err)
-			log.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
+			root.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
 		}
 
 		// execute the backup of the target postgres deployment
 		err = executePostgresBackup(api, podName, nsFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error executing the backup, check the logs. %v", err)
-			log.Fatalf("error executing the backup, check the logs. %v\n", err)
+			root.Fatalf("error executing the backup, check the logs. %v", err)
 		}
 
 		// copy the postgres backup to the local machine
 		result, err = copyDBLocally(api, nsFlag, podName, fileLocationFlag, fileNameFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error copying the database file. %v", err)
-			log.Fatalf("error copying the database file. %v\n", err)
+			root.Fatalf("error copying the database file. %v", err)
 		} else {
 			fmt.Printf("postgres backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
 			log.Printf("postgres backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
@@ -126,9 +135,8 @@ func init() {
 // pg_dump on the postgres pod
 func executePostgresBackup(api *root.KubernetesAPI, pod string, nsFlag string) error {
 	log.Println("executePostgresBackup function called.")
-	// set variables for the clientset and pod name
+	// set variables for the pod name and namespace
 	var (
-		clientset = api.Client
 		podName   = pod
 		namespace = nsFlag
 	)
@@ -140,42 +148,11 @@ func executePostgresBackup(api *root.KubernetesAPI, pod string, nsFlag string) e
 		"export PGPASSWORD=$POSTGRESQL_PASSWORD; echo $POSTGRESQL_PASSWORD; pg_dump -h postgres -U cnvrg -d cnvrg_production -Fc > cnvrg-db-backup.sql",
 	}
 
-	// rest request to send command to pod
-	req := clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
-			Stdin:   false,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     false,
-		}, scheme.ParameterCodec)
-
-	// Execute the command in the pod
-	executor, err := remotecommand.NewSPDYExecutor(api.Config, "POST", req.URL())
-	if err != nil {
-		log.Printf("here was an error executing the commands in the pod. %v\n", err)
-		return fmt.Errorf("here was an error executing the commands in the pod. %w", err)
-	}
-
-	// Prepare the streams for stdout and stderr
-	stdout := os.Stdout
-	stderr := os.Stderr
-
-	// stream the output of the command to stdout and stderr
-	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    false,
-	})
+	// stream the output of the command to stdout and stderr, a failed pg_dump returns its exit status
+	err := root.PodExec(api.Context(), api, namespace, podName, "", nil, os.Stdout, os.Stderr, command...)
 	if err != nil {
-		log.Printf("there was an error streaming the output of the command to stdout, stderr. %v\n", err)
-		return fmt.Errorf("there was an error streaming the output of the command to stdout, stderr. %w", err)
+		log.Printf("there was an error running pg_dump in the pod. %v\n", err)
+		return fmt.Errorf("there was an error running pg_dump in the pod. %w", err)
 	}
 
 	//TODO add in a check if the file exits here cnvrg-db-backup.sql
@@ -194,9 +171,7 @@ func copyDBLocally(api *root.KubernetesAPI, ns string, p string, l string, f str
 		namespace  = ns
 		filePath   = l + "/"
 		backupFile = f
-		clientset  = api.Client
 		command    = []string{"cat", backupFile}
-		config     = api.Config
 	)
 
 	// If the file path is not the local directory, create the directory
@@ -208,62 +183,34 @@ func copyDBLocally(api *root.KubernetesAPI, ns string, p string, l string, f str
 		}
 	}
 
-	// Create a REST client
-	log.Println("Creating the rest client call")
-	req := clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
-			Stdin:   false,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     false,
-		}, scheme.ParameterCodec)
-
-	// execute the command
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if err != nil {
-		log.Printf("error executing the remote command. %v\n", err)
-		return false, fmt.Errorf("error execuuting the remote command. %w", err)
-	}
-
-	// set the variables to type byte and stream the output to those variables
-	var stdout, stderr bytes.Buffer
-
-	// execute the command
-	exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: &stdout,
-		Stderr: &stderr,
-	})
-
-	// Create a local file to write to
-	localFile, err := os.Create(filePath + backupFile)
+	// write to a temporary file so a failed copy doesn't leave a truncated backup
+	// or replace a good one
+	localPath := filePath + backupFile
+	localFile, err := os.Create(localPath + ".tmp")
 	if err != nil {
 		log.Printf("error creating local file. %v\n", err)
 		return false, fmt.Errorf("error creating local file. %w", err)
 	}
 	defer localFile.Close()
 
-	// open the file that was just created
-	file, err := os.OpenFile(filePath+backupFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// stream the output of the cat command to the file
+	err = root.PodExec(api.Context(), api, namespace, podName, "", nil, localFile, nil, command...)
 	if err != nil {
-		log.Printf("opening the file failed. %s\n", err)
-		return false, fmt.Errorf("opening the file failed. %w", err)
+		os.Remove(localPath + ".tmp")
+		log.Printf("the copy failed. %v", err)
+		return false, fmt.Errorf("the copy failed. %w", err)
 	}
-	defer file.Close()
 
-	// copy the stream output from the cat command to the file.
-	_, err = io.Copy(file, &stdout)
+	err = localFile.Close()
 	if err != nil {
-		log.Printf("the copy failed. %v", err)
-		return false, fmt.Errorf("the copy failed. %w", err)
+		os.Remove(localPath + ".tmp")
+		return false, fmt.Errorf("error closing the file %s. %w", localPath, err)
 	}
 
+	err = os.Rename(localPath+".tmp", localPath)
+	if err != nil {
+		return false, fmt.Errorf("error renaming the backup file %s. %w", localPath, err)
+	}
 	return true, nil
 }
 
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+)
+
+func TestCopyDBLocally(t *testing.T) {
+	exec := &root.FakeExecutor{Handler: func(call root.FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+		if call.Command[1] != "cnvrg-db-backup.sql" {
+			fmt.Fprintf(stderr, "cat: %s: No such file or directory", call.Command[1])
+			return 1
+		}
+		fmt.Fprint(stdout, "PGDMP")
+		return 0
+	}}
+	api := &root.KubernetesAPI{Exec: exec}
+	dir := t.TempDir()
+
+	ok, err := copyDBLocally(api, "cnvrg", "postgres-0", dir, "cnvrg-db-backup.sql")
+	if err != nil || !ok {
+		t.Fatalf("error copying the backup: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "cnvrg-db-backup.sql"))
+	if err != nil || string(b) != "PGDMP" {
+		t.Fatalf("expected the dump to be written to the local file, got %q %v", string(b), err)
+	}
+
+	// a missing dump in the pod fails the copy with the exit status
+	_, err = copyDBLocally(api, "cnvrg", "postgres-0", dir, "missing.sql")
+	var exitErr *root.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected the copy to fail with exit status 1, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.sql")); !os.IsNotExist(err) {
+		t.Fatalf("expected no backup file after a failed copy, got %v", err)
+	}
+
+	// a copy failing half way keeps the previous backup, no truncated file is left
+	failing := &root.FakeExecutor{Handler: func(call root.FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+		fmt.Fprint(stdout, "PG")
+		fmt.Fprint(stderr, "connection reset")
+		return 137
+	}}
+	_, err = copyDBLocally(&root.KubernetesAPI{Exec: failing}, "cnvrg", "postgres-0", dir, "cnvrg-db-backup.sql")
+	if err == nil {
+		t.Fatal("expected the copy to fail")
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "cnvrg-db-backup.sql"))
+	if err != nil || string(b) != "PGDMP" {
+		t.Fatalf("expected the previous backup to be kept, got %q %v", string(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cnvrg-db-backup.sql.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be removed, got %v", err)
+	}
+}
@@ -4,35 +4,52 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package backup
 
 import (
-	"context"
+	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	root "github.com/dilerous/cnvrgctl/cmd"
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/remotecommand"
 )
 
 // redisCmd represents the redis command
 var redisCmd = &cobra.Command{
 	Use:   "redis",
 	Short: "Backup the Redis database",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Backs up the Redis database by running a BGSAVE in the running redis pod,
+waiting for the save to complete and streaming the RDB file to the local machine.
+The location of the RDB file is read from the redis 'dir' and 'dbfilename' settings.
+Redis with AOF enabled is supported, the backup is always an RDB file. This command
+will scale down the cnvrg.io application, so use during a downtime window.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+Hardened redis images without redis-cli or a shell can be backed up with '--mode sync'.
+The redis port is forwarded and the RDB snapshot is pulled with the replication
+protocol (SYNC), nothing is executed in the container.
+
+Examples:
+
+# Backups the redis database in the cnvrg namespace to ./dump.rdb.
+  cnvrgctl backup redis -n cnvrg
+
+# Specify the deployment label key, deployment name and backup location.
+  cnvrgctl backup redis --target redis --label app -f ./backups -n cnvrg
+
+# Backup redis over a port-forward without executing anything in the pod.
+  cnvrgctl backup redis --mode sync -n cnvrg`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("redis command called")
 
 		// grab the namespace from the -n flag if not specified default is used
 		nsFlag, _ := cmd.Flags().GetString("namespace")
 
-		// grab the namespace from the -n flag if not specified default is used
+		// name of the secret with the redis password
 		redisSecretName, _ := cmd.Flags().GetString("secret-name")
 
 		// Define the key of the deployment label for the redis deployment
@@ -50,43 +67,94 @@ to quickly create a Cobra application.`,
 		// flag to disable scaling the pods before the backup
 		disableScaleFlag, _ := cmd.Flags().GetBool("disable-scale")
 
+		// time to wait for the background save to finish
+		timeoutFlag, _ := cmd.Flags().GetDuration("timeout")
+
+		// backup using exec or the replication protocol over a port-forward
+		modeFlag, _ := cmd.Flags().GetString("mode")
+		portFlag, _ := cmd.Flags().GetInt("port")
+		if modeFlag != "exec" && modeFlag != "sync" {
+			root.Fatalf("unknown mode %s, the mode must be exec or sync.", modeFlag)
+		}
+
 		// connect to kubernetes and define clientset and rest client
 		api, err := root.ConnectToK8s()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error connecting to the cluster, check your connectivity. %v", err)
-			log.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planRedisBackup(api, nsFlag, targetFlag, labelFlag, redisSecretName, disableScaleFlag, modeFlag, portFlag, fileLocationFlag, fileNameFlag)
+			if err != nil {
+				root.Fatalf("error planning the backup. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
 		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
 
 		// capture the redis password
 		password, err := root.GetRedisPassword(api, redisSecretName, nsFlag)
 		if err != nil {
-			fmt.Printf("error capturing the redis password, check the namespace and secret exists. %v", err)
-			log.Printf("error capturing the redis password, check the namespace and secret exists. %v", err)
+			root.Fatalf("error capturing the redis password, check the namespace and secret exists. %v", err)
 		}
 
 		// get the name of the running redis pod
 		podName, err := root.GetDeployPod(api, targetFlag, nsFlag, labelFlag)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting the pod name check the deployment label, namespace and target. %v", err)
-			log.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
+			root.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
 		}
 
-		// connect to the redis pod and execute the backup
-		err = executeRedisBackup(api, podName, nsFlag, password)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error executing the backup, check the logs. %v", err)
-			log.Fatalf("error executing the backup, check the logs. %v\n", err)
+		// scale down the application pods so sidekiq stops writing to redis
+		if !disableScaleFlag {
+			err = root.ScaleDeployDown(api, nsFlag)
+			if err != nil {
+				root.Fatalf("error scaling the deployment. %v", err)
+			}
 		}
 
-		result, err := copyDBLocally(api, nsFlag, podName, fileLocationFlag, fileNameFlag)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error copying the database file. %v", err)
-			log.Fatalf("error copying the database file. %v\n", err)
-
-		} else {
-			fmt.Printf("postgres backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
-			log.Printf("postgres backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
+		result := false
+		switch modeFlag {
+		case "sync":
+			// pull the rdb snapshot over a port-forward
+			err = syncRedisBackup(api, nsFlag, podName, password, portFlag, fileLocationFlag, fileNameFlag)
+			if err != nil {
+				root.Fatalf("error executing the backup, check the logs. %v", err)
+			}
+			result = true
+
+		default:
+			// connect to the redis pod and execute the backup
+			err = executeRedisBackup(api, podName, nsFlag, password, timeoutFlag)
+			if err != nil {
+				root.Fatalf("error executing the backup, check the logs. %v", err)
+			}
+
+			// record the number of keys so the restore can be verified
+			err = saveRedisKeyCount(api, nsFlag, podName, password, fileLocationFlag, fileNameFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error recording the number of redis keys. %v", err)
+				log.Printf("error recording the number of redis keys. %v\n", err)
+			}
+
+			// stream the rdb file from the redis data directory
+			result, err = copyRDBLocally(api, nsFlag, podName, password, fileLocationFlag, fileNameFlag)
+			if err != nil {
+				root.Fatalf("error copying the database file. %v", err)
+			}
 		}
+		fmt.Printf("redis backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
+		log.Printf("redis backup %s saved to %s.\n", fileNameFlag, fileLocationFlag)
 
 		//If the backup is successful and disable-scale flag is false, scale back up the pods
 		if result && !disableScaleFlag {
@@ -115,66 +183,310 @@ func init() {
 
 	// flag to define the release name
 	redisCmd.Flags().StringP("secret-name", "", "redis-creds", "Define the secret name for the Redis credentials.")
+
+	// flag to define how long to wait for the background save
+	redisCmd.Flags().DurationP("timeout", "", 10*time.Minute, "Time to wait for the redis background save to complete.")
+
+	// flag to select how the backup is taken
+	redisCmd.Flags().StringP("mode", "", "exec", "How to backup redis. exec runs redis-cli in the pod, sync pulls the RDB over a port-forward with SYNC.")
+
+	// flag to define the redis port used in sync mode
+	redisCmd.Flags().IntP("port", "", 6379, "The redis port in the pod, used with --mode sync.")
 }
 
-// Executes a backup of Redis by executing the commands from within the pod
-// takes the arguments pod name "n" the namespace "ns" and the redis password "p"
-func executeRedisBackup(api *root.KubernetesAPI, n string, ns string, p string) error {
-	log.Println("executePostgresBackup function called.")
-	// set variables for the clientset and pod name
+// how often the redis save status is polled
+var redisPollInterval = time.Second
+
+// Executes a backup of Redis by running BGSAVE in the pod and polling LASTSAVE until the save completes
+// takes the arguments pod name "n" the namespace "ns", the redis password "p" and the time to wait "timeout"
+func executeRedisBackup(api *root.KubernetesAPI, n string, ns string, p string, timeout time.Duration) error {
+	log.Println("executeRedisBackup function called.")
+
+	// set variables for the pod name, namespace and password
 	var (
-		clientset = api.Client
 		podName   = n
 		namespace = ns
 		password  = p
 	)
 
-	// Command to save a redis backup of the database
-	command := []string{
-		"sh",
-		"-c",
-		"redis-cli -a %s save;", password,
-	}
-
-	// rest request to send command to pod
-	req := clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
-			Stdin:   false,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     false,
-		}, scheme.ParameterCodec)
-
-	// Execute the command in the pod
-	executor, err := remotecommand.NewSPDYExecutor(api.Config, "POST", req.URL())
-	if err != nil {
-		log.Printf("here was an error executing the commands in the pod. %v\n", err)
-		return fmt.Errorf("here was an error executing the commands in the pod. %w", err)
-	}
-
-	// Prepare the streams for stdout and stderr
-	stdout := os.Stdout
-	stderr := os.Stderr
-
-	// stream the output of the command to stdout and stderr
-	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    false,
-	})
-	if err != nil {
-		log.Printf("there was an error streaming the output of the command to stdout, stderr. %v\n", err)
-		return fmt.Errorf("there was an error streaming the output of the command to stdout, stderr. %w", err)
-	}
-
-	//TODO add in a check if the file exits here cnvrg-db-backup.sql
-	fmt.Println("Redis DB Backup successful!")
+	// wait for any save or AOF rewrite that is already running
+	err := waitForRedisPersistence(api, namespace, podName, password, timeout)
+	if err != nil {
+		return err
+	}
+
+	// time of the last successful save
+	lastSave, err := redisLastSave(api, namespace, podName, password)
+	if err != nil {
+		return err
+	}
+
+	// LASTSAVE has a one second resolution, make sure the new save can't share a second with the last one
+	serverTime, err := root.RedisCLI(api, namespace, podName, password, "TIME")
+	if err == nil && strings.HasPrefix(serverTime, strconv.FormatInt(lastSave, 10)) {
+		time.Sleep(time.Second)
+	}
+
+	// SCHEDULE queues the save if an AOF rewrite starts in between, instead of failing
+	out, err := root.RedisCLI(api, namespace, podName, password, "BGSAVE", "SCHEDULE")
+	if err != nil {
+		log.Printf("error starting the redis background save. %v\n", err)
+		return fmt.Errorf("error starting the redis background save. %w", err)
+	}
+	fmt.Println(out)
+	log.Println(out)
+
+	// a scheduled save starts once the AOF rewrite is done, until then the last status is
+	// the one of an older save
+	started := strings.Contains(out, "started")
+
+	// poll the save status and LASTSAVE until it changes
+	fmt.Println("waiting for the redis background save to complete...")
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(redisPollInterval)
+
+		// a failed save doesn't change LASTSAVE, fail as soon as redis reports it
+		info, err := root.RedisInfo(api, namespace, podName, password, "persistence")
+		if err != nil {
+			return err
+		}
+		if info["rdb_bgsave_in_progress"] == "1" {
+			started = true
+			continue
+		}
+		if started && info["rdb_last_bgsave_status"] == "err" {
+			return fmt.Errorf("the redis background save failed with status err, check the redis logs")
+		}
+
+		save, err := redisLastSave(api, namespace, podName, password)
+		if err != nil {
+			return err
+		}
+		if save <= lastSave {
+			continue
+		}
+
+		// LASTSAVE only changes on success, double check the status reported by redis
+		if status := info["rdb_last_bgsave_status"]; status != "ok" {
+			return fmt.Errorf("the redis background save failed with status %s, check the redis logs", status)
+		}
+
+		fmt.Println("Redis DB Backup successful!")
+		log.Println("Redis DB Backup successful!")
+		return nil
+	}
+	return fmt.Errorf("the redis background save did not complete within %v", timeout)
+}
+
+// Waits until redis is not running a background save or AOF rewrite
+func waitForRedisPersistence(api *root.KubernetesAPI, ns string, p string, password string, timeout time.Duration) error {
+	log.Println("waitForRedisPersistence function called.")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := root.RedisInfo(api, ns, p, password, "persistence")
+		if err != nil {
+			return err
+		}
+
+		if info["rdb_bgsave_in_progress"] != "1" && info["aof_rewrite_in_progress"] != "1" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("redis is still running a background save or AOF rewrite after %v", timeout)
+		}
+
+		fmt.Println("redis is running a background save or AOF rewrite, waiting...")
+		time.Sleep(redisPollInterval)
+	}
+}
+
+// Returns the unix time of the last successful redis save
+func redisLastSave(api *root.KubernetesAPI, ns string, p string, password string) (int64, error) {
+	out, err := root.RedisCLI(api, ns, p, password, "LASTSAVE")
+	if err != nil {
+		return 0, fmt.Errorf("error getting the redis LASTSAVE. %w", err)
+	}
+
+	lastSave, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing the redis LASTSAVE reply %q. %w", out, err)
+	}
+	return lastSave, nil
+}
+
+// Streams the rdb file from the redis data directory to the local machine. The path is read
+// from the redis config with CONFIG GET dir and dbfilename. Takes the location "l" and the file name "f"
+func copyRDBLocally(api *root.KubernetesAPI, ns string, p string, password string, l string, f string) (bool, error) {
+	log.Println("copyRDBLocally function called.")
+
+	// find where redis saved the rdb file
+	dir, err := root.RedisConfigGet(api, ns, p, password, "dir")
+	if err != nil {
+		return false, err
+	}
+	dbFilename, err := root.RedisConfigGet(api, ns, p, password, "dbfilename")
+	if err != nil {
+		return false, err
+	}
+	rdbPath := path.Join(dir, dbFilename)
+
+	// create the local directory if needed
+	err = createDirectory(l)
+	if err != nil {
+		return false, err
+	}
+
+	// write to a temporary file so a failed copy doesn't replace a good backup
+	backupFile := filepath.Join(l, f)
+	file, err := os.Create(backupFile + ".tmp")
+	if err != nil {
+		log.Printf("error creating local file. %v\n", err)
+		return false, fmt.Errorf("error creating local file. %w", err)
+	}
+	defer file.Close()
+
+	// stream the file out of the pod
+	fmt.Printf("copying %s from pod %s...\n", rdbPath, p)
+	err = root.RedisStream(api, ns, p, password, nil, file, "cat", rdbPath)
+	if err != nil {
+		os.Remove(backupFile + ".tmp")
+		log.Printf("error copying %s from the pod. %v\n", rdbPath, err)
+		return false, fmt.Errorf("error copying %s from the pod. %w", rdbPath, err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return false, fmt.Errorf("error closing the file %s. %w", backupFile, err)
+	}
+
+	// check the file looks like an rdb file before replacing the backup
+	err = checkRDBHeader(backupFile + ".tmp")
+	if err != nil {
+		os.Remove(backupFile + ".tmp")
+		return false, err
+	}
+
+	err = os.Rename(backupFile+".tmp", backupFile)
+	if err != nil {
+		return false, fmt.Errorf("error renaming the backup file %s. %w", backupFile, err)
+	}
+	return true, nil
+}
+
+// Checks the file "f" starts with the REDIS magic string of an rdb file
+func checkRDBHeader(f string) error {
+	file, err := os.Open(f)
+	if err != nil {
+		return fmt.Errorf("error opening the backup file %s. %w", f, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 5)
+	_, err = io.ReadFull(bufio.NewReader(file), header)
+	if err != nil || string(header) != "REDIS" {
+		log.Printf("the backup file %s is not a valid rdb file. %v", f, err)
+		return fmt.Errorf("the backup file %s is not a valid rdb file", f)
+	}
+	return nil
+}
+
+// Records the number of keys in redis next to the backup file, restore redis compares
+// the restored keys against this value. Takes the location "l" and file name "f" of the backup
+func saveRedisKeyCount(api *root.KubernetesAPI, ns string, p string, password string, l string, f string) error {
+	log.Println("saveRedisKeyCount function called.")
+
+	keys, expires, err := root.RedisKeyCounts(api, ns, p, password)
+	if err != nil {
+		return err
+	}
+	return writeRedisKeyCount(l, f, keys, expires)
+}
+
+// Writes the number of keys "keys" and of keys with a TTL "expires" to the key count file of the
+// backup "f" in the location "l"
+func writeRedisKeyCount(l string, f string, keys int64, expires int64) error {
+	err := createDirectory(l)
+	if err != nil {
+		return err
+	}
+
+	keyFile := filepath.Join(l, root.RedisKeyCountFile(f))
+	err = os.WriteFile(keyFile, []byte(fmt.Sprintf("%d %d\n", keys, expires)), 0644)
+	if err != nil {
+		log.Printf("error writing the key count file %s. %v", keyFile, err)
+		return fmt.Errorf("error writing the key count file %s. %w", keyFile, err)
+	}
+
+	fmt.Printf("recorded %d redis keys, %d with a TTL, in %s.\n", keys, expires, keyFile)
+	log.Printf("recorded %d redis keys, %d with a TTL, in %s.\n", keys, expires, keyFile)
+	return nil
+}
+
+// Backs up redis without executing anything in the pod. The redis port "port" is forwarded and the
+// rdb snapshot is pulled with SYNC, the same way a replica does. Takes the location "l" and file name "f"
+func syncRedisBackup(api *root.KubernetesAPI, ns string, p string, password string, port int, l string, f string) error {
+	log.Println("syncRedisBackup function called.")
+
+	// forward a local port to the redis pod
+	pf, err := root.ForwardPodPort(api, ns, p, port)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+
+	conn, err := root.DialRedis(pf.Address(), password, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// record the number of keys so the restore can be verified
+	keys, expires, err := conn.KeyCounts()
+	if err != nil {
+		return err
+	}
+	err = writeRedisKeyCount(l, f, keys, expires)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file so a failed copy doesn't replace a good backup
+	backupFile := filepath.Join(l, f)
+	file, err := os.Create(backupFile + ".tmp")
+	if err != nil {
+		log.Printf("error creating local file. %v\n", err)
+		return fmt.Errorf("error creating local file. %w", err)
+	}
+	defer file.Close()
+
+	fmt.Printf("requesting a snapshot from redis pod %s...\n", p)
+	written, err := conn.Sync(file)
+	if err != nil {
+		os.Remove(backupFile + ".tmp")
+		log.Printf("error syncing the rdb from redis. %v\n", err)
+		return fmt.Errorf("error syncing the rdb from redis. %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("error closing the file %s. %w", backupFile, err)
+	}
+
+	// check the file looks like an rdb file before replacing the backup
+	err = checkRDBHeader(backupFile + ".tmp")
+	if err != nil {
+		os.Remove(backupFile + ".tmp")
+		return err
+	}
+
+	err = os.Rename(backupFile+".tmp", backupFile)
+	if err != nil {
+		return fmt.Errorf("error renaming the backup file %s. %w", backupFile, err)
+	}
+
+	fmt.Printf("Redis DB Backup successful! %d bytes received.\n", written)
+	log.Printf("Redis DB Backup successful! %d bytes received.\n", written)
 	return nil
 }
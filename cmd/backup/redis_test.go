@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+)
+
+func TestExecuteRedisBackup(t *testing.T) {
+	redisPollInterval = 10 * time.Millisecond
+	defer func() { redisPollInterval = time.Second }()
+
+	testCases := []struct {
+		name string
+		// the INFO persistence replies after BGSAVE, the last one repeats
+		infos    []string
+		lastSave string
+		ok       bool
+	}{
+		{"saved", []string{"rdb_bgsave_in_progress:1", "rdb_bgsave_in_progress:0\nrdb_last_bgsave_status:ok"}, "200", true},
+		{"failed", []string{"rdb_bgsave_in_progress:1", "rdb_bgsave_in_progress:0\nrdb_last_bgsave_status:err"}, "100", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			saving := false
+			polls := 0
+			exec := &root.FakeExecutor{Handler: func(call root.FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+				switch strings.Join(call.Command[4:], " ") {
+				case "INFO persistence":
+					if !saving {
+						fmt.Fprint(stdout, "rdb_bgsave_in_progress:0\naof_rewrite_in_progress:0\nrdb_last_bgsave_status:ok\n")
+						return 0
+					}
+					fmt.Fprint(stdout, tc.infos[min(polls, len(tc.infos)-1)])
+					polls++
+				case "LASTSAVE":
+					if saving {
+						fmt.Fprint(stdout, tc.lastSave)
+					} else {
+						fmt.Fprint(stdout, "100")
+					}
+				case "TIME":
+					fmt.Fprint(stdout, "150\n0")
+				case "BGSAVE SCHEDULE":
+					saving = true
+					fmt.Fprint(stdout, "Background saving started")
+				default:
+					fmt.Fprintf(stderr, "unexpected command %v", call.Command)
+					return 1
+				}
+				return 0
+			}}
+
+			// a failed save is reported straight away instead of waiting for the timeout
+			start := time.Now()
+			err := executeRedisBackup(&root.KubernetesAPI{Exec: exec}, "redis-0", "cnvrg", "secret", time.Minute)
+			if tc.ok && err != nil {
+				t.Fatalf("expected the backup to succeed: %v", err)
+			}
+			if !tc.ok && (err == nil || !strings.Contains(err.Error(), "status err")) {
+				t.Fatalf("expected the failed save to be reported, got %v", err)
+			}
+			if time.Since(start) > 10*time.Second {
+				t.Fatalf("expected the save status within a few polls, took %v", time.Since(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// the context of the running command, cancelled on the first SIGINT or SIGTERM
+var rootCtx, cancelRootCtx = context.WithCancel(context.Background())
+
+// Returns the context of the running command, it is cancelled when cnvrgctl is interrupted.
+// Pass it to anything that talks to the cluster, a pod or object storage
+func Context() context.Context {
+	return rootCtx
+}
+
+// Returns the context for requests made with the api, cancelled when cnvrgctl is interrupted.
+// A nil api or one not created by ConnectToK8s uses context.Background()
+func (api *KubernetesAPI) Context() context.Context {
+	if api == nil || api.Ctx == nil {
+		return context.Background()
+	}
+	return api.Ctx
+}
+
+// Returns a copy of the api that makes its requests with "ctx". Cleanups use it with
+// context.Background() so they still reach the cluster after an interrupt
+func (api *KubernetesAPI) WithContext(ctx context.Context) *KubernetesAPI {
+	c := *api
+	c.Ctx = ctx
+	return &c
+}
+
+// Cancels the command context on SIGINT or SIGTERM, runs the cleanup stack and exits with 130.
+// A second signal while cleaning up exits straight away
+func handleSignals() {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		fmt.Fprintf(os.Stderr, "\nreceived %v, cleaning up. Interrupt again to exit without cleaning up.\n", sig)
+		log.Printf("received %v, cancelling the command and running the cleanup.\n", sig)
+		cancelRootCtx()
+
+		// exit without waiting for the cleanup on the second signal
+		go func() {
+			<-sigCh
+			fmt.Fprintln(os.Stderr, "exiting without cleaning up. Run \"cnvrgctl scale restore\" to scale the app back up, the next run may need --break-lock.")
+			log.Println("received a second signal, exiting without cleaning up.")
+			os.Exit(130)
+		}()
+
+		RunCleanups()
+		log.Println("cleanup finished after the interrupt, exiting.")
+		os.Exit(130)
+	}()
+}
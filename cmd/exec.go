@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// PodExecutor runs commands in pods, SPDYExecutor talks to the cluster and FakeExecutor is used in tests
+type PodExecutor interface {
+	// Runs the command in the pod "pod" in namespace "ns". Returns an *ExitError when the command
+	// ran but exited with a non-zero status
+	Exec(ctx context.Context, ns string, pod string, opts ExecOptions) error
+}
+
+// ExecOptions is the command to run in a pod and where its input and output go
+type ExecOptions struct {
+	// the container to run in, empty uses the default container of the pod
+	Container string
+	Command   []string
+
+	// streamed to the command, nil runs it without stdin
+	Stdin io.Reader
+
+	// nil discards the output, stderr is also collected for the ExitError
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// stops the command after this long, 0 runs it until the context is cancelled
+	Timeout time.Duration
+}
+
+// ExitError is returned when the command ran in the pod but exited with a non-zero status
+type ExitError struct {
+	Pod     string
+	Command []string
+	Code    int
+	Stderr  string
+}
+
+// the command itself isn't printed, it can hold passwords
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("the command in pod %s exited with status %d", e.Pod, e.Code)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// SPDYExecutor runs commands in pods through the exec subresource of the api server, like kubectl exec
+type SPDYExecutor struct {
+	Client kubernetes.Interface
+	Config *rest.Config
+}
+
+func (e *SPDYExecutor) Exec(ctx context.Context, ns string, pod string, opts ExecOptions) error {
+	log.Println("SPDYExecutor Exec function called.")
+
+	// rest request to send command to pod
+	req := e.Client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(pod).
+		Namespace(ns).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+			TTY:       false,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.Config, "POST", req.URL())
+	if err != nil {
+		log.Printf("there was an error executing the commands in the pod. %v\n", err)
+		return fmt.Errorf("there was an error executing the commands in the pod. %w", err)
+	}
+
+	return runExec(ctx, pod, opts, func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		// stream the output of the command to stdout and stderr
+		return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  opts.Stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+			Tty:    false,
+		})
+	})
+}
+
+// Applies the timeout, collects stderr and turns a non-zero exit status returned by "stream"
+// into an *ExitError. Shared by SPDYExecutor and FakeExecutor so both behave the same
+func runExec(ctx context.Context, pod string, opts ExecOptions, stream func(ctx context.Context, stdout io.Writer, stderr io.Writer) error) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var collected bytes.Buffer
+	stderr := io.Writer(&collected)
+	if opts.Stderr != nil {
+		stderr = io.MultiWriter(opts.Stderr, &collected)
+	}
+
+	err := stream(ctx, stdout, stderr)
+	if err == nil {
+		return nil
+	}
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Pod: pod, Command: opts.Command, Code: exitErr.ExitStatus(), Stderr: strings.TrimSpace(collected.String())}
+	}
+	if opts.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the command in pod %s did not finish within %v. %w", pod, opts.Timeout, err)
+	}
+	return fmt.Errorf("error streaming the command in pod %s. %w", pod, err)
+}
+
+// Returns the executor of the api, the SPDY executor for the api clients if none is set
+func (api *KubernetesAPI) Executor() PodExecutor {
+	if api.Exec != nil {
+		return api.Exec
+	}
+	return &SPDYExecutor{Client: api.Client, Config: api.Config}
+}
+
+// Executes "command" in the container "c" of the pod "p" in namespace "ns", an empty container uses
+// the default container of the pod. stdin can be nil, stdout and stderr are streamed to the writers passed
+func PodExec(ctx context.Context, api *KubernetesAPI, ns string, p string, c string, stdin io.Reader, stdout io.Writer, stderr io.Writer, command ...string) error {
+	log.Println("PodExec function called.")
+
+	return api.Executor().Exec(ctx, ns, p, ExecOptions{
+		Container: c,
+		Command:   command,
+		Stdin:     stdin,
+		Stdout:    stdout,
+		Stderr:    stderr,
+	})
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// FakeExecutor is a PodExecutor for tests. It records every command and answers it with Handler
+// instead of running it in a pod
+type FakeExecutor struct {
+	// writes the output of the command and returns its exit status, nil exits 0 without output
+	Handler func(call FakeExecCall, stdout io.Writer, stderr io.Writer) int
+
+	mu    sync.Mutex
+	calls []FakeExecCall
+}
+
+// FakeExecCall is a command run with a FakeExecutor, stdin is read in full before Handler is called
+type FakeExecCall struct {
+	Namespace string
+	Pod       string
+	Container string
+	Command   []string
+	Stdin     []byte
+}
+
+func (f *FakeExecutor) Exec(ctx context.Context, ns string, pod string, opts ExecOptions) error {
+	call := FakeExecCall{Namespace: ns, Pod: pod, Container: opts.Container, Command: opts.Command}
+	if opts.Stdin != nil {
+		b, err := io.ReadAll(opts.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading stdin. %w", err)
+		}
+		call.Stdin = b
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	return runExec(ctx, pod, opts, func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.Handler == nil {
+			return nil
+		}
+		// the same error the SPDY executor returns for a non-zero exit status
+		if code := f.Handler(call, stdout, stderr); code != 0 {
+			return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code %d", code), Code: code}
+		}
+		return nil
+	})
+}
+
+// Returns the commands run so far in order
+func (f *FakeExecutor) Calls() []FakeExecCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeExecCall(nil), f.calls...)
+}
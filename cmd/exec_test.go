@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	exec := &FakeExecutor{Handler: func(call FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+		switch call.Command[0] {
+		case "cat":
+			fmt.Fprint(stdout, string(call.Stdin))
+			return 0
+		default:
+			fmt.Fprintln(stderr, "sh: not found")
+			return 127
+		}
+	}}
+	api := &KubernetesAPI{Exec: exec}
+
+	// stdin is passed to the command and stdout is streamed back
+	var stdout bytes.Buffer
+	err := PodExec(context.Background(), api, "cnvrg", "redis-0", "redis", strings.NewReader("hello"), &stdout, nil, "cat")
+	if err != nil {
+		t.Fatalf("error running cat: %v", err)
+	}
+	if stdout.String() != "hello" {
+		t.Fatalf("expected the output hello, got %q", stdout.String())
+	}
+
+	// a non-zero exit status is an ExitError carrying stderr
+	var stderr bytes.Buffer
+	err = PodExec(context.Background(), api, "cnvrg", "redis-0", "", nil, nil, &stderr, "missing")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+	if exitErr.Code != 127 || exitErr.Stderr != "sh: not found" || exitErr.Pod != "redis-0" {
+		t.Fatalf("unexpected ExitError %+v", exitErr)
+	}
+	if stderr.String() != "sh: not found\n" {
+		t.Fatalf("expected stderr to be streamed too, got %q", stderr.String())
+	}
+
+	// a cancelled context stops the command before it runs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = PodExec(ctx, api, "cnvrg", "redis-0", "", nil, nil, nil, "cat")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the command to be cancelled, got %v", err)
+	}
+
+	expected := []FakeExecCall{
+		{Namespace: "cnvrg", Pod: "redis-0", Container: "redis", Command: []string{"cat"}, Stdin: []byte("hello")},
+		{Namespace: "cnvrg", Pod: "redis-0", Command: []string{"missing"}},
+		{Namespace: "cnvrg", Pod: "redis-0", Command: []string{"cat"}},
+	}
+	if !reflect.DeepEqual(exec.Calls(), expected) {
+		t.Fatalf("expected the calls %+v, got %+v", expected, exec.Calls())
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+var (
+	// guards the cleanup stack
+	stackMu      sync.Mutex
+	cleanupStack []*cleanup
+
+	// held while the cleanup stack runs so a failure during an interrupt waits for the cleanup to finish
+	cleanupMu sync.Mutex
+)
+
+// a function on the cleanup stack, compared by pointer when it is popped
+type cleanup struct {
+	f func()
+}
+
+// Pushes "f" on the cleanup stack, it runs if the command fails with Fatalf or is interrupted.
+// Call the returned function once "f" isn't needed anymore, it removes "f" without running it.
+// "f" runs after the command context is cancelled, use context.Background() for anything it
+// sends to the cluster and never call Fatalf from it
+func PushCleanup(f func()) (pop func()) {
+	c := &cleanup{f: f}
+
+	stackMu.Lock()
+	cleanupStack = append(cleanupStack, c)
+	stackMu.Unlock()
+
+	return func() {
+		stackMu.Lock()
+		defer stackMu.Unlock()
+		for i, s := range cleanupStack {
+			if s == c {
+				cleanupStack = append(cleanupStack[:i], cleanupStack[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Registers "f" to run if the command fails with Fatalf or is interrupted, it stays on the cleanup
+// stack until the command exits
+func OnFailure(f func()) {
+	PushCleanup(f)
+}
+
+// Runs the cleanup stack in reverse order, called by Fatalf and when cnvrgctl is interrupted.
+// The stack is emptied first so the functions only run once
+func RunCleanups() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	// take the functions so a failure inside one of them doesn't run them again
+	stackMu.Lock()
+	funcs := cleanupStack
+	cleanupStack = nil
+	stackMu.Unlock()
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i].f()
+	}
+}
+
+// Prints the error to stderr, runs the cleanup stack and exits through log.Fatal so the error
+// is also in the log file. Use it instead of log.Fatalf in commands that change the cluster
+func Fatalf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	fmt.Fprintln(os.Stderr, msg)
+
+	RunCleanups()
+	log.Fatal(msg)
+}
+
+// Registers the on-failure hooks for namespace "ns", called by the backup and restore commands
+func RunHooksOnFailure(api *KubernetesAPI, ns string) {
+	OnFailure(func() {
+		// the command context may be cancelled by an interrupt
+		err := RunHooks(api.WithContext(context.Background()), ns, PhaseOnFailure)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			log.Printf("%v\n", err)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPushCleanup(t *testing.T) {
+	cleanupStack = nil
+
+	var ran []string
+	PushCleanup(func() { ran = append(ran, "first") })
+	pop := PushCleanup(func() { ran = append(ran, "popped") })
+	OnFailure(func() { ran = append(ran, "last") })
+
+	// a popped cleanup never runs
+	pop()
+	pop()
+
+	// the stack runs in reverse order and only once
+	RunCleanups()
+	RunCleanups()
+
+	expected := []string{"last", "first"}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("expected the cleanups %v to run, got %v", expected, ran)
+	}
+}
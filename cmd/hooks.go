@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// phases of a backup or restore hooks can run at
+const (
+	PhasePreQuiesce  = "pre-quiesce"
+	PhasePostQuiesce = "post-quiesce"
+	PhasePreRestore  = "pre-restore"
+	PhasePostRestore = "post-restore"
+	PhaseOnFailure   = "on-failure"
+)
+
+// types of hooks
+const (
+	HookExec  = "exec"
+	HookLocal = "local"
+	HookHTTP  = "http"
+)
+
+// failure policies of a hook, fail stops the backup or restore and continue only logs the error
+const (
+	HookFail     = "fail"
+	HookContinue = "continue"
+)
+
+// how long a hook can run when no timeout is set
+const defaultHookTimeout = time.Minute
+
+// Hook is a user defined action from the hooks section of the config file, example:
+//
+//	hooks:
+//	  - name: pause-ingestion
+//	    phase: pre-quiesce
+//	    type: http
+//	    url: https://ingest.example.com/pause
+//	    timeout: 30s
+//	    onError: continue
+type Hook struct {
+	Name  string `mapstructure:"name"`
+	Phase string `mapstructure:"phase"`
+	Type  string `mapstructure:"type"`
+
+	// exec runs the command in the first running pod matching the selector, local runs it on this machine
+	Selector  string   `mapstructure:"selector"`
+	Namespace string   `mapstructure:"namespace"`
+	Container string   `mapstructure:"container"`
+	Command   []string `mapstructure:"command"`
+
+	// http sends the body to the url, a json description of the run is sent if the body is empty
+	URL     string            `mapstructure:"url"`
+	Method  string            `mapstructure:"method"`
+	Headers map[string]string `mapstructure:"headers"`
+	Body    string            `mapstructure:"body"`
+
+	Timeout time.Duration `mapstructure:"timeout"`
+	OnError string        `mapstructure:"onError"`
+}
+
+// Reads and checks the hooks from the config file
+func GetHooks() ([]Hook, error) {
+	hooks := []Hook{}
+
+	err := viper.UnmarshalKey("hooks", &hooks)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the hooks from the config file. %w", err)
+	}
+
+	for i, h := range hooks {
+		if h.Name == "" {
+			hooks[i].Name = fmt.Sprintf("hook-%d", i+1)
+		}
+
+		switch h.Phase {
+		case PhasePreQuiesce, PhasePostQuiesce, PhasePreRestore, PhasePostRestore, PhaseOnFailure:
+		default:
+			return nil, fmt.Errorf("hook %v has an unknown phase %q", hooks[i].Name, h.Phase)
+		}
+
+		switch h.Type {
+		case HookExec:
+			if h.Selector == "" || len(h.Command) == 0 {
+				return nil, fmt.Errorf("exec hook %v needs a selector and a command", hooks[i].Name)
+			}
+		case HookLocal:
+			if len(h.Command) == 0 {
+				return nil, fmt.Errorf("local hook %v needs a command", hooks[i].Name)
+			}
+		case HookHTTP:
+			if h.URL == "" {
+				return nil, fmt.Errorf("http hook %v needs a url", hooks[i].Name)
+			}
+		default:
+			return nil, fmt.Errorf("hook %v has an unknown type %q, the type must be exec, local or http", hooks[i].Name, h.Type)
+		}
+
+		switch h.OnError {
+		case "":
+			hooks[i].OnError = HookFail
+		case HookFail, HookContinue:
+		default:
+			return nil, fmt.Errorf("hook %v has an unknown onError policy %q, the policy must be fail or continue", hooks[i].Name, h.OnError)
+		}
+
+		if h.Timeout == 0 {
+			hooks[i].Timeout = defaultHookTimeout
+		}
+	}
+	return hooks, nil
+}
+
+// Runs the hooks for "phase" in the order they are defined. The output of each hook is written to the
+// log file. Returns an error from the first hook that fails with the fail policy
+func RunHooks(api *KubernetesAPI, ns string, phase string) error {
+	log.Printf("RunHooks function called for phase %v.\n", phase)
+
+	hooks, err := GetHooks()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if h.Phase != phase {
+			continue
+		}
+
+		fmt.Printf("running %s hook %s...\n", phase, h.Name)
+		err = runHook(api, ns, h)
+		if err == nil {
+			fmt.Printf("%s hook %s finished.\n", phase, h.Name)
+			continue
+		}
+
+		if h.OnError == HookContinue {
+			fmt.Fprintf(os.Stderr, "%s hook %s failed, continuing. %v\n", phase, h.Name, err)
+			log.Printf("%s hook %s failed, continuing. %v\n", phase, h.Name, err)
+			continue
+		}
+		return fmt.Errorf("%s hook %s failed. %w", phase, h.Name, err)
+	}
+	return nil
+}
+
+// runs a single hook with its timeout and writes the output to the log file
+func runHook(api *KubernetesAPI, ns string, h Hook) error {
+	ctx, cancel := context.WithTimeout(api.Context(), h.Timeout)
+	defer cancel()
+
+	var (
+		out bytes.Buffer
+		err error
+	)
+
+	switch h.Type {
+	case HookExec:
+		err = runExecHook(ctx, api, ns, h, &out)
+	case HookLocal:
+		err = runLocalHook(ctx, ns, h, &out)
+	case HookHTTP:
+		err = runHTTPHook(ctx, ns, h, &out)
+	}
+
+	// capture the hook output in the run log
+	log.Printf("output of the %s hook %s:\n%s", h.Phase, h.Name, out.String())
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v. %w", h.Timeout, err)
+	}
+	return err
+}
+
+// runs the hook command in the first running pod matching the selector
+func runExecHook(ctx context.Context, api *KubernetesAPI, ns string, h Hook, out io.Writer) error {
+	namespace := ns
+	if h.Namespace != "" {
+		namespace = h.Namespace
+	}
+
+	pods, err := api.Client.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: h.Selector})
+	if err != nil {
+		return fmt.Errorf("error listing the pods matching %v. %w", h.Selector, err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		log.Printf("running the %s hook %s in pod %s.\n", h.Phase, h.Name, pod.Name)
+		return PodExec(ctx, api, namespace, pod.Name, h.Container, nil, out, out, h.Command...)
+	}
+	return fmt.Errorf("no running pods match the selector %v in namespace %v", h.Selector, namespace)
+}
+
+// runs the hook command on this machine, the namespace and phase are passed as env variables
+func runLocalHook(ctx context.Context, ns string, h Hook, out io.Writer) error {
+	c := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	c.Env = append(os.Environ(), "CNVRGCTL_NAMESPACE="+ns, "CNVRGCTL_PHASE="+h.Phase, "CNVRGCTL_HOOK="+h.Name)
+	c.Stdout = out
+	c.Stderr = out
+	return c.Run()
+}
+
+// calls the hook url and fails on a non 2xx response
+func runHTTPHook(ctx context.Context, ns string, h Hook, out io.Writer) error {
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	// describe the run if no body is set
+	body := h.Body
+	if body == "" {
+		b, err := json.Marshal(map[string]string{
+			"hook":      h.Name,
+			"phase":     h.Phase,
+			"namespace": ns,
+			"user":      Identity(),
+			"time":      time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating the request body. %w", err)
+		}
+		body = string(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating the request to %v. %w", h.URL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %v. %w", h.URL, err)
+	}
+	defer resp.Body.Close()
+
+	// keep the start of the response for the log
+	io.Copy(out, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v returned %v", h.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetHooks(t *testing.T) {
+	defer viper.Set("hooks", nil)
+
+	viper.Set("hooks", []map[string]interface{}{
+		{"phase": "pre-quiesce", "type": "local", "command": []string{"true"}, "timeout": "5s"},
+		{"name": "notify", "phase": "on-failure", "type": "http", "url": "http://example.com", "onError": "continue"},
+	})
+	hooks, err := GetHooks()
+	if err != nil {
+		t.Fatalf("error reading the hooks: %v", err)
+	}
+	if hooks[0].Name != "hook-1" || hooks[0].Timeout != 5*time.Second || hooks[0].OnError != HookFail {
+		t.Fatalf("unexpected defaults for the first hook %+v", hooks[0])
+	}
+	if hooks[1].Timeout != defaultHookTimeout || hooks[1].OnError != HookContinue {
+		t.Fatalf("unexpected defaults for the second hook %+v", hooks[1])
+	}
+
+	testCases := []struct {
+		name string
+		hook map[string]interface{}
+	}{
+		{name: "unknown_phase", hook: map[string]interface{}{"phase": "pre-backup", "type": "local", "command": []string{"true"}}},
+		{name: "unknown_type", hook: map[string]interface{}{"phase": "pre-restore", "type": "ssh"}},
+		{name: "exec_without_selector", hook: map[string]interface{}{"phase": "pre-restore", "type": "exec", "command": []string{"true"}}},
+		{name: "http_without_url", hook: map[string]interface{}{"phase": "pre-restore", "type": "http"}},
+		{name: "unknown_policy", hook: map[string]interface{}{"phase": "pre-restore", "type": "local", "command": []string{"true"}, "onError": "retry"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("hooks", []map[string]interface{}{test.hook})
+			if _, err := GetHooks(); err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	defer viper.Set("hooks", nil)
+
+	// the http hook gets a json description of the run
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	viper.Set("hooks", []map[string]interface{}{
+		{"name": "notify", "phase": "pre-restore", "type": "http", "url": server.URL + "/notify"},
+		{"name": "local", "phase": "pre-restore", "type": "local", "command": []string{"sh", "-c", "test \"$CNVRGCTL_NAMESPACE\" = cnvrg"}},
+		{"name": "broken", "phase": "post-restore", "type": "http", "url": server.URL + "/fail", "onError": "continue"},
+		{"name": "slow", "phase": "on-failure", "type": "local", "command": []string{"sleep", "5"}, "timeout": "100ms"},
+	})
+
+	err := RunHooks(nil, "cnvrg", PhasePreRestore)
+	if err != nil {
+		t.Fatalf("error running the pre-restore hooks: %v", err)
+	}
+	if received["hook"] != "notify" || received["phase"] != PhasePreRestore || received["namespace"] != "cnvrg" {
+		t.Fatalf("unexpected request body %v", received)
+	}
+
+	// the failing hook has the continue policy
+	err = RunHooks(nil, "cnvrg", PhasePostRestore)
+	if err != nil {
+		t.Fatalf("expected the failure to be ignored, got %v", err)
+	}
+
+	err = RunHooks(nil, "cnvrg", PhaseOnFailure)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
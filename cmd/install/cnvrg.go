@@ -0,0 +1,586 @@
+/*
+Copyright © 2024 Brad Soper BRADLEY.SOPER@CNVRG.IO
+*/
+package install
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// how often the CnvrgApp and its custom resource definition are checked while waiting
+var cnvrgPollInterval = 10 * time.Second
+
+// the ArgoCD applications deploying the cnvrg operator with --app
+var argoApplicationResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+
+// the deployments of the control plane that must be available once the CnvrgApp is ready
+var controlPlaneWorkloads = []root.Workload{
+	{Kind: root.KindDeployment, Name: "app"},
+	{Kind: root.KindDeployment, Name: "sidekiq"},
+}
+
+// cnvrgCmd represents the install cnvrg command
+var cnvrgCmd = &cobra.Command{
+	Use:   "cnvrg",
+	Short: "Installs the cnvrg operator and the cnvrg control plane",
+	Long: `Install the cnvrg operator as a Helm release, or as an ArgoCD application with --app,
+then create the CnvrgInfra and the CnvrgApp custom resources the operator deploys cnvrg
+from. The CnvrgApp is created in the namespace set with -n along with the operator.
+
+The resources are built from the flags: the cluster domain, TLS, the object storage and
+the image registry. Anything else can be set with a values file, -f, holding the spec of
+each resource and the values of the operator chart. The flags that are set win over the
+file.
+
+  operator:      # values of the operator chart
+    ...
+  cnvrgApp:      # spec of the CnvrgApp
+    clusterDomain: cnvrg.example.com
+    controlPlane:
+      objectStorage:
+        type: aws
+        bucket: cnvrg-storage
+  cnvrgInfra:    # spec of the CnvrgInfra
+    ...
+
+Once the resources are created the command waits until the CnvrgApp is ready and the
+app and sidekiq deployments are available, up to --timeout. Use --wait=false to return
+as soon as the resources are created.
+
+Usage:
+  cnvrgctl install cnvrg [flags]
+
+Examples:
+# Install cnvrg into the cnvrg namespace with the cluster domain cnvrg.example.com.
+  cnvrgctl -n cnvrg install cnvrg -d cnvrg.example.com
+
+# Install cnvrg with TLS, using the wildcard certificate in the secret cnvrg-tls.
+  cnvrgctl -n cnvrg install cnvrg -d cnvrg.example.com --enable-tls --tls-secret cnvrg-tls
+
+# Install cnvrg storing its files in an S3 bucket and pulling its images from a private registry.
+  cnvrgctl -n cnvrg install cnvrg -d cnvrg.example.com --storage-type aws --storage-bucket cnvrg-storage \
+    --storage-region us-west-2 --storage-access-key AKIA... --storage-secret-key ... \
+    --registry-url registry.example.com --registry-user cnvrg --registry-password ...
+
+# Install the operator as an ArgoCD application and the rest of the settings from a values file.
+  cnvrgctl -n cnvrg install cnvrg --app -f cnvrg-values.yaml
+
+# Print the resources that would be created without installing anything.
+  cnvrgctl -n cnvrg install cnvrg -d cnvrg.example.com --dry-run`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("install cnvrg command called")
+
+		// call the Flags structs
+		flags := root.Flags{}
+
+		// grab the namespace from the -n flag if not specified default is used
+		ns, _ := cmd.Flags().GetString("namespace")
+
+		// Flags to set the chart of the operator and how to deploy it
+		flags.Repo, _ = cmd.Flags().GetString("repo")
+		flags.ChartName, _ = cmd.Flags().GetString("chart")
+		flags.ReleaseName, _ = cmd.Flags().GetString("release")
+		flags.TargetRevision, _ = cmd.Flags().GetString("target-version")
+		flags.App, _ = cmd.Flags().GetBool("app")
+		flags.Argocd, _ = cmd.Flags().GetString("argocd-namespace")
+		flags.Values, _ = cmd.Flags().GetString("values")
+		flags.DryRun, _ = cmd.Flags().GetBool("dry-run")
+
+		// Flags to set the CnvrgApp and CnvrgInfra
+		opts := getCnvrgOptions(cmd)
+
+		wait, _ := cmd.Flags().GetBool("wait")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// build the resources first so a bad values file fails before anything is installed
+		values, err := readCnvrgValues(flags.Values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading the values file. %v\n", err)
+			log.Fatalf("error reading the values file. %v", err)
+		}
+		app, infra, err := cnvrgResources(ns, opts, values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating the cnvrg resources. %v\n", err)
+			log.Fatalf("error creating the cnvrg resources. %v", err)
+		}
+
+		// connect to kubernetes and get the client and rest api
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to kubernetes, check your connectivity. %v", err)
+			log.Fatalf("error connecting to kubernetes, check your connectivity. %v", err)
+		}
+
+		// the dry run prints the registry password and the storage keys, they are redacted
+		redactor := root.NewRedactor(opts.RegistryPassword, opts.StorageAccessKey, opts.StorageSecretKey)
+
+		// deploy the operator, as an ArgoCD application or directly with helm
+		if flags.App {
+			err = createCnvrgOperatorApp(api, ns, flags, values.Operator, redactor)
+		} else {
+			var c *chart.Chart
+			c, err = loadChart(flags)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error loading the chart, check the url and path. %v", err)
+				log.Fatalf("error loading the chart, check the url and path. %v", err)
+			}
+			err = deployHelmChart(ns, c, flags, values.Operator)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error installing the cnvrg operator, check the logs. %v\n", err)
+			log.Fatalf("error installing the cnvrg operator, check the logs. %v", err)
+		}
+
+		// with --dry-run print the resources instead of creating them
+		if flags.DryRun {
+			printResources(os.Stdout, redactor, infra, app)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(api.Context(), timeout)
+		defer cancel()
+
+		// the operator installs the custom resource definitions, ArgoCD may not have synced it yet
+		err = waitForCnvrgCRDs(ctx, &api.Dynamic)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			log.Fatalf("%v", err)
+		}
+
+		// the CnvrgInfra is cluster scoped, the CnvrgApp lives in the namespace of the control plane
+		err = applyCnvrgResource(ctx, &api.Dynamic, root.CnvrgInfraResource, infra)
+		if err == nil {
+			err = applyCnvrgResource(ctx, &api.Dynamic, root.CnvrgAppResource, app)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			log.Fatalf("%v", err)
+		}
+
+		if !wait {
+			fmt.Printf("to follow the install run:\nkubectl get cnvrgapp %s -n %s -w\n", app.GetName(), ns)
+			return
+		}
+
+		// wait until the operator reports the control plane ready and the app is serving
+		err = waitForCnvrgApp(ctx, &api.Dynamic, ns, app.GetName())
+		if err == nil {
+			// the deployments get the time left of --timeout
+			deadline, _ := ctx.Deadline()
+			err = root.WaitForRollout(api, ns, controlPlaneWorkloads, time.Until(deadline))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "the cnvrg control plane is not healthy. %v\n", err)
+			log.Fatalf("the cnvrg control plane is not healthy. %v", err)
+		}
+
+		scheme := "http"
+		if https, _, _ := unstructured.NestedBool(app.Object, "spec", "networking", "https", "enabled"); https {
+			scheme = "https"
+		}
+		domain, _, _ := unstructured.NestedString(app.Object, "spec", "clusterDomain")
+		fmt.Printf("cnvrg is ready at %s://app.%s\n", scheme, domain)
+	},
+}
+
+func init() {
+	installCmd.AddCommand(cnvrgCmd)
+
+	// flag to define the path to the chart repo
+	cnvrgCmd.Flags().StringP("repo", "", "https://charts.v3.cnvrg.io", "define the chart repository url")
+
+	// flag to define the repository chart name
+	cnvrgCmd.Flags().StringP("chart", "", "cnvrg-operator", "specify the chart name in the repository defined.")
+
+	// flag to define the release name
+	cnvrgCmd.Flags().StringP("release", "", "cnvrg-operator", "define the cnvrg operator helm release name.")
+
+	// flag to define the helm chart version of the ArgoCD application
+	cnvrgCmd.Flags().StringP("target-version", "t", "*", "define the helm chart version of the ArgoCD application, * for the latest.")
+
+	// flag to install the operator as an ArgoCD application
+	cnvrgCmd.Flags().BoolP("app", "", false, "install the cnvrg operator as an application in ArgoCD.")
+
+	// flag to define the namespace argocd is deployed
+	cnvrgCmd.Flags().StringP("argocd-namespace", "a", "argocd", "define the namespace for the argocd deployment.")
+
+	// flag to define the values file for the install
+	cnvrgCmd.Flags().StringP("values", "f", "", "a YAML file with the operator values and the spec of the cnvrgApp and cnvrgInfra.")
+
+	// flags to define the domain and tls of the cnvrg ingresses
+	cnvrgCmd.Flags().StringP("domain", "d", "", "define the cluster domain, cnvrg is served from app.<domain>.")
+	cnvrgCmd.Flags().Bool("enable-tls", false, "enable https for the cnvrg ingresses.")
+	cnvrgCmd.Flags().String("tls-secret", "", "the secret holding the wildcard certificate of the cluster domain.")
+
+	// flags to define the object storage of the control plane
+	cnvrgCmd.Flags().String("storage-type", "", "the object storage type, minio, aws, azure or gcp, the operator default when not set.")
+	cnvrgCmd.Flags().String("storage-bucket", "", "the bucket cnvrg stores its files in.")
+	cnvrgCmd.Flags().String("storage-endpoint", "", "the url of the object storage api.")
+	cnvrgCmd.Flags().String("storage-region", "", "the region of the bucket.")
+	cnvrgCmd.Flags().String("storage-access-key", "", "the access key of the bucket.")
+	cnvrgCmd.Flags().String("storage-secret-key", "", "the secret key of the bucket.")
+
+	// flags to define the registry the cnvrg images are pulled from
+	cnvrgCmd.Flags().String("registry-url", "", "the registry the cnvrg images are pulled from.")
+	cnvrgCmd.Flags().String("registry-user", "", "the user of the registry.")
+	cnvrgCmd.Flags().String("registry-password", "", "the password of the registry.")
+
+	// flags to define the names of the custom resources
+	cnvrgCmd.Flags().String("app-name", "cnvrg-app", "the name of the CnvrgApp.")
+	cnvrgCmd.Flags().String("infra-name", "cnvrg-infra", "the name of the CnvrgInfra.")
+
+	// flags to wait for the control plane
+	cnvrgCmd.Flags().Bool("wait", true, "wait until the cnvrg control plane is healthy.")
+	cnvrgCmd.Flags().Duration("timeout", 30*time.Minute, "how long to wait for the cnvrg control plane to become healthy.")
+
+	// Flag to perform a dry run of the install
+	cnvrgCmd.Flags().BoolP("dry-run", "", false, "Perform a dry run of the install and print the cnvrg resources.")
+
+	cnvrgCmd.MarkFlagsRequiredTogether("storage-access-key", "storage-secret-key")
+	cnvrgCmd.MarkFlagsRequiredTogether("registry-user", "registry-password")
+}
+
+// cnvrgOptions are the settings of the CnvrgApp and CnvrgInfra set with the flags, the empty
+// ones are left to the values file or the operator defaults
+type cnvrgOptions struct {
+	AppName   string
+	InfraName string
+
+	Domain    string
+	TLS       bool
+	TLSSecret string
+
+	StorageType      string
+	StorageBucket    string
+	StorageEndpoint  string
+	StorageRegion    string
+	StorageAccessKey string
+	StorageSecretKey string
+
+	RegistryURL      string
+	RegistryUser     string
+	RegistryPassword string
+}
+
+// reads the cnvrg resource flags of the install cnvrg command
+func getCnvrgOptions(cmd *cobra.Command) cnvrgOptions {
+	var o cnvrgOptions
+	o.AppName, _ = cmd.Flags().GetString("app-name")
+	o.InfraName, _ = cmd.Flags().GetString("infra-name")
+	o.Domain, _ = cmd.Flags().GetString("domain")
+	o.TLS, _ = cmd.Flags().GetBool("enable-tls")
+	o.TLSSecret, _ = cmd.Flags().GetString("tls-secret")
+	o.StorageType, _ = cmd.Flags().GetString("storage-type")
+	o.StorageBucket, _ = cmd.Flags().GetString("storage-bucket")
+	o.StorageEndpoint, _ = cmd.Flags().GetString("storage-endpoint")
+	o.StorageRegion, _ = cmd.Flags().GetString("storage-region")
+	o.StorageAccessKey, _ = cmd.Flags().GetString("storage-access-key")
+	o.StorageSecretKey, _ = cmd.Flags().GetString("storage-secret-key")
+	o.RegistryURL, _ = cmd.Flags().GetString("registry-url")
+	o.RegistryUser, _ = cmd.Flags().GetString("registry-user")
+	o.RegistryPassword, _ = cmd.Flags().GetString("registry-password")
+	return o
+}
+
+// cnvrgValues is the values file of install cnvrg
+type cnvrgValues struct {
+	// the values of the operator chart
+	Operator map[string]interface{} `json:"operator"`
+
+	// the spec of the CnvrgApp and the CnvrgInfra
+	CnvrgApp   map[string]interface{} `json:"cnvrgApp"`
+	CnvrgInfra map[string]interface{} `json:"cnvrgInfra"`
+}
+
+// reads the values file, no values when "file" is empty
+func readCnvrgValues(file string) (cnvrgValues, error) {
+	log.Println("readCnvrgValues function called.")
+
+	var values cnvrgValues
+	if file == "" {
+		return values, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return values, fmt.Errorf("error reading the values file %v. %w", file, err)
+	}
+	err = yaml.UnmarshalStrict(data, &values)
+	if err != nil {
+		return values, fmt.Errorf("error parsing the values file %v, the keys are operator, cnvrgApp and cnvrgInfra. %w", file, err)
+	}
+	return values, nil
+}
+
+// Returns the CnvrgApp in namespace "ns" and the CnvrgInfra, their spec is the one of the values
+// file with the flags set on top. The cluster domain must be set by one or the other
+func cnvrgResources(ns string, o cnvrgOptions, values cnvrgValues) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	log.Println("cnvrgResources function called.")
+
+	// the settings shared by both resources
+	common := map[string]interface{}{}
+	if o.Domain != "" {
+		common["clusterDomain"] = o.Domain
+	}
+	if o.TLS || o.TLSSecret != "" {
+		https := map[string]interface{}{"enabled": true}
+		if o.TLSSecret != "" {
+			https["certSecret"] = o.TLSSecret
+		}
+		common["networking"] = map[string]interface{}{"https": https}
+	}
+	registry := setValues(map[string]interface{}{}, "url", o.RegistryURL, "user", o.RegistryUser, "password", o.RegistryPassword)
+	if len(registry) > 0 {
+		common["registry"] = registry
+	}
+
+	// the object storage is only used by the control plane
+	appFlags := mergeValues(map[string]interface{}{}, common)
+	storage := setValues(map[string]interface{}{},
+		"type", o.StorageType,
+		"bucket", o.StorageBucket,
+		"endpoint", o.StorageEndpoint,
+		"region", o.StorageRegion,
+		"accessKey", o.StorageAccessKey,
+		"secretKey", o.StorageSecretKey)
+	if len(storage) > 0 {
+		appFlags["controlPlane"] = map[string]interface{}{"objectStorage": storage}
+	}
+
+	appSpec := mergeValues(mergeValues(map[string]interface{}{}, values.CnvrgApp), appFlags)
+	infraSpec := mergeValues(mergeValues(map[string]interface{}{}, values.CnvrgInfra), common)
+
+	if domain, _, _ := unstructured.NestedString(appSpec, "clusterDomain"); domain == "" {
+		return nil, nil, fmt.Errorf("the cluster domain is not set, set it with --domain or clusterDomain in the values file")
+	}
+	// the infrastructure is served from the same domain unless the values file says otherwise
+	if _, ok := infraSpec["clusterDomain"]; !ok {
+		infraSpec["clusterDomain"] = appSpec["clusterDomain"]
+	}
+
+	app := &unstructured.Unstructured{Object: map[string]interface{}{"spec": appSpec}}
+	app.SetGroupVersionKind(schema.GroupVersionKind{Group: root.CnvrgAppResource.Group, Version: root.CnvrgAppResource.Version, Kind: "CnvrgApp"})
+	app.SetName(o.AppName)
+	app.SetNamespace(ns)
+
+	infra := &unstructured.Unstructured{Object: map[string]interface{}{"spec": infraSpec}}
+	infra.SetGroupVersionKind(schema.GroupVersionKind{Group: root.CnvrgInfraResource.Group, Version: root.CnvrgInfraResource.Version, Kind: "CnvrgInfra"})
+	infra.SetName(o.InfraName)
+
+	return app, infra, nil
+}
+
+// sets the key value pairs "kv" in "m" whose value isn't empty
+func setValues(m map[string]interface{}, kv ...string) map[string]interface{} {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			m[kv[i]] = kv[i+1]
+		}
+	}
+	return m
+}
+
+// merges "override" into "base" key by key, the nested maps are merged and anything else in
+// "override" replaces the value in "base"
+func mergeValues(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		if vm, ok := v.(map[string]interface{}); ok {
+			if bm, ok := base[k].(map[string]interface{}); ok {
+				base[k] = mergeValues(bm, vm)
+				continue
+			}
+			base[k] = mergeValues(map[string]interface{}{}, vm)
+			continue
+		}
+		base[k] = v
+	}
+	return base
+}
+
+// Creates the custom resource "obj" of "gvr", or replaces the spec of the existing one so the
+// install can be run again with new settings
+func applyCnvrgResource(ctx context.Context, dyn dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	log.Println("applyCnvrgResource function called.")
+
+	client := dyn.Resource(gvr).Namespace(obj.GetNamespace())
+	_, err := client.Create(ctx, obj, v1.CreateOptions{})
+	if err == nil {
+		fmt.Printf("created %s %s.\n", obj.GetKind(), obj.GetName())
+		log.Printf("created %s %s.\n", obj.GetKind(), obj.GetName())
+		return nil
+	}
+	if !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating %s %s. %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	existing, err := client.Get(ctx, obj.GetName(), v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting %s %s. %w", obj.GetKind(), obj.GetName(), err)
+	}
+	existing.Object["spec"] = obj.Object["spec"]
+	_, err = client.Update(ctx, existing, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error updating %s %s. %w", obj.GetKind(), obj.GetName(), err)
+	}
+	fmt.Printf("updated %s %s.\n", obj.GetKind(), obj.GetName())
+	log.Printf("updated %s %s.\n", obj.GetKind(), obj.GetName())
+	return nil
+}
+
+// waits until the api server serves the CnvrgApp and CnvrgInfra resources
+func waitForCnvrgCRDs(ctx context.Context, dyn dynamic.Interface) error {
+	log.Println("waitForCnvrgCRDs function called.")
+
+	for _, gvr := range []schema.GroupVersionResource{root.CnvrgInfraResource, root.CnvrgAppResource} {
+		for {
+			_, err := dyn.Resource(gvr).List(ctx, v1.ListOptions{Limit: 1})
+			if err == nil {
+				break
+			}
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("error checking the %s custom resource definition. %w", gvr.Resource, err)
+			}
+			fmt.Printf("waiting for the operator to install the %s custom resource definition...\n", gvr.Resource)
+			if err := sleep(ctx, fmt.Sprintf("the %s custom resource definition", gvr.Resource)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Waits until the operator sets the status of the CnvrgApp "name" in namespace "ns" to ready,
+// the status and the progress are printed as they change
+func waitForCnvrgApp(ctx context.Context, dyn dynamic.Interface, ns string, name string) error {
+	log.Println("waitForCnvrgApp function called.")
+
+	var last string
+	for {
+		app, err := dyn.Resource(root.CnvrgAppResource).Namespace(ns).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out waiting for CnvrgApp %s to become ready, increase --timeout if it needs longer. %w", name, err)
+			}
+			return fmt.Errorf("error getting CnvrgApp %s. %w", name, err)
+		}
+
+		status, _, _ := unstructured.NestedString(app.Object, "status", "status")
+		progress, _, _ := unstructured.NestedFieldNoCopy(app.Object, "status", "progress")
+		message, _, _ := unstructured.NestedString(app.Object, "status", "message")
+
+		// only print the status when it changes
+		current := fmt.Sprintf("CnvrgApp %s: %s", name, status)
+		if status == "" {
+			current = fmt.Sprintf("CnvrgApp %s: waiting for the operator", name)
+		}
+		if progress != nil {
+			current += fmt.Sprintf(" %v%%", progress)
+		}
+		if message != "" {
+			current += " " + message
+		}
+		if current != last {
+			fmt.Println(current)
+			log.Println(current)
+			last = current
+		}
+
+		if strings.EqualFold(status, "READY") {
+			return nil
+		}
+		if err := sleep(ctx, fmt.Sprintf("CnvrgApp %s to become ready", name)); err != nil {
+			return err
+		}
+	}
+}
+
+// waits for the poll interval, or returns an error naming what was waited for when "ctx" is done
+func sleep(ctx context.Context, waitingFor string) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for %s, increase --timeout if it needs longer. %w", waitingFor, ctx.Err())
+	case <-time.After(cnvrgPollInterval):
+		return nil
+	}
+}
+
+// deploy the cnvrg operator using argocd, the application is updated when it already exists.
+// A dry run prints the application with the secrets of "r" redacted
+func createCnvrgOperatorApp(api *root.KubernetesAPI, ns string, f root.Flags, vals map[string]interface{}, r *root.Redactor) error {
+	log.Println("createCnvrgOperatorApp function called")
+
+	if vals == nil {
+		vals = map[string]interface{}{}
+	}
+
+	// define the application yaml
+	app := &unstructured.Unstructured{}
+	app.Object = map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name":      f.ReleaseName,
+			"namespace": f.Argocd,
+		},
+
+		"spec": map[string]interface{}{
+			"project": "default",
+			"source": map[string]interface{}{
+				"chart":          f.ChartName,
+				"repoURL":        f.Repo,
+				"targetRevision": f.TargetRevision,
+				"helm": map[string]interface{}{
+					"releaseName":  f.ReleaseName,
+					"valuesObject": vals,
+				},
+			},
+			"destination": map[string]interface{}{
+				"server":    "https://kubernetes.default.svc",
+				"namespace": ns,
+			},
+			"syncPolicy": map[string]interface{}{
+				"automated":   map[string]interface{}{},
+				"syncOptions": []interface{}{"CreateNamespace=true"},
+			},
+		},
+	}
+
+	// define the custom resource schema
+	app.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "argoproj.io",
+		Version: "v1alpha1",
+		Kind:    "Application",
+	})
+
+	// print the application instead of creating it for a dry run
+	if f.DryRun {
+		printResources(os.Stdout, r, app)
+		return nil
+	}
+
+	// apply the cnvrg operator application, running the install again updates it
+	return applyCnvrgResource(api.Context(), &api.Dynamic, argoApplicationResource, app)
+}
+
+// Prints the resources "objs" as yaml documents to "w", the secrets are redacted with "r"
+func printResources(w io.Writer, r *root.Redactor, objs ...*unstructured.Unstructured) {
+	for _, obj := range objs {
+		data, _ := yaml.Marshal(obj.Object)
+		fmt.Fprintf(w, "---\n%s", r.Redact(obj.GetKind(), string(data)))
+	}
+}
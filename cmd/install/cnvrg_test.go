@@ -0,0 +1,212 @@
+package install
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestCnvrgResources(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	err := os.WriteFile(file, []byte(`
+operator:
+  image: registry.example.com/cnvrg-operator
+cnvrgApp:
+  clusterDomain: file.example.com
+  controlPlane:
+    objectStorage:
+      type: aws
+      bucket: from-file
+    webapp:
+      replicas: 2
+cnvrgInfra:
+  infraNamespace: cnvrg-infra
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := readCnvrgValues(file)
+	if err != nil {
+		t.Fatalf("values not read: %v", err)
+	}
+	if values.Operator["image"] != "registry.example.com/cnvrg-operator" {
+		t.Fatalf("unexpected operator values %v", values.Operator)
+	}
+
+	// the flags that are set win over the file, the rest of the file is kept
+	o := cnvrgOptions{
+		AppName:          "cnvrg-app",
+		InfraName:        "cnvrg-infra",
+		Domain:           "cnvrg.example.com",
+		TLSSecret:        "cnvrg-tls",
+		StorageBucket:    "cnvrg-storage",
+		RegistryURL:      "registry.example.com",
+		RegistryUser:     "cnvrg",
+		RegistryPassword: "secret",
+	}
+	app, infra, err := cnvrgResources("cnvrg", o, values)
+	if err != nil {
+		t.Fatalf("resources not created: %v", err)
+	}
+
+	expected := []struct {
+		obj   *unstructured.Unstructured
+		path  []string
+		value interface{}
+	}{
+		{app, []string{"spec", "clusterDomain"}, "cnvrg.example.com"},
+		{app, []string{"spec", "networking", "https", "enabled"}, true},
+		{app, []string{"spec", "networking", "https", "certSecret"}, "cnvrg-tls"},
+		{app, []string{"spec", "controlPlane", "objectStorage", "type"}, "aws"},
+		{app, []string{"spec", "controlPlane", "objectStorage", "bucket"}, "cnvrg-storage"},
+		{app, []string{"spec", "controlPlane", "webapp", "replicas"}, float64(2)},
+		{app, []string{"spec", "registry", "user"}, "cnvrg"},
+		{app, []string{"metadata", "namespace"}, "cnvrg"},
+		{infra, []string{"spec", "clusterDomain"}, "cnvrg.example.com"},
+		{infra, []string{"spec", "infraNamespace"}, "cnvrg-infra"},
+		{infra, []string{"spec", "registry", "url"}, "registry.example.com"},
+	}
+	for _, e := range expected {
+		got, _, _ := unstructured.NestedFieldNoCopy(e.obj.Object, e.path...)
+		if got != e.value {
+			t.Errorf("%s: expected %v at %v, got %v", e.obj.GetKind(), e.value, e.path, got)
+		}
+	}
+	if _, ok, _ := unstructured.NestedFieldNoCopy(infra.Object, "spec", "controlPlane"); ok {
+		t.Error("expected the object storage to only be set on the CnvrgApp")
+	}
+	if infra.GetNamespace() != "" || infra.GetKind() != "CnvrgInfra" {
+		t.Errorf("expected a cluster scoped CnvrgInfra, got %v %v", infra.GetKind(), infra.GetNamespace())
+	}
+
+	// the domain comes from the flag or the file
+	if _, _, err := cnvrgResources("cnvrg", cnvrgOptions{AppName: "cnvrg-app"}, cnvrgValues{}); err == nil {
+		t.Error("expected an error without a cluster domain")
+	}
+
+	// an unknown key is a typo
+	if err := os.WriteFile(file, []byte("cnvrgApps:\n  clusterDomain: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCnvrgValues(file); err == nil {
+		t.Error("expected an error for an unknown key in the values file")
+	}
+}
+
+func TestApplyAndWaitForCnvrgApp(t *testing.T) {
+	cnvrgPollInterval = 10 * time.Millisecond
+	defer func() { cnvrgPollInterval = 10 * time.Second }()
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		root.CnvrgAppResource:   "CnvrgAppList",
+		root.CnvrgInfraResource: "CnvrgInfraList",
+	})
+	ctx := context.Background()
+
+	app, _, err := cnvrgResources("cnvrg", cnvrgOptions{AppName: "cnvrg-app", Domain: "a.example.com"}, cnvrgValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForCnvrgCRDs(ctx, dyn); err != nil {
+		t.Fatalf("custom resources not served: %v", err)
+	}
+	if err := applyCnvrgResource(ctx, dyn, root.CnvrgAppResource, app); err != nil {
+		t.Fatalf("CnvrgApp not created: %v", err)
+	}
+
+	// running the install again replaces the spec
+	app, _, _ = cnvrgResources("cnvrg", cnvrgOptions{AppName: "cnvrg-app", Domain: "b.example.com"}, cnvrgValues{})
+	if err := applyCnvrgResource(ctx, dyn, root.CnvrgAppResource, app); err != nil {
+		t.Fatalf("CnvrgApp not updated: %v", err)
+	}
+	client := dyn.Resource(root.CnvrgAppResource).Namespace("cnvrg")
+	got, err := client.Get(ctx, "cnvrg-app", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain, _, _ := unstructured.NestedString(got.Object, "spec", "clusterDomain"); domain != "b.example.com" {
+		t.Fatalf("expected the spec to be updated, got %v", domain)
+	}
+
+	// the operator hasn't reconciled it yet
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := waitForCnvrgApp(short, dyn, "cnvrg", "cnvrg-app"); err == nil {
+		t.Fatal("expected a timeout before the CnvrgApp is ready")
+	}
+
+	// ready once the operator sets the status
+	unstructured.SetNestedField(got.Object, "READY", "status", "status")
+	unstructured.SetNestedField(got.Object, int64(100), "status", "progress")
+	if _, err := client.Update(ctx, got, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForCnvrgApp(ctx, dyn, "cnvrg", "cnvrg-app"); err != nil {
+		t.Fatalf("expected the CnvrgApp to be ready: %v", err)
+	}
+}
+
+func TestPrintResources(t *testing.T) {
+	o := cnvrgOptions{
+		AppName:          "cnvrg-app",
+		Domain:           "cnvrg.example.com",
+		StorageAccessKey: "storage-access",
+		StorageSecretKey: "storage-secret",
+		RegistryURL:      "registry.example.com",
+		RegistryUser:     "cnvrg",
+		RegistryPassword: "registry-pa55",
+	}
+	app, infra, err := cnvrgResources("cnvrg", o, cnvrgValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	printResources(&out, root.NewRedactor(o.RegistryPassword, o.StorageAccessKey, o.StorageSecretKey), infra, app)
+	for _, secret := range []string{"storage-access", "storage-secret", "registry-pa55"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("expected %s redacted in the dry run, got %s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "clusterDomain: cnvrg.example.com") || strings.Count(out.String(), "---\n") != 2 {
+		t.Errorf("expected both resources in the dry run, got %s", out.String())
+	}
+}
+
+func TestApplyOperatorApplication(t *testing.T) {
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		argoApplicationResource: "ApplicationList",
+	})
+	ctx := context.Background()
+
+	// running the install twice updates the application instead of failing on AlreadyExists
+	for _, revision := range []string{"5.0.0", "5.1.0"} {
+		app := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "Application",
+			"metadata":   map[string]interface{}{"name": "cnvrg-operator", "namespace": "argocd"},
+			"spec":       map[string]interface{}{"source": map[string]interface{}{"targetRevision": revision}},
+		}}
+		if err := applyCnvrgResource(ctx, dyn, argoApplicationResource, app); err != nil {
+			t.Fatalf("application not applied: %v", err)
+		}
+	}
+
+	got, err := dyn.Resource(argoApplicationResource).Namespace("argocd").Get(ctx, "cnvrg-operator", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision, _, _ := unstructured.NestedString(got.Object, "spec", "source", "targetRevision"); revision != "5.1.0" {
+		t.Fatalf("expected the application to be updated, got %v", revision)
+	}
+}
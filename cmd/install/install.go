@@ -5,6 +5,7 @@ package install
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +15,8 @@ import (
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -23,7 +26,7 @@ var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install cnvrg and supporting applications.",
 	Long: `Install ArgoCD to manage deployments of additional helm 
-charts.`,
+charts, and cnvrg with its operator, the CnvrgApp and the CnvrgInfra.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("install cmd called")
 	},
@@ -60,13 +63,12 @@ func loadChart(f root.Flags) (*chart.Chart, error) {
 }
 
 // Check if the namespace exists
-func checkNamespaceExists(ns string, clientset kubernetes.Interface) (bool, error) {
+func checkNamespaceExists(ctx context.Context, ns string, clientset kubernetes.Interface) (bool, error) {
 	// Specify the namespace you want to check
 	var namespace = ns
 
-	//TODO: play with adding cts to the KubernetesAPI struct
 	// Attempt to get the namespace object
-	_, err := clientset.CoreV1().Namespaces().Get(context.Background(), namespace, v1.GetOptions{})
+	_, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
 	if err != nil {
 		// If the namespace does not exist, an error will be returned
 		fmt.Printf("the namespace %v, doesn't exist.\n", namespace)
@@ -93,7 +95,7 @@ func deployHelmChart(ns string, c *chart.Chart, f root.Flags, vals map[string]in
 	actionConfig := new(action.Configuration)
 	// You can pass an empty string instead of settings.Namespace() to list
 	// all namespaces
-	if err := actionConfig.Init(settings.RESTClientGetter(), namespace,
+	if err := actionConfig.Init(root.RESTClientGetter(), namespace,
 		os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
 		log.Printf("%+v", err)
 	}
@@ -117,7 +119,7 @@ func deployHelmChart(ns string, c *chart.Chart, f root.Flags, vals map[string]in
 	}
 
 	// check if the namespace exists
-	exists, err := checkNamespaceExists(client.Namespace, api.Client)
+	exists, err := checkNamespaceExists(api.Context(), client.Namespace, api.Client)
 	if err != nil {
 		log.Printf("error checking if the namespace exists. %v", err)
 		return fmt.Errorf("error checking if the namespace exists. %w", err)
@@ -128,18 +130,40 @@ func deployHelmChart(ns string, c *chart.Chart, f root.Flags, vals map[string]in
 		client.CreateNamespace = true
 	}
 
-	//TODO: add ability to upgrade
-	//TODO: add flag to specify upgrade
+	// an existing release is upgraded like helm upgrade --install, so the install can run again
 	upgradeClient := action.NewUpgrade(actionConfig)
 	upgradeClient.Install = true
 	upgradeClient.Namespace = namespace
+	upgradeClient.DryRun = f.DryRun
+
+	// check if the release was installed before
+	histClient := action.NewHistory(actionConfig)
+	histClient.Max = 1
+	_, err = histClient.Run(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		log.Printf("error reading the history of the release %s. %v", releaseName, err)
+		return fmt.Errorf("error reading the history of the release %s. %w", releaseName, err)
+	}
 
-	// install the chart here
-	fmt.Printf("Installing %s please wait...\n", releaseName)
-	rel, err := client.Run(chart, vals)
-	if err != nil {
-		log.Printf("error installing the chart. %v", err)
-		return fmt.Errorf("error installing the chart. %v", err)
+	var rel *release.Release
+	if err == nil {
+		// upgrade the chart here
+		fmt.Printf("Upgrading %s please wait...\n", releaseName)
+		// the upgrade stops waiting when cnvrgctl is interrupted
+		rel, err = upgradeClient.RunWithContext(api.Context(), releaseName, chart, vals)
+		if err != nil {
+			log.Printf("error upgrading the chart. %v", err)
+			return fmt.Errorf("error upgrading the chart. %v", err)
+		}
+	} else {
+		// install the chart here
+		fmt.Printf("Installing %s please wait...\n", releaseName)
+		// the install stops waiting when cnvrgctl is interrupted
+		rel, err = client.RunWithContext(api.Context(), chart, vals)
+		if err != nil {
+			log.Printf("error installing the chart. %v", err)
+			return fmt.Errorf("error installing the chart. %v", err)
+		}
 	}
 
 	//log and print install was successful
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"time"
+	"os"
+	"strconv"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -18,7 +20,7 @@ func GetObjectSecret(api *KubernetesAPI, name string, namespace string) (*Object
 	object := ObjectStorage{}
 
 	// Get the Secret
-	secret, err := api.Client.CoreV1().Secrets(namespace).Get(context.Background(), name, v1.GetOptions{})
+	secret, err := api.Client.CoreV1().Secrets(namespace).Get(api.Context(), name, v1.GetOptions{})
 	if err != nil {
 		log.Printf("error getting the secret, does it exist? %v", err)
 		return &object, fmt.Errorf("error getting the secret, does it exist? %w ", err)
@@ -88,7 +90,7 @@ func GetRedisPassword(api *KubernetesAPI, n string, ns string) (string, error) {
 	)
 
 	// Get the Redis K8s Secret
-	secret, err := api.Client.CoreV1().Secrets(namespace).Get(context.Background(), name, v1.GetOptions{})
+	secret, err := api.Client.CoreV1().Secrets(namespace).Get(api.Context(), name, v1.GetOptions{})
 	if err != nil {
 		log.Printf("error getting the secret, does it exist? %v", err)
 		return "", fmt.Errorf("error getting the secret, does it exist? %w ", err)
@@ -118,7 +120,7 @@ func GetDeployPod(api *KubernetesAPI, targetFlag string, nsFlag string, labelTag
 	)
 
 	// Get the Pods associated with the deployment
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), v1.ListOptions{
+	pods, err := clientset.CoreV1().Pods(namespace).List(api.Context(), v1.ListOptions{
 		LabelSelector: labels.Set{label: deployName}.AsSelector().String(),
 	})
 	if err != nil {
@@ -137,89 +139,245 @@ func GetDeployPod(api *KubernetesAPI, targetFlag string, nsFlag string, labelTag
 	return podName, nil
 }
 
-// get the pod name from the deployment this will be passed to executeBackup function
-// used by back and restore commands
+// annotation used to save the number of replicas a workload had before it was scaled down
+const ReplicasAnnotation = "cnvrgctl/replicas"
+
+// removes the scale up pushed on the cleanup stack by ScaleDeployDown
+var popScaleUpFunc func()
+
+// Keeps the workloads at 0 replicas if the command fails or is interrupted after ScaleDeployDown.
+// Used when starting the app could do more harm, like after a restore that didn't load
+func KeepScaledDown() {
+	log.Println("KeepScaledDown function called.")
+	popScaleUp()
+}
+
+// removes the pending scale up from the cleanup stack, if there is one
+func popScaleUp() {
+	if popScaleUpFunc != nil {
+		popScaleUpFunc()
+		popScaleUpFunc = nil
+	}
+}
+
+// scales the workloads in the quiesce set back to the number of replicas saved by ScaleDeployDown and
+// resumes the paused HorizontalPodAutoscalers. The operator is scaled up last and the function waits for every
+// workload to be available. used by back and restore commands
 func ScaleDeployUp(api *KubernetesAPI, ns string) error {
 	log.Println("scaleDeployUp function called.")
 
 	var (
-		// Set client, deployment names and namespace
-		clientset   = api.Client
-		deployNames = []string{"app", "sidekiq", "systemkiq", "searchkiq", "cnvrg-operator"}
-		namespace   = ns
+		// Set namespace and the workloads to scale
+		namespace = ns
+		config    = GetQuiesceConfig()
 	)
 
-	// Get the deployment
-	for _, deployName := range deployNames {
+	// the workloads are scaled up here, not by the cleanup stack
+	popScaleUp()
 
-		// Get the current number of replicas for the deployment
-		s, err := clientset.AppsV1().Deployments(namespace).GetScale(context.Background(), deployName, v1.GetOptions{})
+	// include anything still carrying a saved replica count
+	workloads, err := ResolveQuiesceSet(api, namespace, config, true)
+	if err != nil {
+		fmt.Printf("there was an error finding the workloads to scale up. %v\n", err)
+		return fmt.Errorf("there was an error finding the workloads to scale up. %w", err)
+	}
+
+	// scale up in reverse so the operator comes back last
+	for i := len(workloads) - 1; i >= 0; i-- {
+		w := workloads[i]
+
+		// Get the workload annotations to read the saved number of replicas
+		annotations, err := getWorkloadAnnotations(api, namespace, w)
 		if err != nil {
-			fmt.Printf("there was an error getting the number of replicas for deployment %v, check the namespace specified is correct.\n %v", deployName, err)
-			return fmt.Errorf("there was an error getting the number of replicas for deployment %v, check the namespace specified is correct. %w", deployName, err)
+			fmt.Printf("%v\n", err)
+			return err
 		}
 
-		// create a v1.Scale object and set the replicas to 0
-		sc := *s
-		sc.Spec.Replicas = 1
+		// Get the current number of replicas for the workload
+		s, err := getWorkloadScale(api, namespace, w)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return err
+		}
+
+		// use the number of replicas saved when the workload was scaled down
+		saved, ok := annotations[ReplicasAnnotation]
+		if !ok {
+			if s.Spec.Replicas > 0 {
+				fmt.Printf("%s has no saved replica count and is running %d replica(s), leaving it as is.\n", w, s.Spec.Replicas)
+				log.Printf("%s has no saved replica count and is running %d replica(s), leaving it as is.\n", w, s.Spec.Replicas)
+				continue
+			}
+			// workloads scaled down by older versions of cnvrgctl don't have the annotation
+			fmt.Printf("%s has no saved replica count, scaling to 1 replica.\n", w)
+			log.Printf("%s has no saved replica count, scaling to 1 replica.\n", w)
+			saved = "1"
+		}
 
-		// Scale the deployment to 0
-		scale, err := clientset.AppsV1().Deployments(namespace).UpdateScale(context.Background(), deployName, &sc, v1.UpdateOptions{})
+		replicas, err := strconv.ParseInt(saved, 10, 32)
 		if err != nil {
-			fmt.Printf("there was an issue scaling the deployment %v.\n%v", deployName, err)
-			return fmt.Errorf("there was an issue scaling the deployment %v. %w", deployName, err)
+			return fmt.Errorf("the saved replica count %q for %s is not a number. %w", saved, w, err)
 		}
 
-		// Print to screen the deployments scaled to 0
-		fmt.Printf("scaled deployment %s to %d replica(s).\n", scale.Name, sc.Spec.Replicas)
-		//TODO: add check for num of replicas = 0
+		// Scale the workload up
+		err = updateWorkloadScale(api, namespace, w, s, int32(replicas))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return err
+		}
+
+		// remove the saved count now the workload is restored
+		err = patchReplicasAnnotation(api, namespace, w, nil)
+		if err != nil {
+			return err
+		}
+
+		// Print to screen the workloads scaled up
+		fmt.Printf("scaled %s to %d replica(s).\n", w, replicas)
+	}
 
+	// let the autoscalers manage the workloads again
+	err = ResumeHPAs(api, namespace)
+	if err != nil {
+		fmt.Printf("there was an error resuming the horizontal pod autoscalers. %v\n", err)
+		return err
 	}
-	//TODO: add a check if all replicas = 0
-	fmt.Println("scaled deployments back to 1 replica(s)...")
+
+	// wait for every workload to be available again
+	fmt.Println("waiting for the workloads to become available...")
+	err = WaitForRollout(api, namespace, workloads, GetWaitTimeout())
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+
+	fmt.Println("scaled workloads back to their original replica(s)...")
 	return nil
 }
 
-// scales the following deployments "app", "sidekiq", "systemkiq", "searchkiq", "cnvrg-operator" in the namespace specified
-// used in back and restore commands
+// scales the workloads in the quiesce set in the namespace specified to 0. The default set is the
+// "cnvrg-operator", "app", "sidekiq", "systemkiq" and "searchkiq" deployments, it can be changed in the
+// quiesce section of the config file or with the --quiesce-* flags. The current number of replicas is
+// saved in the cnvrgctl/replicas annotation so ScaleDeployUp can restore it and HorizontalPodAutoscalers
+// targeting the workloads are paused. Returns once all of their pods are gone. The pre-quiesce and
+// post-quiesce hooks run before and after the scale down. If the command fails with Fatalf or is
+// interrupted afterwards the cleanup stack scales the workloads back up. used in back and restore commands
 func ScaleDeployDown(api *KubernetesAPI, ns string) error {
 	log.Println("scaleDeployDown function called.")
 
 	var (
-		// Set client, deployment names and namespace
-		clientset   = api.Client
-		deployNames = []string{"app", "sidekiq", "systemkiq", "searchkiq", "cnvrg-operator"}
-		namespace   = ns
+		// Set namespace and the workloads to scale
+		namespace = ns
+		config    = GetQuiesceConfig()
 	)
 
-	// Get the deployment
-	for _, deployName := range deployNames {
+	workloads, err := ResolveQuiesceSet(api, namespace, config, false)
+	if err != nil {
+		fmt.Printf("there was an error finding the workloads to scale down. %v\n", err)
+		return fmt.Errorf("there was an error finding the workloads to scale down. %w", err)
+	}
+
+	// run the user defined hooks before anything is scaled down
+	err = RunHooks(api, namespace, PhasePreQuiesce)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
 
-		// Get the current number of replicas for the deployment
-		s, err := clientset.AppsV1().Deployments(namespace).GetScale(context.Background(), deployName, v1.GetOptions{})
+	// scale everything back up if the command fails or is interrupted from here on, ScaleDeployUp
+	// and KeepScaledDown remove it
+	popScaleUp()
+	popScaleUpFunc = PushCleanup(func() {
+		fmt.Fprintln(os.Stderr, "scaling the workloads back up...")
+		err := ScaleDeployUp(api.WithContext(context.Background()), namespace)
 		if err != nil {
-			fmt.Printf("there was an error getting the number of replicas for deployment %v, check the namespace specified is correct.\n %v", deployName, err)
-			return fmt.Errorf("there was an error getting the number of replicas for deployment %v, check the namespace specified is correct. %w", deployName, err)
+			fmt.Fprintf(os.Stderr, "error scaling the workloads back up, run \"cnvrgctl scale restore -n %s\". %v\n", namespace, err)
+			log.Printf("error scaling the workloads back up. %v\n", err)
 		}
+	})
+
+	// pause the autoscalers first so they don't fight the scale down
+	err = PauseHPAs(api, namespace, workloads)
+	if err != nil {
+		fmt.Printf("there was an error pausing the horizontal pod autoscalers. %v\n", err)
+		return err
+	}
+
+	// the operator is first in the list so it can't scale the others back up
+	for _, w := range workloads {
 
-		// create a v1.Scale object and set the replicas to 0
-		sc := *s
-		sc.Spec.Replicas = 0
+		// Get the workload annotations to check for a saved number of replicas
+		annotations, err := getWorkloadAnnotations(api, namespace, w)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return err
+		}
 
-		// Scale the deployment to 0
-		scale, err := clientset.AppsV1().Deployments(namespace).UpdateScale(context.Background(), deployName, &sc, v1.UpdateOptions{})
+		// Get the current number of replicas for the workload
+		s, err := getWorkloadScale(api, namespace, w)
 		if err != nil {
-			fmt.Printf("there was an issue scaling the deployment %v.\n%v", deployName, err)
-			return fmt.Errorf("there was an issue scaling the deployment %v. %w", deployName, err)
+			fmt.Printf("%v\n", err)
+			return err
+		}
+
+		// save the current number of replicas, unless a previous run already saved it
+		// so running the scale down twice doesn't overwrite the count with 0
+		if _, ok := annotations[ReplicasAnnotation]; !ok {
+			replicas := strconv.Itoa(int(s.Spec.Replicas))
+			err = patchReplicasAnnotation(api, namespace, w, &replicas)
+			if err != nil {
+				return err
+			}
 		}
 
-		// Print to screen the deployments scaled to 0
-		fmt.Printf("scaled deployment %s to %d replica(s).\n", scale.Name, sc.Spec.Replicas)
-		//TODO: add check for num of replicas = 0
+		// Scale the workload to 0
+		err = updateWorkloadScale(api, namespace, w, s, 0)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return err
+		}
 
+		// Print to screen the workloads scaled to 0
+		fmt.Printf("scaled %s from %d to 0 replica(s).\n", w, s.Spec.Replicas)
 	}
-	//TODO: add a check if all replicas = 0
+
+	// wait for the pods to be gone so nothing is writing during the backup or restore
 	fmt.Println("waiting for pods to finish terminating...")
-	time.Sleep(10 * time.Second)
+	err = WaitForPodsGone(api, namespace, workloads, GetWaitTimeout())
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+
+	// run the user defined hooks now the app is quiesced
+	err = RunHooks(api, namespace, PhasePostQuiesce)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return err
+	}
+	return nil
+}
+
+// Sets the cnvrgctl/replicas annotation on the workload "w" to "replicas", the annotation is removed if replicas is nil
+func patchReplicasAnnotation(api *KubernetesAPI, ns string, w Workload, replicas *string) error {
+	annotations := map[string]interface{}{ReplicasAnnotation: nil}
+	if replicas != nil {
+		annotations[ReplicasAnnotation] = *replicas
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating the annotation patch. %w", err)
+	}
+
+	err = patchWorkload(api, ns, w, patch)
+	if err != nil {
+		log.Printf("there was an issue saving the replica count on %s. %v", w, err)
+		return fmt.Errorf("there was an issue saving the replica count on %s. %w", w, err)
+	}
 	return nil
 }
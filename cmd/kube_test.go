@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// the fake clientset doesn't implement the scale subresource, these reactors
+// read and write the replicas of the deployment or statefulset in the tracker instead
+func newScaleClientset(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+
+	for _, resource := range []string{"deployments", "statefulsets"} {
+		gvr := appsv1.SchemeGroupVersion.WithResource(resource)
+
+		client.PrependReactor("get", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			get := action.(k8stesting.GetAction)
+			obj, err := client.Tracker().Get(gvr, get.GetNamespace(), get.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			meta, replicas := workloadReplicas(obj)
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: metav1.ObjectMeta{Name: meta.Name, Namespace: meta.Namespace},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: *replicas},
+			}, nil
+		})
+
+		client.PrependReactor("update", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+			obj, err := client.Tracker().Get(gvr, action.GetNamespace(), scale.Name)
+			if err != nil {
+				return true, nil, err
+			}
+			obj = obj.DeepCopyObject()
+			setWorkloadReplicas(obj, scale.Spec.Replicas)
+			return true, scale, client.Tracker().Update(gvr, obj, action.GetNamespace())
+		})
+	}
+	return client
+}
+
+// returns the metadata and a pointer to the replicas of a deployment or statefulset
+func workloadReplicas(obj runtime.Object) (*metav1.ObjectMeta, *int32) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.ObjectMeta, o.Spec.Replicas
+	case *appsv1.StatefulSet:
+		return &o.ObjectMeta, o.Spec.Replicas
+	}
+	return nil, nil
+}
+
+// sets the replicas of a deployment or statefulset, the status is updated as if the controller
+// rolled it out straight away
+func setWorkloadReplicas(obj runtime.Object, replicas int32) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		o.Spec.Replicas = &replicas
+		o.Status.Replicas, o.Status.UpdatedReplicas, o.Status.AvailableReplicas = replicas, replicas, replicas
+	case *appsv1.StatefulSet:
+		o.Spec.Replicas = &replicas
+		o.Status.Replicas, o.Status.ReadyReplicas = replicas, replicas
+	}
+}
+
+func newDeployment(name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cnvrg"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+func newStatefulSet(name string, replicas int32, labels map[string]string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cnvrg", Labels: labels},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	}
+}
+
+func newHPA(name string, kind string, target string) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cnvrg"},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: kind, Name: target},
+		},
+	}
+}
+
+func TestScaleDeployDownAndUp(t *testing.T) {
+	cleanupStack = nil
+
+	// searchkiq is optional and isn't installed
+	client := newScaleClientset(
+		newDeployment("app", 3),
+		newDeployment("sidekiq", 2),
+		newDeployment("systemkiq", 1),
+		newDeployment("cnvrg-operator", 1),
+		newStatefulSet("worker", 2, map[string]string{"cnvrg.io/quiesce": "true"}),
+		newHPA("app", KindDeployment, "app"),
+	)
+	api := &KubernetesAPI{Client: client}
+
+	viper.Set("quiesce.selector", "cnvrg.io/quiesce=true")
+	defer viper.Set("quiesce.selector", "")
+
+	err := ScaleDeployDown(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error scaling down: %v", err)
+	}
+
+	// every workload is at 0 with the original count saved
+	app, _ := client.AppsV1().Deployments("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if *app.Spec.Replicas != 0 || app.Annotations[ReplicasAnnotation] != "3" {
+		t.Fatalf("expected app at 0 replicas with 3 saved, got %d replicas and %q saved", *app.Spec.Replicas, app.Annotations[ReplicasAnnotation])
+	}
+	worker, _ := client.AppsV1().StatefulSets("cnvrg").Get(context.Background(), "worker", metav1.GetOptions{})
+	if *worker.Spec.Replicas != 0 || worker.Annotations[ReplicasAnnotation] != "2" {
+		t.Fatalf("expected worker at 0 replicas with 2 saved, got %d replicas and %q saved", *worker.Spec.Replicas, worker.Annotations[ReplicasAnnotation])
+	}
+
+	// the autoscaler points at a workload that doesn't exist while paused
+	hpa, _ := client.AutoscalingV2().HorizontalPodAutoscalers("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if hpa.Spec.ScaleTargetRef.Name != pausedHPAPrefix+"app" || hpa.Annotations[HPATargetAnnotation] != "app" {
+		t.Fatalf("expected the autoscaler to be paused, got target %q and %q saved", hpa.Spec.ScaleTargetRef.Name, hpa.Annotations[HPATargetAnnotation])
+	}
+
+	// a second scale down must not overwrite the saved count with 0
+	err = ScaleDeployDown(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error scaling down a second time: %v", err)
+	}
+
+	// the statefulset is still restored after the selector is removed from the config
+	viper.Set("quiesce.selector", "")
+	err = ScaleDeployUp(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error scaling up: %v", err)
+	}
+
+	for name, expected := range map[string]int32{"app": 3, "sidekiq": 2, "cnvrg-operator": 1} {
+		deploy, _ := client.AppsV1().Deployments("cnvrg").Get(context.Background(), name, metav1.GetOptions{})
+		if *deploy.Spec.Replicas != expected {
+			t.Errorf("expected %s at %d replicas, got %d", name, expected, *deploy.Spec.Replicas)
+		}
+		if _, ok := deploy.Annotations[ReplicasAnnotation]; ok {
+			t.Errorf("expected the saved count to be removed from %s", name)
+		}
+	}
+	worker, _ = client.AppsV1().StatefulSets("cnvrg").Get(context.Background(), "worker", metav1.GetOptions{})
+	if *worker.Spec.Replicas != 2 {
+		t.Errorf("expected worker at 2 replicas, got %d", *worker.Spec.Replicas)
+	}
+
+	hpa, _ = client.AutoscalingV2().HorizontalPodAutoscalers("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if _, ok := hpa.Annotations[HPATargetAnnotation]; ok || hpa.Spec.ScaleTargetRef.Name != "app" {
+		t.Errorf("expected the autoscaler to be resumed, got target %q", hpa.Spec.ScaleTargetRef.Name)
+	}
+
+	// the scale up removed the cleanup pushed by the scale down
+	if len(cleanupStack) != 0 {
+		t.Errorf("expected an empty cleanup stack after the scale up, got %d function(s)", len(cleanupStack))
+	}
+}
+
+func TestScaleDeployDownCleanup(t *testing.T) {
+	cleanupStack = nil
+
+	client := newScaleClientset(
+		newDeployment("app", 3),
+		newDeployment("cnvrg-operator", 1),
+	)
+	api := &KubernetesAPI{Client: client}
+
+	err := ScaleDeployDown(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error scaling down: %v", err)
+	}
+
+	// a failure after the scale down brings the app back
+	RunCleanups()
+
+	app, _ := client.AppsV1().Deployments("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if *app.Spec.Replicas != 3 {
+		t.Fatalf("expected the cleanup to scale app to 3 replicas, got %d", *app.Spec.Replicas)
+	}
+
+	// nothing is scaled up when the app is kept down
+	err = ScaleDeployDown(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error scaling down a second time: %v", err)
+	}
+	KeepScaledDown()
+	RunCleanups()
+
+	app, _ = client.AppsV1().Deployments("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if *app.Spec.Replicas != 0 {
+		t.Fatalf("expected app to stay at 0 replicas, got %d", *app.Spec.Replicas)
+	}
+}
+
+func TestResolveQuiesceSet(t *testing.T) {
+	client := newScaleClientset(newDeployment("app", 1), newDeployment("cnvrg-operator", 1))
+	api := &KubernetesAPI{Client: client}
+
+	// the operator is always first and missing workloads are skipped
+	workloads, err := ResolveQuiesceSet(api, "cnvrg", QuiesceConfig{
+		Operator:    "cnvrg-operator",
+		Deployments: []string{"app", "searchkiq", "app"},
+	}, false)
+	if err != nil {
+		t.Fatalf("error resolving the quiesce set: %v", err)
+	}
+	expected := []Workload{{Kind: KindDeployment, Name: "cnvrg-operator"}, {Kind: KindDeployment, Name: "app"}}
+	if len(workloads) != len(expected) || workloads[0] != expected[0] || workloads[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, workloads)
+	}
+
+	// a namespace without any of the workloads is an error
+	_, err = ResolveQuiesceSet(api, "default", QuiesceConfig{Deployments: []string{"app"}}, false)
+	if err == nil {
+		t.Fatal("expected an error when no workloads are found, got nil")
+	}
+}
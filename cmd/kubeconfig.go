@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+)
+
+// the connection flags shared by every client, filled in by the persistent root flags
+// --kubeconfig, --context, --namespace, --as, --as-group and --request-timeout
+var kubeConfigFlags = genericclioptions.NewConfigFlags(true)
+
+// Returns the kubeconfig loader for the cluster chosen with the root flags. The typed, dynamic
+// and controller-runtime clients built by ConnectToK8s and the Helm action config all use it
+// so they always talk to the same cluster as the same user
+func RESTClientGetter() genericclioptions.RESTClientGetter {
+	return kubeConfigFlags
+}
+
+// Builds the rest config from --kubeconfig or the KUBECONFIG env variable, then $HOME/.kube/config,
+// and falls back to the in-cluster config when cnvrgctl runs in a pod. --context picks the context
+// with any kubeconfig, --as and --as-group impersonate a user and --request-timeout limits each request
+func RESTConfig() (*rest.Config, error) {
+	log.Println("RESTConfig function called.")
+
+	config, err := RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building the kubeconfig. %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+current-context: first
+clusters:
+- name: first
+  cluster:
+    server: https://first.example.com
+- name: second
+  cluster:
+    server: https://second.example.com
+contexts:
+- name: first
+  context:
+    cluster: first
+    user: admin
+- name: second
+  context:
+    cluster: second
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestRESTConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(path, []byte(testKubeConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := kubeConfigFlags
+	defer func() { kubeConfigFlags = saved }()
+
+	// the current context of the kubeconfig is used by default
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	*kubeConfigFlags.KubeConfig = path
+	config, err := RESTConfig()
+	if err != nil {
+		t.Fatalf("error building the config: %v", err)
+	}
+	if config.Host != "https://first.example.com" {
+		t.Fatalf("expected the first cluster, got %s", config.Host)
+	}
+
+	// --context, --as, --as-group and --request-timeout are applied
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	*kubeConfigFlags.KubeConfig = path
+	*kubeConfigFlags.Context = "second"
+	*kubeConfigFlags.Impersonate = "jane"
+	*kubeConfigFlags.ImpersonateGroup = []string{"cnvrg-admins"}
+	*kubeConfigFlags.Timeout = "30s"
+	config, err = RESTConfig()
+	if err != nil {
+		t.Fatalf("error building the config: %v", err)
+	}
+	if config.Host != "https://second.example.com" {
+		t.Fatalf("expected the second cluster, got %s", config.Host)
+	}
+	if config.Impersonate.UserName != "jane" || !reflect.DeepEqual(config.Impersonate.Groups, []string{"cnvrg-admins"}) {
+		t.Fatalf("expected to impersonate jane in cnvrg-admins, got %+v", config.Impersonate)
+	}
+	if config.Timeout != 30*time.Second {
+		t.Fatalf("expected a 30s request timeout, got %v", config.Timeout)
+	}
+
+	// the context from --context is also used without --kubeconfig
+	t.Setenv("KUBECONFIG", path)
+	kubeConfigFlags = genericclioptions.NewConfigFlags(false)
+	*kubeConfigFlags.Context = "second"
+	config, err = RESTConfig()
+	if err != nil {
+		t.Fatalf("error building the config: %v", err)
+	}
+	if config.Host != "https://second.example.com" {
+		t.Fatalf("expected the second cluster from KUBECONFIG, got %s", config.Host)
+	}
+}
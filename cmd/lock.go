@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// name of the lease taken by the backup, restore, scale and maintenance commands
+const LockName = "cnvrgctl-lock"
+
+// annotation on the lease recording the command holding the lock
+const LockOperationAnnotation = "cnvrgctl/operation"
+
+// how long the lock is held without being renewed, a crashed run frees the lock after this
+const lockDuration = 2 * time.Minute
+
+// Lock is a coordination.k8s.io lease held for the length of a mutating command so two
+// backups or restores can't run against the same namespace at once
+type Lock struct {
+	api       *KubernetesAPI
+	namespace string
+	holder    string
+	stopCh    chan struct{}
+	once      sync.Once
+	pop       func()
+}
+
+// Takes the cnvrgctl-lock lease in namespace "ns" for "operation", e.g. "backup postgres".
+// Fails if another run holds an unexpired lease unless --break-lock is set. The lease is renewed
+// in the background until Release is called
+func AcquireLock(api *KubernetesAPI, ns string, operation string) (*Lock, error) {
+	log.Println("AcquireLock function called.")
+
+	var (
+		leases     = api.Client.CoordinationV1().Leases(ns)
+		holder     = fmt.Sprintf("%s/%d", Identity(), os.Getpid())
+		breakLock  = viper.GetBool("lock.break")
+		now        = v1.NewMicroTime(time.Now())
+		duration   = int32(lockDuration.Seconds())
+		annotation = map[string]string{LockOperationAnnotation: operation}
+	)
+
+	lease, err := leases.Get(api.Context(), LockName, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: v1.ObjectMeta{Name: LockName, Namespace: ns, Annotations: annotation},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+
+		// a create conflict means another run took the lock first
+		_, err = leases.Create(api.Context(), lease, v1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("another cnvrgctl run took the lock %v in namespace %v first, try again once it finishes", LockName, ns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating the lock %v. %w", LockName, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting the lock %v. %w", LockName, err)
+	} else {
+		// refuse to take a lock that is still held
+		if !leaseExpired(lease, time.Now()) && !breakLock {
+			return nil, fmt.Errorf("namespace %v is locked by %v running %q since %v, the lock expires at %v if that run is gone. use --break-lock to take it anyway",
+				ns, deref(lease.Spec.HolderIdentity), lease.Annotations[LockOperationAnnotation],
+				timeOf(lease.Spec.AcquireTime).Format(time.RFC3339), leaseExpiry(lease).Format(time.RFC3339))
+		}
+		if breakLock {
+			fmt.Printf("breaking the lock held by %v running %q.\n", deref(lease.Spec.HolderIdentity), lease.Annotations[LockOperationAnnotation])
+			log.Printf("breaking the lock held by %v running %q.\n", deref(lease.Spec.HolderIdentity), lease.Annotations[LockOperationAnnotation])
+		}
+
+		lease.Annotations = annotation
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.LeaseDurationSeconds = &duration
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+
+		// the update fails on a conflict if another run took over the lease since the get
+		_, err = leases.Update(api.Context(), lease, v1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			return nil, fmt.Errorf("another cnvrgctl run took the lock %v in namespace %v first, try again once it finishes", LockName, ns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error taking the lock %v. %w", LockName, err)
+		}
+	}
+
+	log.Printf("took the lock %v in namespace %v as %v for %q.\n", LockName, ns, holder, operation)
+
+	lock := &Lock{api: api, namespace: ns, holder: holder, stopCh: make(chan struct{})}
+	go lock.renew()
+
+	// release the lock if the command fails or is interrupted, deferred releases don't run on exit
+	lock.pop = PushCleanup(lock.Release)
+	return lock, nil
+}
+
+// renews the lease every third of its duration until the lock is released
+func (l *Lock) renew() {
+	ticker := time.NewTicker(lockDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopCh:
+			return
+		case <-ticker.C:
+			leases := l.api.Client.CoordinationV1().Leases(l.namespace)
+			lease, err := leases.Get(context.Background(), LockName, v1.GetOptions{})
+			if err != nil {
+				log.Printf("error renewing the lock %v. %v", LockName, err)
+				continue
+			}
+
+			// someone used --break-lock, stop renewing
+			if deref(lease.Spec.HolderIdentity) != l.holder {
+				fmt.Fprintf(os.Stderr, "the lock %v was taken over by %v.\n", LockName, deref(lease.Spec.HolderIdentity))
+				log.Printf("the lock %v was taken over by %v.\n", LockName, deref(lease.Spec.HolderIdentity))
+				return
+			}
+
+			now := v1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			_, err = leases.Update(context.Background(), lease, v1.UpdateOptions{})
+			if err != nil {
+				log.Printf("error renewing the lock %v. %v", LockName, err)
+			}
+		}
+	}
+}
+
+// Stops renewing the lease and deletes it if this run still holds it, safe to call more than once
+func (l *Lock) Release() {
+	l.once.Do(func() {
+		log.Println("Release function called.")
+		l.pop()
+		close(l.stopCh)
+
+		leases := l.api.Client.CoordinationV1().Leases(l.namespace)
+		lease, err := leases.Get(context.Background(), LockName, v1.GetOptions{})
+		if err != nil {
+			log.Printf("error getting the lock %v to release it. %v", LockName, err)
+			return
+		}
+
+		// don't delete a lock another run took with --break-lock
+		if deref(lease.Spec.HolderIdentity) != l.holder {
+			return
+		}
+
+		// only delete the version read above so a new holder isn't removed
+		err = leases.Delete(context.Background(), LockName, v1.DeleteOptions{
+			Preconditions: &v1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		if err != nil {
+			log.Printf("error releasing the lock %v. %v", LockName, err)
+			return
+		}
+		log.Printf("released the lock %v in namespace %v.\n", LockName, l.namespace)
+	})
+}
+
+// returns when the lease expires if it isn't renewed
+func leaseExpiry(lease *coordinationv1.Lease) time.Time {
+	duration := lockDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return timeOf(lease.Spec.RenewTime).Add(duration)
+}
+
+// a lease without a holder or past its expiry is free to take
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if deref(lease.Spec.HolderIdentity) == "" {
+		return true
+	}
+	return now.After(leaseExpiry(lease))
+}
+
+func timeOf(t *v1.MicroTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAcquireLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	api := &KubernetesAPI{Client: client}
+
+	lock, err := AcquireLock(api, "cnvrg", "cnvrgctl backup postgres")
+	if err != nil {
+		t.Fatalf("error taking the lock: %v", err)
+	}
+
+	lease, _ := client.CoordinationV1().Leases("cnvrg").Get(context.Background(), LockName, metav1.GetOptions{})
+	if lease.Annotations[LockOperationAnnotation] != "cnvrgctl backup postgres" || lease.Spec.HolderIdentity == nil {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+
+	// a second run fails fast while the lock is held
+	_, err = AcquireLock(api, "cnvrg", "cnvrgctl restore postgres")
+	if err == nil || !strings.Contains(err.Error(), "is locked by") {
+		t.Fatalf("expected a locked error, got %v", err)
+	}
+
+	// releasing deletes the lease so the next run can take it
+	lock.Release()
+	lock.Release()
+	_, err = client.CoordinationV1().Leases("cnvrg").Get(context.Background(), LockName, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("expected the lease to be deleted, got %v", err)
+	}
+
+	lock, err = AcquireLock(api, "cnvrg", "cnvrgctl restore postgres")
+	if err != nil {
+		t.Fatalf("error taking the released lock: %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireLockExpiredAndBroken(t *testing.T) {
+	holder := "someone@elsewhere/1"
+	duration := int32(120)
+	renewed := metav1.NewMicroTime(time.Now().Add(-10 * time.Minute))
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: LockName, Namespace: "cnvrg"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renewed,
+		},
+	})
+	api := &KubernetesAPI{Client: client}
+
+	// a lease left by a crashed run can be taken once it expires
+	lock, err := AcquireLock(api, "cnvrg", "cnvrgctl backup redis")
+	if err != nil {
+		t.Fatalf("error taking the expired lock: %v", err)
+	}
+
+	// another run breaks the lock, the old holder must leave it alone on release
+	lease, _ := client.CoordinationV1().Leases("cnvrg").Get(context.Background(), LockName, metav1.GetOptions{})
+	lease.Spec.HolderIdentity = &holder
+	client.CoordinationV1().Leases("cnvrg").Update(context.Background(), lease, metav1.UpdateOptions{})
+
+	lock.Release()
+	_, err = client.CoordinationV1().Leases("cnvrg").Get(context.Background(), LockName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the lease of the new holder to be kept, got %v", err)
+	}
+
+	// the lease is held and not expired, only --break-lock takes it
+	_, err = AcquireLock(api, "cnvrg", "cnvrgctl restore redis")
+	if err == nil {
+		t.Fatal("expected a locked error, got nil")
+	}
+
+	viper.Set("lock.break", true)
+	defer viper.Set("lock.break", false)
+
+	lock, err = AcquireLock(api, "cnvrg", "cnvrgctl restore redis")
+	if err != nil {
+		t.Fatalf("error breaking the lock: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,466 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// the built-in rule catalog, extended or overridden with --rules
+//
+//go:embed rules.yaml
+var builtinRules []byte
+
+// the number of sample lines printed for each finding
+const maxSamples = 3
+
+// analyzeCmd represents the logs analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Scan the collected logs and the pod statuses for known cnvrg issues",
+	Long: `Scan the logs saved by cnvrgctl logs or a support bundle and the status of the pods
+in the namespaces against a catalog of known issues, like the database refusing
+connections, Redis authentication errors, object storage 403s and OOMKilled sidekiq
+workers, then print a summary of the problems found with how to fix them. The pods
+are the ones of -n, or of --namespaces, --all-namespaces or --cnvrg like cnvrgctl logs.
+
+Rules match log lines with a regular expression and containers by their waiting or
+terminated reason and restart count. Add or override rules with a YAML file, a rule
+with the id of a built-in rule replaces it:
+
+rules:
+  - id: license-expired
+    title: The cnvrg license expired
+    severity: critical
+    containers: '^app$'
+    pattern: 'License expired'
+    remediation: Contact cnvrg support for a new license.
+
+Usage:
+  cnvrgctl logs analyze [flags]
+
+Examples:
+  # Analyze the logs in ./logs and the pods in the cnvrg namespace.
+  cnvrgctl -n cnvrg logs analyze
+
+  # Analyze an extracted support bundle without connecting to the cluster.
+  cnvrgctl logs analyze --log-dir=cnvrg-support-cnvrg-20240601-100000 --offline
+
+  # Analyze the logs and the pods of every namespace of the cnvrg installation.
+  cnvrgctl -n cnvrg logs analyze --cnvrg
+
+  # Add the rules of rules.yaml to the built-in ones.
+  cnvrgctl -n cnvrg logs analyze --rules=rules.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("called the logs analyze command.")
+
+		logDir, _ := cmd.Flags().GetString("log-dir")
+		rulesFile, _ := cmd.Flags().GetString("rules")
+		offline, _ := cmd.Flags().GetBool("offline")
+
+		// the pod statuses are only checked when the cluster can be reached
+		var pods []corev1.Pod
+		if !offline {
+			api, err := root.ConnectToK8s()
+			if err == nil {
+				// the namespaces from -n, --namespaces, --all-namespaces or --cnvrg, like the logs command
+				var namespaces []string
+				namespaces, err = root.ResolveNamespaces(api.Context(), api.Client, &api.Dynamic, root.GetNamespaceOptions(cmd))
+				if err == nil {
+					pods, err = getNamespacesPods(api.Context(), namespaces, api.Client)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: the pod statuses were not checked. %v\n", err)
+			}
+		}
+
+		err := analyzeLogs(pods, logDir, rulesFile, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error analyzing the logs. %v\n", err)
+		}
+	},
+}
+
+func init() {
+	// Adds the analyze command under the logs command
+	logsCmd.AddCommand(analyzeCmd)
+
+	// Add the flag --rules to extend the built-in rule catalog, also used by logs --analyze
+	logsCmd.PersistentFlags().String("rules", "", "A YAML file of rules added to the built-in ones, a rule with the id of a built-in rule replaces it")
+
+	// Add the flag --offline to only scan the log files
+	analyzeCmd.Flags().Bool("offline", false, "Only scan the log files, don't check the pod statuses in the cluster")
+
+	// Add the flags --namespaces, --all-namespaces and --cnvrg to check the pods of more than the -n namespace
+	root.AddNamespaceFlags(analyzeCmd)
+}
+
+// Returns the pods of every namespace in "namespaces"
+func getNamespacesPods(ctx context.Context, namespaces []string, clientset kubernetes.Interface) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	for _, ns := range namespaces {
+		nsPods, err := getPods(ctx, ns, "", clientset)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, nsPods...)
+	}
+	return pods, nil
+}
+
+// Matches the containers of the pods and the logs saved in "logdir" against the built-in rules and
+// the rules of "rulesFile", then prints the summary of the findings to "w"
+func analyzeLogs(pods []corev1.Pod, logdir string, rulesFile string, w io.Writer) error {
+	log.Println("analyzeLogs function called.")
+
+	rules, err := loadRules(rulesFile)
+	if err != nil {
+		return err
+	}
+
+	a := newAnalyzer(rules)
+	a.checkPods(pods)
+	err = a.scanDir(logdir)
+	if err != nil {
+		return err
+	}
+	a.report(w)
+	return nil
+}
+
+// Rule is a known issue, found by a log line matching Pattern or a container whose waiting or
+// terminated reason matches State and that restarted at least MinRestarts times
+type Rule struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Severity    string `json:"severity,omitempty"`
+	Pods        string `json:"pods,omitempty"`
+	Containers  string `json:"containers,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	State       string `json:"state,omitempty"`
+	MinRestarts int32  `json:"minRestarts,omitempty"`
+	Remediation string `json:"remediation"`
+}
+
+type ruleCatalog struct {
+	Rules []Rule `json:"rules"`
+}
+
+// a rule with its regular expressions compiled, a nil expression matches everything
+type compiledRule struct {
+	Rule
+	pods, containers, pattern, state *regexp.Regexp
+}
+
+// Loads the built-in rules, then the rules of "path" when it is set. A rule of the file with the
+// id of a built-in rule replaces it, the other rules are added after the built-in ones
+func loadRules(path string) ([]compiledRule, error) {
+	log.Println("loadRules function called.")
+
+	var catalog ruleCatalog
+	err := yaml.Unmarshal(builtinRules, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the built-in rules. %w", err)
+	}
+	rules := catalog.Rules
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the rules file %v. %w", path, err)
+		}
+		var extra ruleCatalog
+		err = yaml.UnmarshalStrict(data, &extra)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the rules file %v. %w", path, err)
+		}
+
+		for _, rule := range extra.Rules {
+			replaced := false
+			for i := range rules {
+				if rules[i].ID == rule.ID {
+					rules[i] = rule
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule}
+	if rule.ID == "" {
+		return c, fmt.Errorf("the rule %q has no id", rule.Title)
+	}
+	if rule.Pattern == "" && rule.State == "" && rule.MinRestarts == 0 {
+		return c, fmt.Errorf("the rule %v needs a pattern, a state or minRestarts", rule.ID)
+	}
+	if rule.Severity == "" {
+		c.Severity = "warning"
+	}
+
+	for _, re := range []struct {
+		expr string
+		dst  **regexp.Regexp
+	}{
+		{rule.Pods, &c.pods},
+		{rule.Containers, &c.containers},
+		{rule.Pattern, &c.pattern},
+		{rule.State, &c.state},
+	} {
+		if re.expr == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(re.expr)
+		if err != nil {
+			return c, fmt.Errorf("the rule %v has an invalid regular expression %q. %w", rule.ID, re.expr, err)
+		}
+		*re.dst = compiled
+	}
+	return c, nil
+}
+
+// the rule applies to the container of the pod
+func (r compiledRule) appliesTo(pod string, container string) bool {
+	return (r.pods == nil || r.pods.MatchString(pod)) && (r.containers == nil || r.containers.MatchString(container))
+}
+
+// Finding is a rule that matched, with where and how often
+type Finding struct {
+	Rule    Rule
+	Count   int
+	Where   []string
+	Samples []string
+}
+
+// analyzer matches the log lines and the container states against the rules
+type analyzer struct {
+	rules    []compiledRule
+	findings map[string]*Finding
+}
+
+func newAnalyzer(rules []compiledRule) *analyzer {
+	return &analyzer{rules: rules, findings: map[string]*Finding{}}
+}
+
+func (a *analyzer) add(rule Rule, where string, sample string) {
+	f, ok := a.findings[rule.ID]
+	if !ok {
+		f = &Finding{Rule: rule}
+		a.findings[rule.ID] = f
+	}
+	f.Count++
+	if !root.Contains(f.Where, where) {
+		f.Where = append(f.Where, where)
+	}
+	if len(f.Samples) < maxSamples && sample != "" {
+		f.Samples = append(f.Samples, sample)
+	}
+}
+
+// Checks the line of the container's log against the pattern rules, "source" is the file it was read from
+func (a *analyzer) scanLine(pod string, container string, source string, line string) {
+	for _, rule := range a.rules {
+		if rule.pattern == nil || !rule.appliesTo(pod, container) {
+			continue
+		}
+		if rule.pattern.MatchString(line) {
+			a.add(rule.Rule, source, strings.TrimSpace(line))
+		}
+	}
+}
+
+// Checks the containers of the pods against the state rules. A container is reported by the
+// first rule matching it, so a specific rule like sidekiq-oomkilled hides the generic oomkilled
+func (a *analyzer) checkPods(pods []corev1.Pod) {
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			reasons := containerReasons(status)
+
+			for _, rule := range a.rules {
+				if (rule.state == nil && rule.MinRestarts == 0) || !rule.appliesTo(pod.Name, status.Name) {
+					continue
+				}
+				if rule.MinRestarts > 0 && status.RestartCount < rule.MinRestarts {
+					continue
+				}
+
+				matched := ""
+				if rule.state != nil {
+					for _, reason := range reasons {
+						if rule.state.MatchString(reason) {
+							matched = reason
+							break
+						}
+					}
+					if matched == "" {
+						continue
+					}
+				}
+
+				sample := fmt.Sprintf("%s/%s restarts=%d", pod.Name, status.Name, status.RestartCount)
+				if matched != "" {
+					sample += " reason=" + matched
+				}
+				a.add(rule.Rule, pod.Name+"/"+status.Name, sample)
+				break
+			}
+		}
+	}
+}
+
+// the waiting reason, the terminated reason and the reason of the last termination of the container
+func containerReasons(status corev1.ContainerStatus) []string {
+	var reasons []string
+	if w := status.State.Waiting; w != nil && w.Reason != "" {
+		reasons = append(reasons, w.Reason)
+	}
+	if t := status.State.Terminated; t != nil && t.Reason != "" {
+		reasons = append(reasons, t.Reason)
+	}
+	if t := status.LastTerminationState.Terminated; t != nil && t.Reason != "" {
+		reasons = append(reasons, t.Reason)
+	}
+	return reasons
+}
+
+// Scans the logs saved in "dir" and its sub folders, the <pod>_<container>.txt files and the
+// logs.jsonl records, gzipped or not
+func (a *analyzer) scanDir(dir string) error {
+	log.Println("scanDir function called.")
+
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking the file path. %w", err)
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		// the file is named by its path in the folder, the logs of each namespace are in a sub folder
+		source, err := filepath.Rel(dir, path)
+		if err != nil {
+			source = fi.Name()
+		}
+
+		switch name := fi.Name(); {
+		case strings.HasSuffix(name, ".txt"):
+			// <pod>_<container>.txt or <pod>_<container>_previous.txt, pod and container names have no _
+			parts := strings.Split(strings.TrimSuffix(name, ".txt"), "_")
+			if len(parts) < 2 {
+				return nil
+			}
+			return a.scanFile(path, func(line string) {
+				a.scanLine(parts[0], parts[1], source, line)
+			})
+		case name == "logs.jsonl" || name == "logs.jsonl.gz":
+			return a.scanFile(path, func(line string) {
+				var r logRecord
+				if json.Unmarshal([]byte(line), &r) == nil {
+					a.scanLine(r.Pod, r.Container, r.Pod+"_"+r.Container+" in "+source, r.Message)
+				}
+			})
+		}
+		return nil
+	})
+}
+
+// calls "scan" for every line of the file, gunzipped when its name ends in .gz
+func (a *analyzer) scanFile(path string, scan func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %v. %w", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzr, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %v. %w", path, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		scan(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %v. %w", path, err)
+	}
+	return nil
+}
+
+// Returns the findings, the critical ones first, then by the number of matches
+func (a *analyzer) results() []*Finding {
+	findings := make([]*Finding, 0, len(a.findings))
+	for _, f := range a.findings {
+		findings = append(findings, f)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		ci, cj := findings[i].Rule.Severity == "critical", findings[j].Rule.Severity == "critical"
+		if ci != cj {
+			return ci
+		}
+		if findings[i].Count != findings[j].Count {
+			return findings[i].Count > findings[j].Count
+		}
+		return findings[i].Rule.ID < findings[j].Rule.ID
+	})
+	return findings
+}
+
+// Prints the summary of the findings with their remediation, returns the number of findings
+func (a *analyzer) report(w io.Writer) int {
+	findings := a.results()
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No known issues found.")
+		return 0
+	}
+
+	fmt.Fprintf(w, "Found %d known issues:\n", len(findings))
+	for _, f := range findings {
+		fmt.Fprintf(w, "\n[%s] %s (%s)\n", f.Rule.Severity, f.Rule.Title, f.Rule.ID)
+		fmt.Fprintf(w, "  %d matches in %s\n", f.Count, strings.Join(f.Where, ", "))
+		for _, sample := range f.Samples {
+			fmt.Fprintf(w, "  e.g. %s\n", sample)
+		}
+		fmt.Fprintf(w, "  Fix: %s\n", f.Rule.Remediation)
+	}
+	return len(findings)
+}
@@ -0,0 +1,156 @@
+package logs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnalyzeLogs(t *testing.T) {
+	logDir := t.TempDir()
+	files := map[string]string{
+		"app-1_app.txt":          "Started GET /\nPG::ConnectionBad: could not connect to server: Connection refused\nPG::ConnectionBad: could not connect to server\n",
+		"sidekiq-1_sidekiq.txt":  "Redis::CommandError: NOAUTH Authentication required.\n",
+		"app-1_app_previous.txt": "Completed 200 OK\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(logDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sidekiq-1"},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "sidekiq",
+				RestartCount:         1,
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"}},
+			}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-1"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 7}}},
+		},
+	}
+
+	rules, err := loadRules("")
+	if err != nil {
+		t.Fatalf("built-in rules not loaded: %v", err)
+	}
+	a := newAnalyzer(rules)
+	a.checkPods(pods)
+	if err := a.scanDir(logDir); err != nil {
+		t.Fatalf("logs not scanned: %v", err)
+	}
+
+	// the specific sidekiq rule reports the OOMKilled sidekiq instead of the generic rule
+	counts := map[string]int{}
+	for _, f := range a.results() {
+		counts[f.Rule.ID] = f.Count
+	}
+	expected := map[string]int{"postgres-connection-refused": 2, "redis-auth-failed": 1, "sidekiq-oomkilled": 1, "frequent-restarts": 1}
+	if len(counts) != len(expected) {
+		t.Fatalf("expected the findings %v, got %v", expected, counts)
+	}
+	for id, count := range expected {
+		if counts[id] != count {
+			t.Errorf("expected %d matches of %s, got %d", count, id, counts[id])
+		}
+	}
+
+	var out strings.Builder
+	if n := a.report(&out); n != 4 {
+		t.Fatalf("expected 4 findings reported, got %d", n)
+	}
+	for _, s := range []string{"Found 4 known issues", "[critical] The database refuses connections (postgres-connection-refused)", "2 matches in app-1_app.txt", "e.g. sidekiq-1/sidekiq restarts=1 reason=OOMKilled", "Fix: "} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %q in the report, got:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	// replaces a built-in rule and adds one
+	custom := filepath.Join(dir, "rules.yaml")
+	err := os.WriteFile(custom, []byte(`rules:
+  - id: disk-full
+    title: Disk full on our NFS
+    pattern: 'ENOSPC'
+    remediation: Call the storage team.
+  - id: license-expired
+    title: The cnvrg license expired
+    severity: critical
+    containers: '^app$'
+    pattern: 'License expired'
+    remediation: Contact cnvrg support.
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builtin, err := loadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadRules(custom)
+	if err != nil {
+		t.Fatalf("rules not loaded: %v", err)
+	}
+	if len(rules) != len(builtin)+1 || rules[len(rules)-1].ID != "license-expired" {
+		t.Fatalf("expected the custom rule added after the %d built-in rules, got %d rules", len(builtin), len(rules))
+	}
+
+	a := newAnalyzer(rules)
+	a.scanLine("app-1", "app", "app-1_app.txt", "License expired on 2024-06-01")
+	a.scanLine("sidekiq-1", "sidekiq", "sidekiq-1_sidekiq.txt", "License expired on 2024-06-01")
+	a.scanLine("app-1", "app", "app-1_app.txt", "write failed: ENOSPC")
+	a.scanLine("app-1", "app", "app-1_app.txt", "No space left on device")
+	if a.findings["license-expired"].Count != 1 || a.findings["disk-full"].Count != 1 || a.findings["disk-full"].Rule.Severity != "warning" {
+		t.Fatalf("unexpected findings %+v %+v", a.findings["license-expired"], a.findings["disk-full"])
+	}
+
+	// bad rules are reported
+	for _, bad := range []string{
+		"rules:\n  - id: no-match\n    title: nothing to match\n",
+		"rules:\n  - title: no id\n    pattern: x\n",
+		"rules:\n  - id: bad-regexp\n    pattern: '('\n",
+		"rules:\n  - id: typo\n    patern: x\n",
+	} {
+		if err := os.WriteFile(custom, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRules(custom); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestGetNamespacesPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-1", Namespace: "cnvrg-jobs"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other-1", Namespace: "other"}},
+	)
+
+	// the pods of every namespace are checked, not only the ones of -n
+	pods, err := getNamespacesPods(context.Background(), []string{"cnvrg", "cnvrg-jobs"}, clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	if strings.Join(names, ",") != "app-1,job-1" {
+		t.Fatalf("expected the pods of both namespaces, got %v", names)
+	}
+}
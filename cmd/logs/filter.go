@@ -0,0 +1,191 @@
+package logs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// logFilter drops the lines the api server can't filter out, the lines logged after --until and
+// the lines that don't match --grep
+type logFilter struct {
+	// keep the lines matching the pattern, nil keeps every line
+	Grep *regexp.Regexp
+
+	// the number of lines kept before and after each match, like grep -C
+	Context int
+
+	// drop the lines logged after this time, the zero time keeps every line
+	Until time.Time
+
+	// the lines are prefixed with their time by the api server, set with --until and --format jsonl
+	Timestamps bool
+}
+
+// a filtered log is read line by line instead of being copied as is
+func (f logFilter) active() bool {
+	return f.Grep != nil || !f.Until.IsZero() || f.Timestamps
+}
+
+// Sets the filters from the flags. The selector and the since time are sent to the api server,
+// --until and --grep are applied to the lines as they are read
+func (o *logOptions) setFilters(selector string, since time.Duration, sinceTime string, until string, grep string, context int) error {
+	log.Println("setFilters function called.")
+
+	o.Selector = selector
+
+	if since > 0 && sinceTime != "" {
+		return fmt.Errorf("only one of --since or --since-time can be used")
+	}
+	if since > 0 {
+		seconds := int64(since.Seconds())
+		o.SinceSeconds = &seconds
+	}
+	if sinceTime != "" {
+		t, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return fmt.Errorf("the --since-time %v isn't an RFC3339 time like 2024-06-01T10:00:00Z. %w", sinceTime, err)
+		}
+		o.SinceTime = &v1.Time{Time: t}
+	}
+
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fmt.Errorf("the --until %v isn't an RFC3339 time like 2024-06-01T12:00:00Z. %w", until, err)
+		}
+		if o.SinceTime != nil && !t.After(o.SinceTime.Time) {
+			return fmt.Errorf("--until %v must be after --since-time %v", until, sinceTime)
+		}
+		o.Filter.Until = t
+		o.Filter.Timestamps = true
+	}
+
+	if grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return fmt.Errorf("the --grep pattern %v isn't a valid regular expression. %w", grep, err)
+		}
+		o.Filter.Grep = re
+	}
+	if context < 0 {
+		return fmt.Errorf("--grep-context can't be negative")
+	}
+	o.Filter.Context = context
+	return nil
+}
+
+// Returns the options sent to the api server for the log of a container. The timestamps are
+// requested when --until or --format jsonl is set, they are removed from the lines by the filter
+func (o logOptions) podLogOptions(container string, previous bool) *corev1.PodLogOptions {
+	podLogOptions := &corev1.PodLogOptions{
+		Container:    container,
+		Previous:     previous,
+		SinceSeconds: o.SinceSeconds,
+		SinceTime:    o.SinceTime,
+		Timestamps:   o.Filter.Timestamps,
+	}
+	if o.TailLines > 0 {
+		tail := o.TailLines
+		podLogOptions.TailLines = &tail
+	}
+	return podLogOptions
+}
+
+// Reads the log "r" line by line and calls "emit" with every line kept, without the newline and
+// with its time when the timestamps were requested, the zero time otherwise. When lines are dropped
+// between two groups of matches a "--" line is emitted like grep does. Reading stops at the first
+// line logged after the until time, the log is in order
+func (f logFilter) scan(r io.Reader, emit func(ts time.Time, line string) error) error {
+	reader := bufio.NewReader(r)
+
+	var (
+		// the lines before the next match, at most f.Context of them
+		before []timedLine
+
+		// the number of lines still kept after the last match
+		after int
+
+		// a line was emitted and lines were dropped since, the next group starts with --
+		emitted bool
+		gap     bool
+	)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			l := timedLine{line: strings.TrimSuffix(line, "\n")}
+
+			// the api server prefixes the lines with their time when the timestamps are requested
+			if f.Timestamps {
+				if ts, rest, ok := strings.Cut(l.line, " "); ok {
+					if t, perr := time.Parse(time.RFC3339Nano, ts); perr == nil {
+						if !f.Until.IsZero() && t.After(f.Until) {
+							return nil
+						}
+						l = timedLine{ts: t, line: rest}
+					}
+				}
+			}
+
+			switch {
+			case f.Grep == nil:
+				if e := emit(l.ts, l.line); e != nil {
+					return e
+				}
+			case f.Grep.MatchString(l.line):
+				if emitted && gap {
+					if e := emit(time.Time{}, "--"); e != nil {
+						return e
+					}
+				}
+				for _, b := range append(before, l) {
+					if e := emit(b.ts, b.line); e != nil {
+						return e
+					}
+				}
+				before = before[:0]
+				emitted, gap = true, false
+				after = f.Context
+			case after > 0:
+				if e := emit(l.ts, l.line); e != nil {
+					return e
+				}
+				after--
+			case f.Context > 0:
+				// held back in case a match follows, the oldest line is dropped when there are too many
+				before = append(before, l)
+				if len(before) > f.Context {
+					before = append(before[:0], before[1:]...)
+					gap = true
+				}
+			default:
+				gap = true
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// the -- emitted by scan between two groups of --grep matches, the lines of the log have their time
+func (f logFilter) separator(ts time.Time, line string) bool {
+	return f.Grep != nil && ts.IsZero() && line == "--"
+}
+
+// a line of the log and the time it was logged
+type timedLine struct {
+	ts   time.Time
+	line string
+}
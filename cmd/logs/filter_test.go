@@ -0,0 +1,157 @@
+package logs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// returns the lines kept by the filter joined with newlines
+func scanLines(t *testing.T, filter logFilter, log string) string {
+	var lines []string
+	err := filter.scan(strings.NewReader(log), func(ts time.Time, line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestLogFilterScan(t *testing.T) {
+	log := "a\nb\nmatch 1\nc\nd\ne\nf\nmatch 2\nmatch 3\ng\n"
+
+	testCases := []struct {
+		name     string
+		filter   logFilter
+		log      string
+		expected string
+	}{
+		{
+			name:     "no_filter",
+			log:      "a\nb",
+			expected: "a\nb",
+		},
+		{
+			name:     "grep",
+			filter:   logFilter{Grep: regexp.MustCompile("match")},
+			log:      log,
+			expected: "match 1\n--\nmatch 2\nmatch 3",
+		},
+		{
+			name:     "grep_with_context",
+			filter:   logFilter{Grep: regexp.MustCompile("match"), Context: 1},
+			log:      log,
+			expected: "b\nmatch 1\nc\n--\nf\nmatch 2\nmatch 3\ng",
+		},
+		{
+			name:     "grep_with_overlapping_context",
+			filter:   logFilter{Grep: regexp.MustCompile("match"), Context: 2},
+			log:      log,
+			expected: "a\nb\nmatch 1\nc\nd\ne\nf\nmatch 2\nmatch 3\ng",
+		},
+		{
+			name:     "until_stops_and_strips_the_timestamps",
+			filter:   logFilter{Until: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Timestamps: true},
+			log:      "2024-06-01T11:59:59.000000001Z before\n2024-06-01T12:00:00Z at\n2024-06-01T12:00:01Z after\n",
+			expected: "before\nat",
+		},
+		{
+			name:     "until_and_grep",
+			filter:   logFilter{Grep: regexp.MustCompile("dataset"), Until: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Timestamps: true},
+			log:      "2024-06-01T11:00:00Z dataset one\n2024-06-01T11:30:00Z other\n2024-06-01T12:30:00Z dataset two\n",
+			expected: "dataset one",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if got := scanLines(t, test.filter, test.log); got != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetFilters(t *testing.T) {
+	var o logOptions
+	err := o.setFilters("app=sidekiq", 2*time.Hour, "", "2024-06-01T12:00:00Z", "dataset-[0-9]+", 3)
+	if err != nil {
+		t.Fatalf("filters not set: %v", err)
+	}
+	p := o.podLogOptions("sidekiq", false)
+	if o.Selector != "app=sidekiq" || p.SinceSeconds == nil || *p.SinceSeconds != 7200 || !p.Timestamps || p.TailLines != nil {
+		t.Fatalf("unexpected options %+v %+v", o, p)
+	}
+
+	// the bad flags are reported
+	bad := [][]string{
+		{"1h", "2024-06-01T10:00:00Z", "", ""},
+		{"", "yesterday", "", ""},
+		{"", "", "noon", ""},
+		{"", "2024-06-01T12:00:00Z", "2024-06-01T10:00:00Z", ""},
+		{"", "", "", "dataset-("},
+	}
+	for _, flags := range bad {
+		var since time.Duration
+		if flags[0] != "" {
+			since, _ = time.ParseDuration(flags[0])
+		}
+		var o logOptions
+		if err := o.setFilters("", since, flags[1], flags[2], flags[3], 0); err == nil {
+			t.Errorf("expected an error for %v", flags)
+		}
+	}
+}
+
+func TestGetLogsSelectorAndGrep(t *testing.T) {
+	sidekiq := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sidekiq-1", Namespace: "cnvrg", Labels: map[string]string{"app": "sidekiq"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "sidekiq"}}},
+	}
+	app := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg", Labels: map[string]string{"app": "app"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	client := fake.NewSimpleClientset(sidekiq, app)
+
+	pods, err := getPods(context.Background(), "cnvrg", "app=sidekiq", client)
+	if err != nil || len(pods) != 1 || pods[0].Name != "sidekiq-1" {
+		t.Fatalf("expected only the sidekiq pod, got %v %v", pods, err)
+	}
+
+	// the fake log is "fake logs", a matching pattern keeps it and another one saves no file
+	logDir := t.TempDir()
+	opts := logOptions{Workers: 1}
+	if err := opts.setFilters("app=sidekiq", 0, "", "", "fake", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := getLogs(context.Background(), pods, opts, logDir, client); err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(logDir, "sidekiq-1_sidekiq.txt"))
+	if err != nil || string(b) != "fake logs\n" {
+		t.Fatalf("expected the matching line, got %q %v", string(b), err)
+	}
+
+	logDir = t.TempDir()
+	opts = logOptions{Workers: 1}
+	if err := opts.setFilters("", 0, "", "", "dataset", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := getLogs(context.Background(), pods, opts, logDir, client); err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "sidekiq-1_sidekiq.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file without a matching line, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the colors of the pod/container tags, picked in turn for each new container
+var tagColors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[31m"}
+
+const colorReset = "\033[0m"
+
+// follower streams the logs of every running container in the namespaces to a single writer,
+// each line prefixed with a pod/container tag, namespace/pod/container with opts.PerNamespace
+type follower struct {
+	clientset kubernetes.Interface
+	opts      logOptions
+
+	// where the tagged lines go, written one line at a time
+	out   io.Writer
+	outMu sync.Mutex
+	color bool
+
+	// with --format jsonl the lines are written to "out" as records instead of tagged lines
+	records *recordWriter
+
+	// also append the lines to <tee>/<pod>_<container>.txt, or <tee>/<namespace>/<pod>_<container>.txt
+	// with opts.PerNamespace, empty to not save them
+	tee string
+
+	// the container instances already streamed, keyed by namespace, pod, container and restart count
+	mu      sync.Mutex
+	started map[string]bool
+	next    int
+	wg      sync.WaitGroup
+}
+
+// Streams the logs of all the containers in the namespaces to "out" until "ctx" is cancelled.
+// Pods created or containers restarted while following are picked up from the pod watch. When
+// "tee" is set the lines are also saved to the folder, one file per container
+func followLogs(ctx context.Context, clientset kubernetes.Interface, namespaces []string, opts logOptions, out io.Writer, color bool, tee string) error {
+	log.Println("followLogs function called.")
+
+	if tee != "" {
+		for _, ns := range namespaces {
+			err := os.MkdirAll(opts.namespaceDir(tee, ns), 0755)
+			if err != nil {
+				return fmt.Errorf("error creating the folder. %w", err)
+			}
+		}
+	}
+
+	f := &follower{clientset: clientset, opts: opts, out: out, color: color, tee: tee, started: map[string]bool{}}
+	if opts.Format == formatJSONL {
+		f.records = newRecordWriter("stdout", out)
+	}
+	defer f.wg.Wait()
+
+	// each namespace has its own pod watch, the first one failing stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(namespaces))
+	for _, ns := range namespaces {
+		go func() {
+			err := f.followNamespace(ctx, ns)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}()
+	}
+
+	var firstErr error
+	for range namespaces {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// follows the pods of namespace "ns" until "ctx" is cancelled, which returns nil
+func (f *follower) followNamespace(ctx context.Context, ns string) error {
+	for {
+		// list to catch up, then watch from the list for new pods and restarts
+		pods, err := f.clientset.CoreV1().Pods(ns).List(ctx, v1.ListOptions{LabelSelector: f.opts.Selector})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error listing the pods in namespace %v. %w", ns, err)
+		}
+		for i := range pods.Items {
+			f.follow(ctx, &pods.Items[i])
+		}
+
+		watcher, err := f.clientset.CoreV1().Pods(ns).Watch(ctx, v1.ListOptions{LabelSelector: f.opts.Selector, ResourceVersion: pods.ResourceVersion})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error watching the pods in namespace %v. %w", ns, err)
+		}
+
+		err = f.watch(ctx, watcher)
+		watcher.Stop()
+
+		// the watch closes when it expires on the server, start over unless we are done
+		if err != nil {
+			return nil
+		}
+		log.Println("the pod watch closed, listing the pods again.")
+	}
+}
+
+// follows the pods added or changed until the watch closes or "ctx" is cancelled, which returns the context error
+func (f *follower) watch(ctx context.Context, watcher watch.Interface) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				f.follow(ctx, pod)
+			}
+		}
+	}
+}
+
+// starts streaming every running container of the pod that isn't streamed yet
+func (f *follower) follow(ctx context.Context, pod *corev1.Pod) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Running == nil {
+			continue
+		}
+
+		// a restarted container is a new instance with its own logs
+		key := fmt.Sprintf("%s/%s/%s/%s/%d", pod.Namespace, pod.Name, pod.UID, status.Name, status.RestartCount)
+
+		f.mu.Lock()
+		if f.started[key] {
+			f.mu.Unlock()
+			continue
+		}
+		f.started[key] = true
+		tag := pod.Name + "/" + status.Name
+		if f.opts.PerNamespace {
+			tag = pod.Namespace + "/" + tag
+		}
+		if f.color {
+			tag = tagColors[f.next%len(tagColors)] + tag + colorReset
+		}
+		f.next++
+		f.mu.Unlock()
+
+		target := logTarget{Namespace: pod.Namespace, Pod: pod.Name, Container: status.Name, Node: pod.Spec.NodeName, Labels: pod.Labels}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			err := f.stream(ctx, target, tag)
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "error following the logs of pod %s container %s: %v\n", target.Pod, target.Container, err)
+				log.Printf("error following the logs of pod %s container %s: %v\n", target.Pod, target.Container, err)
+			}
+		}()
+	}
+}
+
+// follows the log of a single container, writing each line to the output with the tag or as a record
+func (f *follower) stream(ctx context.Context, t logTarget, tag string) error {
+	podLogOptions := f.opts.podLogOptions(t.Container, false)
+	podLogOptions.Follow = true
+
+	stream, err := f.clientset.CoreV1().Pods(t.Namespace).GetLogs(t.Pod, podLogOptions).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	var file *os.File
+	if f.tee != "" {
+		file, err = os.OpenFile(filepath.Join(f.opts.namespaceDir(f.tee, t.Namespace), t.fileName()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening the log file. %w", err)
+		}
+		defer file.Close()
+	}
+
+	// the lines kept by --until and --grep, all of them when no filter is set, without the secrets
+	err = f.opts.Filter.scan(stream, func(ts time.Time, line string) error {
+		line = f.opts.Redactor.Redact(t.Pod+"/"+t.Container, line)
+
+		if f.records != nil {
+			if f.opts.Filter.separator(ts, line) {
+				return nil
+			}
+			if err := f.records.write(t.record(ts, line)); err != nil {
+				return err
+			}
+		} else {
+			f.outMu.Lock()
+			fmt.Fprintf(f.out, "[%s] %s\n", tag, line)
+			f.outMu.Unlock()
+		}
+
+		if file != nil {
+			file.WriteString(line + "\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("the log of pod %s container %s ended at %v.\n", t.Pod, t.Container, time.Now().Format(time.RFC3339))
+	return nil
+}
+
+// colors are used when the output is a terminal and NO_COLOR isn't set
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// a buffer the followers can write to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newRunningPod(name string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "cnvrg"}}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  c,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		})
+	}
+	return pod
+}
+
+// waits for "s" to show up in the output
+func waitForOutput(t *testing.T, out *syncBuffer, s string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q in the output, got %q", s, out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	client := fake.NewSimpleClientset(newRunningPod("app-1", "app", "proxy"))
+	tee := t.TempDir()
+	out := &syncBuffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- followLogs(ctx, client, []string{"cnvrg"}, logOptions{TailLines: 10}, out, false, tee)
+	}()
+
+	// every running container is followed with its tag
+	waitForOutput(t, out, "[app-1/app] fake logs\n")
+	waitForOutput(t, out, "[app-1/proxy] fake logs\n")
+
+	// a pod created while following is picked up
+	_, err := client.CoreV1().Pods("cnvrg").Create(context.Background(), newRunningPod("sidekiq-1", "sidekiq"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForOutput(t, out, "[sidekiq-1/sidekiq] fake logs\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("error following the logs: %v", err)
+	}
+
+	// each container is streamed once and saved without the tag
+	if n := strings.Count(out.String(), "[app-1/app]"); n != 1 {
+		t.Errorf("expected app-1/app to be followed once, got %d", n)
+	}
+	b, err := os.ReadFile(filepath.Join(tee, "sidekiq-1_sidekiq.txt"))
+	if err != nil || string(b) != "fake logs\n" {
+		t.Errorf("expected the followed logs to be saved, got %q %v", string(b), err)
+	}
+}
+
+func TestFollowLogsNamespaces(t *testing.T) {
+	other := newRunningPod("app-1", "app")
+	other.Namespace = "cnvrg-jobs"
+	client := fake.NewSimpleClientset(newRunningPod("app-1", "app"), other)
+	tee := t.TempDir()
+	out := &syncBuffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- followLogs(ctx, client, []string{"cnvrg", "cnvrg-jobs"}, logOptions{PerNamespace: true}, out, false, tee)
+	}()
+
+	// the pods with the same name are told apart by their namespace
+	waitForOutput(t, out, "[cnvrg/app-1/app] fake logs\n")
+	waitForOutput(t, out, "[cnvrg-jobs/app-1/app] fake logs\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("error following the logs: %v", err)
+	}
+
+	for _, ns := range []string{"cnvrg", "cnvrg-jobs"} {
+		b, err := os.ReadFile(filepath.Join(tee, ns, "app-1_app.txt"))
+		if err != nil || string(b) != "fake logs\n" {
+			t.Errorf("expected the logs of %s saved to its folder, got %q %v", ns, string(b), err)
+		}
+	}
+}
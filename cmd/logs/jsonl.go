@@ -0,0 +1,161 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the output formats of the logs command
+const (
+	formatText  = "text"
+	formatJSONL = "jsonl"
+)
+
+// logRecord is a line of a container log with the metadata of its pod, written as one JSON line
+type logRecord struct {
+	Timestamp string            `json:"timestamp,omitempty"`
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	Container string            `json:"container"`
+	Previous  bool              `json:"previous,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Message   string            `json:"message"`
+}
+
+// Sets the output format, the api server adds the time of each line for the jsonl records
+func (o *logOptions) setFormat(format string, compress bool) error {
+	switch format {
+	case formatText:
+		if compress {
+			return fmt.Errorf("--gzip can only be used with --format jsonl")
+		}
+	case formatJSONL:
+		o.Filter.Timestamps = true
+	default:
+		return fmt.Errorf("unknown --format %v, use text or jsonl", format)
+	}
+	o.Format = format
+	o.Gzip = compress
+	return nil
+}
+
+// the record of a line of the target's log, "ts" is the zero time when the line had no time
+func (t logTarget) record(ts time.Time, message string) logRecord {
+	r := logRecord{
+		Namespace: t.Namespace,
+		Pod:       t.Pod,
+		Container: t.Container,
+		Previous:  t.Previous,
+		Node:      t.Node,
+		Labels:    t.Labels,
+		Message:   message,
+	}
+	if !ts.IsZero() {
+		r.Timestamp = ts.UTC().Format(time.RFC3339Nano)
+	}
+	return r
+}
+
+// recordWriter writes the records of every container as JSON lines, one record at a time so
+// the workers can share it
+type recordWriter struct {
+	// the name the redactions are counted under
+	name string
+
+	mu    sync.Mutex
+	enc   *json.Encoder
+	file  io.WriteCloser
+	gz    *gzip.Writer
+	count int
+}
+
+// Creates logs.jsonl in the folder "logdir", or logs.jsonl.gz when "compress" is set. With an
+// archive the records are written to the archive at that path instead
+func newRecordFile(logdir string, compress bool, archive *root.Archive) (*recordWriter, error) {
+	name := filepath.Join(logdir, "logs.jsonl")
+	if compress {
+		name += ".gz"
+	}
+
+	var file io.WriteCloser
+	if archive != nil {
+		file = archive.Create(name)
+	} else {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the file. %w", err)
+		}
+		file = f
+	}
+
+	w := &recordWriter{name: name, file: file}
+	if compress {
+		w.gz = gzip.NewWriter(file)
+		w.enc = json.NewEncoder(w.gz)
+	} else {
+		w.enc = json.NewEncoder(file)
+	}
+	return w, nil
+}
+
+// Returns a writer of the records to "out", used to follow the logs as jsonl
+func newRecordWriter(name string, out io.Writer) *recordWriter {
+	return &recordWriter{name: name, enc: json.NewEncoder(out)}
+}
+
+func (w *recordWriter) write(r logRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.count++
+	return w.enc.Encode(r)
+}
+
+// flushes the gzip stream and closes the file or adds it to the archive, nothing to do for a writer to "out"
+func (w *recordWriter) Close() error {
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.file.Close()
+			return fmt.Errorf("error compressing %s. %w", w.name, err)
+		}
+	}
+	if w.file != nil {
+		return w.file.Close()
+	}
+	return nil
+}
+
+// Streams the log of the target to the records, through the filter and the redactor. Returns
+// false when no line matched --grep
+func saveRecords(ctx context.Context, t logTarget, opts logOptions, records *recordWriter, clientset kubernetes.Interface) (bool, error) {
+	stream, err := clientset.CoreV1().Pods(t.Namespace).GetLogs(t.Pod, opts.podLogOptions(t.Container, t.Previous)).Stream(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	kept := 0
+	err = opts.Filter.scan(stream, func(ts time.Time, line string) error {
+		// the -- between groups of --grep matches only makes sense in a text file
+		if opts.Filter.separator(ts, line) {
+			return nil
+		}
+		kept++
+		return records.write(t.record(ts, opts.Redactor.Redact(records.name, line)))
+	})
+	if err != nil {
+		return false, fmt.Errorf("error writing the log to %s. %w", records.name, err)
+	}
+	return kept > 0 || opts.Filter.Grep == nil, nil
+}
@@ -0,0 +1,90 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetLogsJSONL(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sidekiq-1", Namespace: "cnvrg", Labels: map[string]string{"app": "sidekiq"}},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "sidekiq"}, {Name: "proxy"}},
+		},
+	}
+
+	opts := logOptions{Workers: 2}
+	if err := opts.setFormat(formatJSONL, true); err != nil {
+		t.Fatalf("format not set: %v", err)
+	}
+	if !opts.podLogOptions("sidekiq", false).Timestamps {
+		t.Fatal("expected the timestamps to be requested for the records")
+	}
+
+	logDir := t.TempDir()
+	err := getLogs(context.Background(), []corev1.Pod{*pod}, opts, logDir, fake.NewSimpleClientset(pod))
+	if err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(logDir, "logs.jsonl.gz"))
+	if err != nil {
+		t.Fatalf("records not written: %v", err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("records not compressed: %v", err)
+	}
+
+	// one record per container, the fake log has a single line
+	containers := map[string]bool{}
+	scanner := bufio.NewScanner(gzr)
+	for scanner.Scan() {
+		var r logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("record %q not valid: %v", scanner.Text(), err)
+		}
+		if r.Namespace != "cnvrg" || r.Pod != "sidekiq-1" || r.Node != "node-1" || r.Labels["app"] != "sidekiq" || r.Message != "fake logs" {
+			t.Errorf("unexpected record %+v", r)
+		}
+		containers[r.Container] = true
+	}
+	if len(containers) != 2 || !containers["sidekiq"] || !containers["proxy"] {
+		t.Fatalf("expected a record for each container, got %v", containers)
+	}
+}
+
+func TestLogRecord(t *testing.T) {
+	target := logTarget{Namespace: "cnvrg", Pod: "app-1", Container: "app", Previous: true}
+	ts := time.Date(2024, 6, 1, 10, 0, 0, 123, time.UTC)
+
+	b, err := json.Marshal(target.record(ts, "started"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"timestamp":"2024-06-01T10:00:00.000000123Z","namespace":"cnvrg","pod":"app-1","container":"app","previous":true,"message":"started"}`
+	if string(b) != expected {
+		t.Fatalf("expected %s, got %s", expected, b)
+	}
+
+	// the formats are checked
+	var opts logOptions
+	if err := opts.setFormat("xml", false); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if err := opts.setFormat(formatText, true); err == nil {
+		t.Error("expected an error for --gzip with text")
+	}
+}
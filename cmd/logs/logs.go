@@ -4,15 +4,14 @@ Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
 package logs
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
 	root "github.com/dilerous/cnvrgctl/cmd"
@@ -27,7 +26,36 @@ var logsCmd = &cobra.Command{
 	Use:   "logs",
 	Short: "Pull logs from all running pods in the namespace defined",
 	Long: `Capture the logs for every container in a specified namespace and save the 
-files to ./<log-dir>/<pod-name>.txt. 
+files to ./<log-dir>/<pod-name>_<container>.txt. Init containers are included and
+the logs of the previous instance of containers that restarted are saved to
+<pod-name>_<container>_previous.txt.
+
+The pods can be picked with a label selector and the lines with a time window and
+a regular expression. The selector and the start of the window are applied by the
+api server, --until and --grep are applied to the lines as they are read.
+
+The values of the secrets in the namespace, AWS keys, JWTs, passwords in URLs and
+CNVRG_*_KEY values are replaced with [REDACTED] before the logs are written, the
+number of redactions of each file is printed at the end. Use --no-redact to keep them.
+
+A cnvrg install spans several namespaces. Use --namespaces to list them, --all-namespaces
+for the whole cluster or --cnvrg to find the namespaces of the installation whose
+control plane is in -n: the CnvrgApp and CnvrgInfra namespaces, the namespaces and pods
+labeled for cnvrg like the workload namespaces, and the ingress controllers. The logs
+of each namespace are then saved to ./<log-dir>/<namespace>/ and the followed lines
+are tagged with the namespace.
+
+--archive=tar.gz or --archive=zip archives the log folder next to it as
+cnvrg-logs-<cluster>-<namespaces>-<time>.tar.gz or .zip, --tar is --archive=tar.gz.
+With --archive-only the logs are written straight into the archive and the log folder
+isn't created. Each log is held in memory up to 4MiB while it is fetched, larger logs
+are spooled to a temporary file until they are added to the archive.
+
+--upload=s3://bucket/prefix archives the logs, uploads the archive and prints a
+download link valid for --upload-expiry. The credentials are read from the MinIO tenant created by install
+minio unless --upload-secret or --upload-access-key and --upload-secret-key are set.
+The tenant found from its ingress is verified with its certificate and the cluster CA,
+add --upload-insecure to upload without verifying it.
 
 Usage:
   cnvrgctl logs [flags]
@@ -39,51 +67,195 @@ Examples:
   # Gather all container logs in the cnvrg namespace and select the last 10 lines.
   cnvrgctl -n cnvrg logs -l=10
 
-  # Gather all container logs and tar the log files into a tar.gz
+  # Gather all container logs and archive the log folder to cnvrg-logs-<cluster>-cnvrg-<time>.tar.gz
   cnvrgctl -n cnvrg logs --tar
+
+  # Write the logs straight into a zip archive without saving the log folder.
+  cnvrgctl -n cnvrg logs --archive=zip --archive-only
   
   # Gather all container logs and specify the directory the files are saved to.
-  cnvrgctl -n cnvrg logs --log-dir=my-log-folder `,
+  cnvrgctl -n cnvrg logs --log-dir=my-log-folder
+
+  # Gather the logs 10 at a time without the previous instances of restarted containers.
+  cnvrgctl -n cnvrg logs --workers=10 --previous=false
+
+  # Watch the logs of every container live, starting from the last 10 lines, and save them to ./logs.
+  cnvrgctl -n cnvrg logs --follow -l=10 --tee
+
+  # Gather the sidekiq logs of the last 2 hours mentioning a dataset, with 3 lines around each match.
+  cnvrgctl -n cnvrg logs --selector=app=sidekiq --since=2h --grep="dataset-1234" -C 3
+
+  # Write every line as a json record with its time, pod, container, node and labels to ./logs/logs.jsonl.gz.
+  cnvrgctl -n cnvrg logs --format=jsonl --gzip
+
+  # Gather the logs of every namespace of the cnvrg installation, one folder per namespace.
+  cnvrgctl -n cnvrg logs --cnvrg
+
+  # Gather the logs of the cnvrg and cnvrg-jobs namespaces.
+  cnvrgctl logs --namespaces=cnvrg,cnvrg-jobs
+
+  # Upload the logs to the MinIO backup bucket created by install minio and print a download link.
+  cnvrgctl -n cnvrg logs --upload=s3://cnvrg-backups/support
+
+  # Upload the logs to s3://my-bucket/support with the credentials of the cnvrg object storage.
+  cnvrgctl -n cnvrg logs --upload=s3://my-bucket/support --upload-secret=cp-object-storage --upload-namespace=cnvrg
+
+  # Gather the logs and print the known issues found in them with how to fix them.
+  cnvrgctl -n cnvrg logs --analyze
+
+  # Gather the logs of a time window.
+  cnvrgctl -n cnvrg logs --since-time=2024-06-01T10:00:00Z --until=2024-06-01T12:00:00Z`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("called the logs command.")
 
-		// Pass a namespace to the logs command
-		ns, _ := cmd.Flags().GetString("namespace")
-
 		// Pass a namespace to the logs command
 		logDir, _ := cmd.Flags().GetString("log-dir")
 
 		// Pass the number of lines to gather when grabbing logs
 		lines, _ := cmd.Flags().GetInt("lines")
 
+		// also grab the logs of restarted containers before their restart
+		previous, _ := cmd.Flags().GetBool("previous")
+
+		// the number of logs grabbed at the same time
+		workers, _ := cmd.Flags().GetInt("workers")
+
 		// calls connect function to set the clientset for kubectl access
 		api, err := root.ConnectToK8s()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error connecting to cluster, check your connectivity. %v", err)
+			return
+		}
+
+		// the filters, the selector and the since time are applied by the api server
+		selector, _ := cmd.Flags().GetString("selector")
+		since, _ := cmd.Flags().GetDuration("since")
+		sinceTime, _ := cmd.Flags().GetString("since-time")
+		until, _ := cmd.Flags().GetString("until")
+		grep, _ := cmd.Flags().GetString("grep")
+		grepContext, _ := cmd.Flags().GetInt("grep-context")
+
+		// a time window or a pattern searches the whole log unless --lines is set
+		if !cmd.Flags().Changed("lines") && (since > 0 || sinceTime != "" || until != "" || grep != "") {
+			lines = 0
 		}
 
-		// return a list all pods in the cnvrg namespace
-		podList, err := getPods(ns, api.Client)
+		// the namespaces from -n, --namespaces, --all-namespaces or --cnvrg, each one saved to its own
+		// folder unless only -n is set
+		nsOptions := root.GetNamespaceOptions(cmd)
+		namespaces, err := root.ResolveNamespaces(api.Context(), api.Client, &api.Dynamic, nsOptions)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting the list of pods. %v", err)
+			fmt.Fprintf(os.Stderr, "error finding the namespaces to collect. %v\n", err)
 			return
 		}
 
-		// takes the podlist and gathers logs for each pod and saves to txt file
-		err = getLogs(podList, lines, logDir, api.Client)
+		opts := logOptions{TailLines: int64(lines), Previous: previous, Workers: workers, PerNamespace: nsOptions.Multiple()}
+		err = opts.setFilters(selector, since, sinceTime, until, grep, grepContext)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error gathering logs. %v", err)
+			fmt.Fprintf(os.Stderr, "error in the log filters. %v\n", err)
+			return
+		}
+
+		// text files per container or jsonl records
+		format, _ := cmd.Flags().GetString("format")
+		compress, _ := cmd.Flags().GetBool("gzip")
+		err = opts.setFormat(format, compress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in the log format. %v\n", err)
+			return
 		}
 
-		// Tars the log files
+		// replace the secrets of the namespace and the built-in patterns unless --no-redact is set
+		if noRedact, _ := cmd.Flags().GetBool("no-redact"); !noRedact {
+			opts.Redactor, err = root.NewNamespaceRedactor(api.Context(), api.Client, namespaces...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+		}
+
+		// stream the logs of every container until Ctrl-C, optionally saving them to the log directory
+		if followFlag, _ := cmd.Flags().GetBool("follow"); followFlag {
+			tee := ""
+			if teeFlag, _ := cmd.Flags().GetBool("tee"); teeFlag {
+				tee = logDir
+			}
+			err = followLogs(api.Context(), api.Client, namespaces, opts, os.Stdout, useColor(os.Stdout), tee)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error following the logs. %v\n", err)
+			}
+			return
+		}
+
+		// the archive format, --tar, --archive-only and --upload archive to tar.gz unless --archive is set
+		archiveFormat, _ := cmd.Flags().GetString("archive")
+		archiveOnly, _ := cmd.Flags().GetBool("archive-only")
 		tarFlag, _ := cmd.Flags().GetBool("tar")
-		if tarFlag {
-			err = createTar()
+		upload := root.GetUploadOptions(cmd)
+		if archiveFormat == "" && (tarFlag || archiveOnly || upload.URL != "") {
+			archiveFormat = root.ArchiveTarGz
+		}
+
+		// the archive is saved next to the log folder, named after the cluster, the namespaces and the time
+		var archive *root.Archive
+		if archiveFormat != "" {
+			dir, err := archiveDir(logDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error creating the archive. %v\n", err)
+				return
+			}
+			name := root.ArchiveName("logs", root.ClusterName(), namespaces, time.Now())
+			archive, err = root.CreateArchive(dir, name, archiveFormat)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error creating the tar file. %v", err)
+				fmt.Fprintf(os.Stderr, "error creating the archive. %v\n", err)
+				return
 			}
+			defer archive.Close()
 		}
 
+		// with --archive-only the logs go straight into the archive, no file is written to the log folder
+		saveDir := logDir
+		if archiveOnly {
+			opts.Archive = archive
+			saveDir = ""
+		}
+
+		// gathers the logs of each pod in the namespaces and saves them to txt files
+		podList, err := getNamespaceLogs(api.Context(), namespaces, opts, saveDir, api.Client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error gathering logs. %v", err)
+		}
+		opts.Redactor.PrintReport(os.Stdout)
+
+		// look for known issues in the logs just saved and the pod statuses
+		if analyzeFlag, _ := cmd.Flags().GetBool("analyze"); analyzeFlag {
+			rulesFile, _ := cmd.Flags().GetString("rules")
+			err = analyzeLogs(podList, logDir, rulesFile, os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error analyzing the logs. %v\n", err)
+			}
+		}
+
+		if archive == nil {
+			return
+		}
+
+		// archives the log folder, the archive is needed to upload the logs
+		if !archiveOnly {
+			err = archive.AddDir(logDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error archiving the log folder %s. %v\n", logDir, err)
+				return
+			}
+		}
+		err = archive.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating the archive. %v\n", err)
+			return
+		}
+		fmt.Printf("archive saved to %s\n", archive.Path)
+
+		// share the archive through a bucket when --upload is set
+		root.UploadAndPrintLink(api.Context(), api.Client, upload, archive.Path, "")
 	},
 }
 
@@ -92,18 +264,112 @@ func init() {
 	root.RootCmd.AddCommand(logsCmd)
 
 	// Adds the flag -t --tar to the logs command this is local
-	logsCmd.Flags().BoolP("tar", "t", false, "Tarball the log files")
+	logsCmd.Flags().BoolP("tar", "t", false, "Archive the log folder to a tar.gz next to it, like --archive=tar.gz")
+
+	// Add the flags --archive and --archive-only to pick the archive format and skip the log folder
+	logsCmd.Flags().String("archive", "", "Archive the log folder next to it as tar.gz or zip, named cnvrg-logs-<cluster>-<namespaces>-<time>")
+	logsCmd.Flags().Bool("archive-only", false, "Write the logs straight into the archive without saving them to the log folder")
 
 	// Add the flag -n --number to select the number of logs to grab
 	logsCmd.Flags().IntP("lines", "l", 100, "Define the number of lines in the log to return")
 
+	// Add the flag --previous to also grab the logs of the crashed instance of restarted containers
+	logsCmd.Flags().BoolP("previous", "p", true, "Also save the logs of the previous instance of containers that restarted")
+
+	// Add the flag --workers to define how many logs are grabbed at the same time
+	logsCmd.Flags().IntP("workers", "w", 5, "Number of logs to grab at the same time")
+
+	// Add the flag --follow to stream the logs of all the containers until Ctrl-C
+	logsCmd.Flags().BoolP("follow", "f", false, "Stream the logs of all the running containers, new pods and restarted containers are picked up")
+
+	// Add the flag --tee to save the followed logs to the log directory as well
+	logsCmd.Flags().Bool("tee", false, "With --follow, also append the logs to a file per container in the log directory")
+
+	// Add the flag --selector to only grab the logs of the pods matching the labels
+	logsCmd.Flags().StringP("selector", "s", "", "Only grab the logs of the pods matching this label selector, e.g. app=sidekiq")
+
+	// Add the flags --since and --since-time to only grab the recent lines
+	logsCmd.Flags().Duration("since", 0, "Only return the lines newer than a relative duration like 5s, 2m or 3h")
+	logsCmd.Flags().String("since-time", "", "Only return the lines after this RFC3339 time, e.g. 2024-06-01T10:00:00Z")
+	logsCmd.MarkFlagsMutuallyExclusive("since", "since-time")
+
+	// Add the flag --until to drop the lines logged after a time
+	logsCmd.Flags().String("until", "", "Only return the lines before this RFC3339 time, e.g. 2024-06-01T12:00:00Z")
+
+	// Add the flags --grep and --grep-context to only keep the lines matching a pattern
+	logsCmd.Flags().String("grep", "", "Only keep the lines matching this regular expression, the whole log is searched unless --lines is set")
+	logsCmd.Flags().IntP("grep-context", "C", 0, "Number of lines to keep before and after each line matching --grep")
+
+	// Add the flags --format and --gzip to write the logs as json lines
+	logsCmd.Flags().String("format", formatText, "The output format, text for a file per container or jsonl for one record per line with the pod metadata in logs.jsonl")
+	logsCmd.Flags().Bool("gzip", false, "With --format jsonl, compress the records to logs.jsonl.gz")
+
+	// Add the flag --analyze to check the logs for known issues once they are saved
+	logsCmd.Flags().Bool("analyze", false, "Scan the logs and the pod statuses for known issues once the logs are saved, like cnvrgctl logs analyze")
+
+	// Add the flag --no-redact to write the logs without replacing the secrets
+	logsCmd.Flags().Bool("no-redact", false, "Don't replace the secrets of the namespace, keys, tokens and passwords in the logs with [REDACTED]")
+
+	// Add the flags --namespaces, --all-namespaces and --cnvrg to gather more than the -n namespace
+	root.AddNamespaceFlags(logsCmd)
+
+	// Add the flag --upload to upload the archive to a bucket and print a download link
+	root.AddUploadFlags(logsCmd)
+
+	// the logs written straight into the archive can't be analyzed or followed
+	logsCmd.MarkFlagsMutuallyExclusive("archive-only", "analyze")
+	logsCmd.MarkFlagsMutuallyExclusive("archive-only", "follow")
+
 	// Add the flag --log-dir to define the log directory
 	logsCmd.PersistentFlags().StringP("log-dir", "", "./logs", "Define the directory logs are saved too.")
 }
 
-func getPods(ns string, clientset kubernetes.Interface) ([]corev1.Pod, error) {
-	// List Pods
-	pods, err := clientset.CoreV1().Pods(ns).List(context.Background(), v1.ListOptions{})
+// Gathers the logs of the pods in each namespace, saved to the folder of the namespace, and
+// returns the pods. A namespace failing doesn't stop the others, the errors are returned together
+// Returns the folder the archive is saved to, the parent of the log folder. The log folder is made
+// absolute first, with "--log-dir ." the archive is saved next to the current folder instead of
+// inside the folder being archived
+func archiveDir(logDir string) (string, error) {
+	log.Println("archiveDir function called.")
+
+	dir, err := filepath.Abs(logDir)
+	if err != nil {
+		return "", fmt.Errorf("error finding the path of the log folder %s. %w", logDir, err)
+	}
+	parent := filepath.Dir(dir)
+	if parent == dir {
+		return "", fmt.Errorf("the log folder %s has no parent folder to save the archive to, set another --log-dir", logDir)
+	}
+	return parent, nil
+}
+
+func getNamespaceLogs(ctx context.Context, namespaces []string, opts logOptions, logdir string, clientset kubernetes.Interface) ([]corev1.Pod, error) {
+	log.Println("getNamespaceLogs function called.")
+
+	var (
+		pods []corev1.Pod
+		errs []error
+	)
+	for _, ns := range namespaces {
+		// return a list all pods in the namespace
+		nsPods, err := getPods(ctx, ns, opts.Selector, clientset)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", ns, err))
+			continue
+		}
+		pods = append(pods, nsPods...)
+
+		err = getLogs(ctx, nsPods, opts, opts.namespaceDir(logdir, ns), clientset)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", ns, err))
+		}
+	}
+	return pods, errors.Join(errs...)
+}
+
+func getPods(ctx context.Context, ns string, selector string, clientset kubernetes.Interface) ([]corev1.Pod, error) {
+	// List Pods, only the ones matching the selector when it is set
+	pods, err := clientset.CoreV1().Pods(ns).List(ctx, v1.ListOptions{LabelSelector: selector})
 	if err != nil {
 		return nil, fmt.Errorf("getting the list of pods failed. %w", err)
 	}
@@ -116,118 +382,273 @@ func getPods(ns string, clientset kubernetes.Interface) ([]corev1.Pod, error) {
 	return pods.Items, nil
 }
 
-func getLogs(pods []corev1.Pod, num int, logdir string, clientset kubernetes.Interface) error {
-	fmt.Println("Grabbing the following pod logs:")
+// logOptions are the flags of the logs command used when fetching the logs
+type logOptions struct {
+	// number of lines from the end of each log
+	TailLines int64
 
-	tailLines := int64(num)
+	// also fetch the logs of the previous instance of restarted containers
+	Previous bool
 
-	logsPath := logdir
-	err := os.MkdirAll(logsPath, 0755)
-	if err != nil {
-		return fmt.Errorf("error creating the folder. %w", err)
-	}
+	// number of logs fetched at the same time
+	Workers int
 
-	// change to the logs directory
-	err = os.Chdir(logsPath)
-	if err != nil {
-		return fmt.Errorf("error changing directory. %w", err)
-	}
+	// only the pods matching the label selector, filtered by the api server
+	Selector string
 
-	for _, pod := range pods {
-		time.Sleep(1 * time.Second)
-		podLogs, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{TailLines: &tailLines}).DoRaw(context.Background())
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting logs for pod %s: %v\n", pod.Name, err)
-			continue
-		}
+	// only the lines logged in the last seconds or since the time, filtered by the api server
+	SinceSeconds *int64
+	SinceTime    *v1.Time
 
-		file, err := os.Create(pod.Name + ".txt")
-		if err != nil {
-			return fmt.Errorf("error creating the file. %w", err)
-		}
-		defer file.Close()
+	// the filters applied to the lines as they are read
+	Filter logFilter
 
-		fmt.Printf("Pod: %s\n%s\n", pod.Name, string(podLogs))
-		fmt.Fprint(file, string(podLogs))
-		file.Close()
-	}
+	// replaces the secrets in the lines written, nil writes them as is
+	Redactor *root.Redactor
 
-	// changing back to the root directory
-	err = os.Chdir("../")
-	if err != nil {
-		return fmt.Errorf("error changing directory to root. %w", err)
-	}
-	return nil
-}
+	// text writes a file per container, jsonl writes a record per line to logs.jsonl
+	Format string
 
-// TODO: create a flag that lets you define the folder the files live in; default ./logs
-func createTar() error {
-	log.Println("You called the tar flag")
+	// compress the jsonl records to logs.jsonl.gz
+	Gzip bool
 
-	tarFile := "logs.tar.gz"
-	dir := "./"
+	// write the logs to the archive instead of files in the log folder, the paths in the folder
+	// become paths in the archive
+	Archive *root.Archive
 
-	err := createTarGz(dir, tarFile)
-	if err != nil {
-		return fmt.Errorf("error creating the tar file, %v. %w", tarFile, err)
+	// save the logs of each namespace to its own folder and tag the followed lines with the namespace
+	PerNamespace bool
+}
+
+// the folder the logs of namespace "ns" are saved to, <logdir>/<ns> with PerNamespace
+func (o logOptions) namespaceDir(logdir string, ns string) string {
+	if o.PerNamespace {
+		return filepath.Join(logdir, ns)
 	}
+	return logdir
+}
 
-	fmt.Printf("tar file created successfully, %v\n", tarFile)
-	return nil
+// logTarget is a single log to fetch, one container of a pod, or its previous instance
+type logTarget struct {
+	Namespace string
+	Pod       string
+	Container string
+	Previous  bool
+
+	// the node and labels of the pod, added to the jsonl records
+	Node   string
+	Labels map[string]string
 }
 
-func createTarGz(source string, target string) error {
-	// Create the target file logs.tar.gz
-	file, err := os.Create(target)
-	if err != nil {
-		return fmt.Errorf("file creation failed, the name of the file is %v. %w", target, err)
+// the file the log is saved to, <pod>_<container>.txt or <pod>_<container>_previous.txt
+func (t logTarget) fileName() string {
+	name := t.Pod + "_" + t.Container
+	if t.Previous {
+		name += "_previous"
 	}
-	defer file.Close()
+	return name + ".txt"
+}
 
-	// Create a gzip writer
-	gzwFile := gzip.NewWriter(file)
-	defer gzwFile.Close()
+// Returns a target for every init and app container of the pods, plus the previous instance of
+// the containers that restarted when "previous" is set
+func logTargets(pods []corev1.Pod, previous bool) []logTarget {
+	var targets []logTarget
+	for _, pod := range pods {
+		// containers that restarted have the logs of the crashed instance
+		restarted := map[string]bool{}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.RestartCount > 0 || status.LastTerminationState.Terminated != nil {
+				restarted[status.Name] = true
+			}
+		}
+
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, c := range containers {
+			target := logTarget{Namespace: pod.Namespace, Pod: pod.Name, Container: c.Name, Node: pod.Spec.NodeName, Labels: pod.Labels}
+			targets = append(targets, target)
+			if previous && restarted[c.Name] {
+				target.Previous = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
 
-	// Create a new tar writer
-	twFile := tar.NewWriter(gzwFile)
-	defer twFile.Close()
+// Saves the logs of every container of the pods to the folder "logdir", one file per container and
+// previous instance, or all of them to logs.jsonl with --format jsonl. The logs are fetched by a
+// pool of opts.Workers workers, a log that can't be fetched is reported and skipped
+func getLogs(ctx context.Context, pods []corev1.Pod, opts logOptions, logdir string, clientset kubernetes.Interface) error {
+	log.Println("getLogs function called.")
+	fmt.Println("Grabbing the following pod logs:")
 
-	// Walk through the source directory
-	err = filepath.Walk(source, func(file string, fi os.FileInfo, err error) error {
+	// the archive has no folders to create
+	if opts.Archive == nil {
+		err := os.MkdirAll(logdir, 0755)
 		if err != nil {
-			return fmt.Errorf("error walking the file path. %w", err)
+			return fmt.Errorf("error creating the folder. %w", err)
 		}
+	}
 
-		// Only include .txt files
-		if !strings.HasSuffix(fi.Name(), ".txt") {
-			return nil
-		}
+	var err error
+	targets := logTargets(pods, opts.Previous)
+	workers := max(opts.Workers, 1)
 
-		//TODO: wy is it opening file not tarfile
-		// Open the file
-		f, err := os.Open(file)
+	// the jsonl records of every container go to one file
+	var records *recordWriter
+	if opts.Format == formatJSONL {
+		records, err = newRecordFile(logdir, opts.Gzip, opts.Archive)
 		if err != nil {
-			return fmt.Errorf("error opening the file %v. %w", file, err)
+			return err
 		}
-		defer f.Close()
+	}
 
-		// Create a new tar header
-		hdr := &tar.Header{
-			Name:    strings.TrimPrefix(file, source+"/"),
-			Size:    fi.Size(),
-			Mode:    int64(fi.Mode()),
-			ModTime: fi.ModTime(),
+	// hand the targets to the workers
+	targetCh := make(chan logTarget)
+	go func() {
+		defer close(targetCh)
+		for _, t := range targets {
+			select {
+			case targetCh <- t:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		failed    int
+		unmatched int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range targetCh {
+				var (
+					saved bool
+					err   error
+				)
+				if records != nil {
+					saved, err = saveRecords(ctx, t, opts, records, clientset)
+				} else {
+					saved, err = saveLog(ctx, t, opts, logdir, clientset)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error getting logs for pod %s container %s: %v\n", t.Pod, t.Container, err)
+					log.Printf("error getting logs for pod %s container %s: %v\n", t.Pod, t.Container, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					continue
+				}
+				if !saved {
+					fmt.Printf("Pod: %s, container: %s, no matching lines\n", t.Pod, t.Container)
+					mu.Lock()
+					unmatched++
+					mu.Unlock()
+					continue
+				}
+				if records != nil {
+					fmt.Printf("Pod: %s, container: %s, saved to %s\n", t.Pod, t.Container, filepath.Base(records.name))
+					continue
+				}
+				fmt.Printf("Pod: %s, container: %s, saved to %s\n", t.Pod, t.Container, t.fileName())
+			}
+		}()
+	}
+	wg.Wait()
 
-		// Write the header to the tar archive
-		_ = twFile.WriteHeader(hdr)
+	if records != nil {
+		if err := records.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %d records to %s.\n", records.count, records.name)
+	}
 
-		// Copy the file contents to the tar archive
-		if _, err := io.Copy(twFile, f); err != nil {
-			return fmt.Errorf("error copying the file contents to the tar archive. %w", err)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("collecting the logs was interrupted. %w", err)
+	}
+	dest := logdir
+	if opts.Archive != nil {
+		dest = filepath.Join(opts.Archive.Path, logdir)
+	}
+	fmt.Printf("saved %d of %d logs to %s.\n", len(targets)-failed-unmatched, len(targets), dest)
+	if unmatched > 0 {
+		fmt.Printf("%d logs had no lines matching --grep.\n", unmatched)
+	}
+	return nil
+}
+
+// Streams the log of the target to its file in the folder "logdir", or to the archive at that
+// path when opts.Archive is set, through the filter when one is set. Returns false when no line
+// matched --grep and no file was saved
+func saveLog(ctx context.Context, t logTarget, opts logOptions, logdir string, clientset kubernetes.Interface) (bool, error) {
+	// open the stream first so no empty file is left for a container that hasn't started
+	stream, err := clientset.CoreV1().Pods(t.Namespace).GetLogs(t.Pod, opts.podLogOptions(t.Container, t.Previous)).Stream(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	name := filepath.Join(logdir, t.fileName())
+	var (
+		out     io.WriteCloser
+		discard func() error
+	)
+	if opts.Archive != nil {
+		entry := opts.Archive.Create(name)
+		out = entry
+		discard = func() error { entry.Discard(); return nil }
+	} else {
+		file, err := os.Create(name)
+		if err != nil {
+			return false, fmt.Errorf("error creating the file. %w", err)
 		}
+		out = file
+		discard = func() error { file.Close(); return os.Remove(name) }
+	}
+
+	// the secrets are replaced before the lines reach the file
+	w := opts.Redactor.Writer(name, out)
+
+	kept := 0
+	if !opts.Filter.active() {
+		_, err = io.Copy(w, stream)
+	} else {
+		// write the lines kept by the filter, the file is dropped when --grep matched nothing
+		err = opts.Filter.scan(stream, func(_ time.Time, line string) error {
+			kept++
+			_, err := io.WriteString(w, line+"\n")
+			return err
+		})
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		// keep what was written so far, like a log cut short by Ctrl-C
+		out.Close()
+		return false, fmt.Errorf("error writing the log to %s. %w", name, err)
+	}
+	if kept == 0 && opts.Filter.Grep != nil {
+		return false, discard()
+	}
+	return true, out.Close()
+}
+
+// SaveLogs saves the logs of every container in namespace "ns" to the folder "logdir", the last
+// "tailLines" lines of each, 0 for the whole log. The previous instances of restarted containers
+// are included and the secrets are replaced when "redactor" is set. Used by the support bundle
+func SaveLogs(ctx context.Context, clientset kubernetes.Interface, ns string, logdir string, tailLines int64, redactor *root.Redactor) error {
+	log.Println("SaveLogs function called.")
 
-		return nil
-	})
-	return err
+	pods, err := getPods(ctx, ns, "", clientset)
+	if err != nil {
+		return err
+	}
+	return getLogs(ctx, pods, logOptions{TailLines: tailLines, Previous: true, Workers: 5, Redactor: redactor}, logdir, clientset)
 }
@@ -1,13 +1,17 @@
 package logs
 
 import (
-	"errors"
-	"fmt"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	root "github.com/dilerous/cnvrgctl/cmd"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -76,7 +80,9 @@ func TestGetPods(t *testing.T) {
 			logDir := "./logs"
 
 			pods, err := getPods(
+				context.Background(),
 				test.targetNamespace,
+				"",
 				fakeClientset,
 			)
 
@@ -86,7 +92,7 @@ func TestGetPods(t *testing.T) {
 				}
 			}
 
-			err = getLogs(pods, 10, logDir, fakeClientset)
+			err = getLogs(context.Background(), pods, logOptions{TailLines: 10, Workers: 2}, logDir, fakeClientset)
 			if err != nil {
 				t.Fatalf("logs not gathered: %v", err)
 			}
@@ -95,6 +101,97 @@ func TestGetPods(t *testing.T) {
 	}
 }
 
+func TestGetLogsContainers(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers:     []corev1.Container{{Name: "app"}, {Name: "proxy"}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 3}, {Name: "proxy"}},
+		},
+	}
+
+	// one target per container and one for the previous instance of the restarted app container
+	expected := []string{"app-1_migrate.txt", "app-1_app.txt", "app-1_app_previous.txt", "app-1_proxy.txt"}
+	var names []string
+	for _, target := range logTargets([]corev1.Pod{*pod}, true) {
+		names = append(names, target.fileName())
+	}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected the logs %v, got %v", expected, names)
+	}
+	if targets := logTargets([]corev1.Pod{*pod}, false); len(targets) != 3 {
+		t.Fatalf("expected 3 logs without the previous instances, got %d", len(targets))
+	}
+
+	logDir := t.TempDir()
+	err := getLogs(context.Background(), []corev1.Pod{*pod}, logOptions{TailLines: 10, Previous: true, Workers: 3}, logDir, fake.NewSimpleClientset(pod))
+	if err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	for _, name := range expected {
+		b, err := os.ReadFile(filepath.Join(logDir, name))
+		if err != nil || string(b) != "fake logs" {
+			t.Errorf("expected %s to hold the fake logs, got %q %v", name, string(b), err)
+		}
+	}
+
+	// the secrets are replaced and counted for each file
+	logDir = t.TempDir()
+	redactor := root.NewRedactor("fake logs")
+	err = getLogs(context.Background(), []corev1.Pod{*pod}, logOptions{Workers: 3, Redactor: redactor}, logDir, fake.NewSimpleClientset(pod))
+	if err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(logDir, "app-1_app.txt"))
+	if err != nil || string(b) != "[REDACTED]" {
+		t.Fatalf("expected the log redacted, got %q %v", string(b), err)
+	}
+	if counts := redactor.Counts(); len(counts) != 3 || counts[filepath.Join(logDir, "app-1_app.txt")] != 1 {
+		t.Fatalf("expected one redaction in each of the 3 files, got %v", counts)
+	}
+}
+
+func TestGetNamespaceLogs(t *testing.T) {
+	cnvrgPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	jobPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-1", Namespace: "cnvrg-jobs"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+	}
+	clientset := fake.NewSimpleClientset(cnvrgPod, jobPod)
+
+	// each namespace is saved to its own folder
+	logDir := t.TempDir()
+	opts := logOptions{Workers: 2, PerNamespace: true}
+	pods, err := getNamespaceLogs(context.Background(), []string{"cnvrg", "cnvrg-jobs"}, opts, logDir, clientset)
+	if err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	if len(pods) != 2 {
+		t.Fatalf("expected the pods of both namespaces, got %d", len(pods))
+	}
+	for _, name := range []string{"cnvrg/app-1_app.txt", "cnvrg-jobs/job-1_main.txt"} {
+		if _, err := os.Stat(filepath.Join(logDir, name)); err != nil {
+			t.Errorf("expected %s to be saved: %v", name, err)
+		}
+	}
+
+	// only -n keeps the logs at the root of the folder
+	logDir = t.TempDir()
+	_, err = getNamespaceLogs(context.Background(), []string{"cnvrg"}, logOptions{Workers: 2}, logDir, clientset)
+	if err != nil {
+		t.Fatalf("logs not gathered: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "app-1_app.txt")); err != nil {
+		t.Errorf("expected the logs at the root of the folder: %v", err)
+	}
+}
+
 /*
 func TestGetLogsError(t *testing.T) {
 
@@ -112,92 +209,140 @@ func TestGetLogsError(t *testing.T) {
 }
 */
 
-func TestCreateTar(t *testing.T) {
-
-	t.Run("when days is just 30", func(t *testing.T) {
-		// Call the function to be tested
-		err := createTar()
+// reads the names and contents of the files in the tar.gz or zip archive
+func readArchiveFiles(t *testing.T, path string) map[string]string {
+	files := map[string]string{}
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
 		if err != nil {
-			t.Fatalf("Error creating tar: %v", err)
+			t.Fatalf("archive not valid: %v", err)
 		}
-		//Check if the tar file was created
-		if _, err := os.Stat("logs.tar.gz"); os.IsNotExist(err) {
-			t.Fatalf("Expected tar file logs.tar.gz does not exist")
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(r)
+			r.Close()
+			files[f.Name] = string(b)
 		}
-	})
-}
+		return files
+	}
 
-func TestCreateTarError(t *testing.T) {
-	// Create a directory with invalid permissions to force an error
-	err := os.Mkdir("testDir", 0000)
+	f, err := os.Open(path)
 	if err != nil {
-		t.Fatalf("Error creating test directory: %v", err)
+		t.Fatalf("archive not created: %v", err)
 	}
-	defer os.RemoveAll("testDir")
-
-	// Call the function to be tested
-	err = createTar()
-	if err == nil {
-		t.Fatal("Expected error, got nil")
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("archive is not gzipped: %v", err)
 	}
-	actualErrorMessage := err.Error()
-	expectedError := "error creating the tar file, logs.tar.gz. error walking the file path. open testDir: permission denied"
-
-	// check the specific error is returned
-	if actualErrorMessage != expectedError {
-		t.Errorf("Expected error message '%s', got '%s'", expectedError, actualErrorMessage)
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("error reading the archive: %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
 	}
 }
 
-func TestCreateTarGzError(t *testing.T) {
-
-	badSource := "./missing-folder"
-	badTarget := "/-asdf.tar.gz"
-
-	// cause an error by trying to create a file with an invalid name
-	err := createTarGz(badSource, badTarget)
-	if err == nil {
-		t.Fatal("expected error, got nil")
+func TestGetLogsArchive(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "proxy"}}},
 	}
+	clientset := fake.NewSimpleClientset(pod)
 
-	actualErrorMessage := err.Error()
-	expectedError := fmt.Sprintf("file creation failed, the name of the file is %v. open %v: read-only file system", badTarget, badTarget)
+	for _, format := range []string{root.ArchiveTarGz, root.ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			archive, err := root.CreateArchive(dir, "cnvrg-logs-test", format)
+			if err != nil {
+				t.Fatal(err)
+			}
 
-	// check the specific error is returned
-	if actualErrorMessage != expectedError {
-		t.Errorf("Expected error message '%s', got '%s'", expectedError, actualErrorMessage)
-	}
+			// the logs go straight to the archive, in the folder of the namespace
+			opts := logOptions{Workers: 2, PerNamespace: true, Archive: archive, Redactor: root.NewRedactor("fake logs")}
+			_, err = getNamespaceLogs(context.Background(), []string{"cnvrg"}, opts, "", clientset)
+			if err != nil {
+				t.Fatalf("logs not gathered: %v", err)
+			}
+			if err := archive.Close(); err != nil {
+				t.Fatalf("archive not written: %v", err)
+			}
 
-	// Create a temporary directory
-	tmpDir := t.TempDir()
+			// nothing but the archive is written
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 || entries[0].Name() != "cnvrg-logs-test."+format {
+				t.Fatalf("expected only the archive in the folder, got %v", entries)
+			}
+			files := readArchiveFiles(t, archive.Path)
+			for _, name := range []string{"cnvrg-logs-test/cnvrg/app-1_app.txt", "cnvrg-logs-test/cnvrg/app-1_proxy.txt"} {
+				if files[name] != "[REDACTED]" {
+					t.Errorf("expected %s redacted in the archive, got %q in %v", name, files[name], files)
+				}
+			}
+		})
+	}
+}
 
-	// Create a test .txt file
-	testFilePath := filepath.Join(tmpDir, "test.txt")
-	testFile, err := os.Create(testFilePath)
+func TestArchiveDirCurrentFolder(t *testing.T) {
+	// --log-dir . archives the current folder
+	logDir := filepath.Join(t.TempDir(), "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logDir, "app-1_app.txt"), []byte("started"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
 	if err != nil {
-		t.Fatalf("failed to create test file: %v", err)
+		t.Fatal(err)
 	}
-	defer testFile.Close()
-
-	_, err = fmt.Fprintf(testFile, "Hello, world!")
+	if err := os.Chdir(logDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	cwd, err := os.Getwd()
 	if err != nil {
-		t.Fatalf("failed to write content to test file: %v", err)
+		t.Fatal(err)
 	}
 
-	expectedErr := errors.New("error walking the file path. lstat /nonexistent/directory: no such file or directory")
-	err = createTarGz("/nonexistent/directory", "test.tar.gz")
-	if err == nil || err.Error() != expectedErr.Error() {
-		t.Errorf("Expected error '%v', got '%v'", expectedErr, err)
+	dir, err := archiveDir(".")
+	if err != nil {
+		t.Fatalf("expected a folder for the archive: %v", err)
+	}
+	if dir != filepath.Dir(cwd) {
+		t.Fatalf("expected the archive next to the current folder %s, got %s", filepath.Dir(cwd), dir)
 	}
 
-	// Simulate an error during header writing
-	testFile, _ = os.Create(testFilePath)
-	testFile.Close() // Close the file to simulate a failure to open it for writing
-	err = createTarGz(tmpDir, testFilePath)
-	if err == nil || !strings.Contains(err.Error(), "error copying the file contents to the tar archive. archive/tar: write too long") {
-		t.Errorf("Expected error containing 'error writing the header to the tar archive', got '%v'", err)
+	// the archive isn't written inside the folder it archives
+	archive, err := root.CreateArchive(dir, "cnvrg-logs-test", root.ArchiveTarGz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.AddDir("."); err != nil {
+		t.Fatalf("folder not archived: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("archive not written: %v", err)
+	}
+	files := readArchiveFiles(t, archive.Path)
+	if len(files) != 1 || files["cnvrg-logs-test/app-1_app.txt"] != "started" {
+		t.Fatalf("expected only the log file in the archive, got %v", files)
 	}
 
+	// the root folder has no parent to save the archive to
+	if _, err := archiveDir("/"); err == nil {
+		t.Fatal("expected an error for the root folder")
+	}
 }
 
 /*
@@ -0,0 +1,230 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// workloadCmd represents the logs workload command
+var workloadCmd = &cobra.Command{
+	Use:   "workload <id>",
+	Short: "Collect the logs and events of a cnvrg experiment, workspace or endpoint by its id",
+	Long: `Find the pods cnvrg created for an experiment, workspace, endpoint or any other job
+by the id in their labels and save their logs and events. Pods that completed or
+failed are included as long as they still exist, and the events of the jobs and
+replica sets owning the pods and of the objects named after the id, like <id>-worker,
+are saved with the events of its pods.
+
+The output is kept apart from the control plane logs, in
+./<log-dir>/workloads/<id>/<namespace>/ with one file per container and events.yaml.
+
+By default every namespace is searched, use --workload-namespaces to only search
+some of them. The pods are found by the labels in --label-keys.
+
+Usage:
+  cnvrgctl logs workload <id> [flags]
+
+Examples:
+  # Collect the logs and events of the experiment with the id d7jmfgfe4mbafidrz8qp.
+  cnvrgctl logs workload d7jmfgfe4mbafidrz8qp
+
+  # Only search the cnvrg namespace and keep the last 500 lines of each container.
+  cnvrgctl logs workload d7jmfgfe4mbafidrz8qp --workload-namespaces=cnvrg -l=500`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("called the logs workload command.")
+
+		id := args[0]
+		logDir, _ := cmd.Flags().GetString("log-dir")
+		lines, _ := cmd.Flags().GetInt("lines")
+		keys, _ := cmd.Flags().GetStringSlice("label-keys")
+		namespaces, _ := cmd.Flags().GetStringSlice("workload-namespaces")
+		noRedact, _ := cmd.Flags().GetBool("no-redact")
+
+		// the id goes into the label selector and the log folder, it must be a label value
+		err := validateWorkloadID(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+
+		// calls connect function to set the clientset for kubectl access
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to cluster, check your connectivity. %v\n", err)
+			return
+		}
+
+		pods, err := findWorkloadPods(api.Context(), api.Client, namespaces, keys, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error finding the pods of workload %s. %v\n", id, err)
+			return
+		}
+		if len(pods) == 0 {
+			fmt.Printf("no pods found with the id %s in the labels %s.\n", id, strings.Join(keys, ", "))
+		}
+
+		opts := logOptions{TailLines: int64(lines), Previous: true, Workers: 5}
+
+		// replace the secrets of the namespaces the workload runs in unless --no-redact is set
+		if !noRedact {
+			opts.Redactor, err = root.NewNamespaceRedactor(api.Context(), api.Client, podNamespaces(pods)...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+		}
+
+		dir := filepath.Join(logDir, "workloads", id)
+		err = saveWorkload(api.Context(), api.Client, pods, id, opts, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error collecting workload %s. %v\n", id, err)
+			return
+		}
+		opts.Redactor.PrintReport(os.Stdout)
+		fmt.Printf("the logs and events of workload %s were saved to %s.\n", id, dir)
+	},
+}
+
+func init() {
+	// Adds the workload command under the logs command
+	logsCmd.AddCommand(workloadCmd)
+
+	// Add the flag --label-keys to define the labels holding the job id
+	workloadCmd.Flags().StringSlice("label-keys", []string{"job-id", "cnvrg.io/job-id"}, "The pod labels cnvrg sets to the id of the workload, the pods matching any of them are collected")
+
+	// Add the flag --workload-namespaces to limit the namespaces searched
+	workloadCmd.Flags().StringSlice("workload-namespaces", []string{}, "The namespaces to search for the workload, every namespace when not set")
+
+	// Add the flag --lines to define the number of lines of each log
+	workloadCmd.Flags().IntP("lines", "l", 0, "The number of lines of each container log, 0 for the whole log")
+
+	// Add the flag --no-redact to write the logs without replacing the secrets
+	workloadCmd.Flags().Bool("no-redact", false, "Don't replace the secrets of the namespaces, keys, tokens and passwords with [REDACTED]")
+}
+
+// Checks the workload id "id" is a valid label value, so it can't change the label selector or
+// point the log folder outside of ./<log-dir>/workloads
+func validateWorkloadID(id string) error {
+	if id == "" {
+		return fmt.Errorf("the workload id is empty")
+	}
+	if errs := validation.IsValidLabelValue(id); len(errs) > 0 {
+		return fmt.Errorf("%q is not a valid workload id. %s", id, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Returns the pods with the label "key=id" for any of the keys, in the namespaces or in every
+// namespace when none is given. A pod matching several keys is only returned once
+func findWorkloadPods(ctx context.Context, clientset kubernetes.Interface, namespaces []string, keys []string, id string) ([]corev1.Pod, error) {
+	log.Println("findWorkloadPods function called.")
+
+	if len(namespaces) == 0 {
+		namespaces = []string{v1.NamespaceAll}
+	}
+
+	var pods []corev1.Pod
+	seen := map[string]bool{}
+	for _, ns := range namespaces {
+		for _, key := range keys {
+			list, err := clientset.CoreV1().Pods(ns).List(ctx, v1.ListOptions{LabelSelector: key + "=" + id})
+			if err != nil {
+				return nil, fmt.Errorf("error listing the pods with the label %s=%s. %w", key, id, err)
+			}
+			for _, pod := range list.Items {
+				if seen[pod.Namespace+"/"+pod.Name] {
+					continue
+				}
+				seen[pod.Namespace+"/"+pod.Name] = true
+				fmt.Printf("pod name: %s, namespace: %s, phase: %s\n", pod.Name, pod.Namespace, pod.Status.Phase)
+				pods = append(pods, pod)
+			}
+		}
+	}
+	return pods, nil
+}
+
+// Checks the object "name" is named after the workload "id", the id alone or with a prefix or
+// suffix like <id>-worker or tensorboard-<id>. An id in the middle of another name isn't matched
+func namedAfterWorkload(name string, id string) bool {
+	return name == id || strings.HasPrefix(name, id+"-") || strings.HasSuffix(name, "-"+id)
+}
+
+// the namespaces of the pods, sorted
+func podNamespaces(pods []corev1.Pod) []string {
+	var namespaces []string
+	for _, pod := range pods {
+		if !root.Contains(namespaces, pod.Namespace) {
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Saves the logs of the pods and the events of the workload to "dir", in a folder per namespace.
+// The events are the ones of the pods, of their owners and of the objects named after the id,
+// like the deployment or job cnvrg created for the workload
+func saveWorkload(ctx context.Context, clientset kubernetes.Interface, pods []corev1.Pod, id string, opts logOptions, dir string) error {
+	log.Println("saveWorkload function called.")
+
+	for _, ns := range podNamespaces(pods) {
+		var nsPods []corev1.Pod
+		// the pods and the objects that own them, like the job or the replica set
+		objects := map[string]bool{}
+		for _, pod := range pods {
+			if pod.Namespace == ns {
+				nsPods = append(nsPods, pod)
+				objects[pod.Name] = true
+				for _, owner := range pod.OwnerReferences {
+					objects[owner.Name] = true
+				}
+			}
+		}
+
+		nsDir := filepath.Join(dir, ns)
+		err := getLogs(ctx, nsPods, opts, nsDir, clientset)
+		if err != nil {
+			return err
+		}
+
+		list, err := clientset.CoreV1().Events(ns).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing the events in namespace %v. %w", ns, err)
+		}
+		var events []corev1.Event
+		for _, event := range list.Items {
+			if objects[event.InvolvedObject.Name] || namedAfterWorkload(event.InvolvedObject.Name, id) {
+				events = append(events, event)
+			}
+		}
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+		})
+
+		data, err := yaml.Marshal(events)
+		if err != nil {
+			return fmt.Errorf("error converting the events to yaml. %w", err)
+		}
+		file := filepath.Join(nsDir, "events.yaml")
+		err = os.WriteFile(file, []byte(opts.Redactor.Redact(file, string(data))), 0644)
+		if err != nil {
+			return fmt.Errorf("error saving the events. %w", err)
+		}
+		fmt.Printf("saved %d events to %s\n", len(events), file)
+	}
+	return nil
+}
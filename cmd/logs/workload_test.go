@@ -0,0 +1,119 @@
+package logs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkloadLogs(t *testing.T) {
+	id := "d7jmfgfe4mbafidrz8qp"
+	objects := []runtime.Object{
+		// the experiment pod completed, a second pod of the job has the id under another label
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "worker-x7k2p", Namespace: "cnvrg-jobs", Labels: map[string]string{"job-id": id},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "train-4f9a"}},
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "tensorboard-1", Namespace: "cnvrg", Labels: map[string]string{"cnvrg.io/job-id": id, "job-id": id}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "tensorboard"}}},
+		},
+		// the control plane isn't part of the workload, even with the id in the app label
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg", Labels: map[string]string{"app": id}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pod-event", Namespace: "cnvrg-jobs"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "worker-x7k2p"},
+			Reason:         "Scheduled",
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "owner-event", Namespace: "cnvrg-jobs"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "train-4f9a"},
+			Reason:         "SuccessfulCreate",
+		},
+		// an object with the id in the middle of its name isn't part of the workload
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "other-event", Namespace: "cnvrg-jobs"},
+			InvolvedObject: corev1.ObjectReference{Kind: "ConfigMap", Name: "copy" + id + "backup"},
+			Reason:         "Unrelated",
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "job-event", Namespace: "cnvrg-jobs"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: id},
+			Reason:         "Completed",
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "app-event", Namespace: "cnvrg"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "app-1"},
+			Reason:         "Pulled",
+		},
+	}
+	client := fake.NewSimpleClientset(objects...)
+
+	pods, err := findWorkloadPods(context.Background(), client, nil, []string{"job-id", "cnvrg.io/job-id"}, id)
+	if err != nil {
+		t.Fatalf("pods not found: %v", err)
+	}
+	if len(pods) != 2 {
+		t.Fatalf("expected the 2 pods of the workload once each, got %d", len(pods))
+	}
+	if ns := podNamespaces(pods); strings.Join(ns, ",") != "cnvrg,cnvrg-jobs" {
+		t.Fatalf("unexpected namespaces %v", ns)
+	}
+
+	// only the namespaces searched
+	pods, err = findWorkloadPods(context.Background(), client, []string{"cnvrg-jobs"}, []string{"job-id", "cnvrg.io/job-id"}, id)
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("expected the pod in cnvrg-jobs, got %d %v", len(pods), err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "workloads", id)
+	err = saveWorkload(context.Background(), client, pods, id, logOptions{Previous: true, Workers: 2}, dir)
+	if err != nil {
+		t.Fatalf("workload not saved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cnvrg-jobs", "worker-x7k2p_main.txt")); err != nil {
+		t.Fatalf("expected the log of the completed pod: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "cnvrg-jobs", "events.yaml"))
+	if err != nil {
+		t.Fatalf("events not saved: %v", err)
+	}
+	for _, reason := range []string{"Scheduled", "SuccessfulCreate", "Completed"} {
+		if !strings.Contains(string(b), reason) {
+			t.Errorf("expected the %s event in %s", reason, b)
+		}
+	}
+	for _, reason := range []string{"Pulled", "Unrelated"} {
+		if strings.Contains(string(b), reason) {
+			t.Errorf("expected no %s event in %s", reason, b)
+		}
+	}
+}
+
+func TestValidateWorkloadID(t *testing.T) {
+	for _, id := range []string{"d7jmfgfe4mbafidrz8qp", "app-1.v2_x"} {
+		if err := validateWorkloadID(id); err != nil {
+			t.Errorf("expected %q to be valid: %v", id, err)
+		}
+	}
+	// ids that change the selector or the log folder are rejected
+	for _, id := range []string{"", "x,app=webapp", "../../etc", "a b", "x!=y", strings.Repeat("a", 64)} {
+		if err := validateWorkloadID(id); err == nil {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+}
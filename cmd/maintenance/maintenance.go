@@ -0,0 +1,186 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package maintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// name of the configmap recording that maintenance mode is on
+const configMapName = "cnvrgctl-maintenance"
+
+// keys of the maintenance configmap
+const (
+	keyEnabledBy   = "enabled-by"
+	keyEnabledAt   = "enabled-at"
+	keyReason      = "reason"
+	keyIngress     = "ingress"
+	keyIngressSpec = "ingress-spec"
+)
+
+// maintenanceCmd represents the maintenance command
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Put cnvrg.io in and out of maintenance mode for downtime windows",
+	Long: `Maintenance mode scales down the same workloads as a backup or restore so the
+app is quiesced for a storage migration, database maintenance or any other
+downtime window. Who enabled it and when is recorded in the cnvrgctl-maintenance
+configmap. The app ingress can optionally be pointed at a maintenance page
+service while maintenance mode is on.
+
+Examples:
+
+# Turn on maintenance mode in the cnvrg namespace.
+  cnvrgctl maintenance on -n cnvrg --reason "storage migration"
+
+# Show who turned on maintenance mode and the saved replica counts.
+  cnvrgctl maintenance status -n cnvrg
+
+# Turn off maintenance mode and scale cnvrg.io back up.
+  cnvrgctl maintenance off -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("called the maintenance command")
+	},
+}
+
+func init() {
+	root.RootCmd.AddCommand(maintenanceCmd)
+}
+
+// gets the maintenance configmap in namespace "ns", returns nil if maintenance mode is off
+func getMaintenanceRecord(api *root.KubernetesAPI, ns string) (*corev1.ConfigMap, error) {
+	log.Println("getMaintenanceRecord function called.")
+
+	cm, err := api.Client.CoreV1().ConfigMaps(ns).Get(api.Context(), configMapName, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting the configmap %v. %w", configMapName, err)
+	}
+	return cm, nil
+}
+
+// creates or updates the maintenance configmap in namespace "ns" with "data"
+func saveMaintenanceRecord(api *root.KubernetesAPI, ns string, data map[string]string) error {
+	log.Println("saveMaintenanceRecord function called.")
+
+	cm, err := getMaintenanceRecord(api, ns)
+	if err != nil {
+		return err
+	}
+
+	// create the configmap the first time maintenance mode is turned on
+	if cm == nil {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      configMapName,
+				Namespace: ns,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "cnvrgctl"},
+			},
+			Data: data,
+		}
+		_, err = api.Client.CoreV1().ConfigMaps(ns).Create(api.Context(), cm, v1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating the configmap %v. %w", configMapName, err)
+		}
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	for k, v := range data {
+		cm.Data[k] = v
+	}
+	_, err = api.Client.CoreV1().ConfigMaps(ns).Update(api.Context(), cm, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error updating the configmap %v. %w", configMapName, err)
+	}
+	return nil
+}
+
+// Returns the spec of the ingress "name" as json, saved before the ingress is pointed at the
+// maintenance page so it can be restored
+func getIngressSpec(api *root.KubernetesAPI, ns string, name string) (string, error) {
+	log.Println("getIngressSpec function called.")
+
+	ingress, err := api.Client.NetworkingV1().Ingresses(ns).Get(api.Context(), name, v1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting the ingress %v. %w", name, err)
+	}
+	spec, err := json.Marshal(ingress.Spec)
+	if err != nil {
+		return "", fmt.Errorf("error saving the spec of the ingress %v. %w", name, err)
+	}
+	return string(spec), nil
+}
+
+// Points every backend of the ingress "name" at the maintenance page service "svc" on "port".
+// The spec must be saved with getIngressSpec first, running it again is harmless
+func swapIngressBackend(api *root.KubernetesAPI, ns string, name string, svc string, port int32) error {
+	log.Println("swapIngressBackend function called.")
+
+	ingress, err := api.Client.NetworkingV1().Ingresses(ns).Get(api.Context(), name, v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting the ingress %v. %w", name, err)
+	}
+
+	backend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: svc,
+			Port: networkingv1.ServiceBackendPort{Number: port},
+		},
+	}
+
+	if ingress.Spec.DefaultBackend != nil {
+		ingress.Spec.DefaultBackend = backend.DeepCopy()
+	}
+	for i := range ingress.Spec.Rules {
+		if ingress.Spec.Rules[i].HTTP == nil {
+			continue
+		}
+		for j := range ingress.Spec.Rules[i].HTTP.Paths {
+			ingress.Spec.Rules[i].HTTP.Paths[j].Backend = *backend.DeepCopy()
+		}
+	}
+
+	_, err = api.Client.NetworkingV1().Ingresses(ns).Update(api.Context(), ingress, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error pointing the ingress %v at the maintenance page. %w", name, err)
+	}
+	return nil
+}
+
+// Restores the ingress "name" to the json "spec" saved by swapIngressBackend
+func restoreIngressBackend(api *root.KubernetesAPI, ns string, name string, spec string) error {
+	log.Println("restoreIngressBackend function called.")
+
+	ingress, err := api.Client.NetworkingV1().Ingresses(ns).Get(api.Context(), name, v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting the ingress %v. %w", name, err)
+	}
+
+	original := networkingv1.IngressSpec{}
+	err = json.Unmarshal([]byte(spec), &original)
+	if err != nil {
+		return fmt.Errorf("error reading the saved spec of the ingress %v. %w", name, err)
+	}
+	ingress.Spec = original
+
+	_, err = api.Client.NetworkingV1().Ingresses(ns).Update(api.Context(), ingress, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error restoring the ingress %v. %w", name, err)
+	}
+	return nil
+}
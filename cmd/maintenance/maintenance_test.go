@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSwapAndRestoreIngressBackend(t *testing.T) {
+	original := networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{{
+			Host: "app.cnvrg.example.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path: "/",
+					Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: "app",
+						Port: networkingv1.ServiceBackendPort{Number: 8080},
+					}},
+				}},
+			}},
+		}},
+	}
+	client := fake.NewSimpleClientset(&networkingv1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "cnvrg"},
+		Spec:       *original.DeepCopy(),
+	})
+	api := &root.KubernetesAPI{Client: client}
+
+	saved, err := getIngressSpec(api, "cnvrg", "app")
+	if err != nil {
+		t.Fatalf("error saving the ingress spec: %v", err)
+	}
+	err = swapIngressBackend(api, "cnvrg", "app", "maintenance-page", 80)
+	if err != nil {
+		t.Fatalf("error swapping the ingress backend: %v", err)
+	}
+
+	// swapping again keeps the maintenance page
+	err = swapIngressBackend(api, "cnvrg", "app", "maintenance-page", 80)
+	if err != nil {
+		t.Fatalf("error swapping the ingress backend again: %v", err)
+	}
+
+	ingress, _ := client.NetworkingV1().Ingresses("cnvrg").Get(context.Background(), "app", v1.GetOptions{})
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if backend.Name != "maintenance-page" || backend.Port.Number != 80 {
+		t.Fatalf("expected the maintenance-page service on port 80, got %s on port %d", backend.Name, backend.Port.Number)
+	}
+
+	err = restoreIngressBackend(api, "cnvrg", "app", saved)
+	if err != nil {
+		t.Fatalf("error restoring the ingress backend: %v", err)
+	}
+
+	ingress, _ = client.NetworkingV1().Ingresses("cnvrg").Get(context.Background(), "app", v1.GetOptions{})
+	if !reflect.DeepEqual(ingress.Spec, original) {
+		t.Fatalf("expected the original spec %+v, got %+v", original, ingress.Spec)
+	}
+}
+
+func TestSaveMaintenanceRecord(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	api := &root.KubernetesAPI{Client: client}
+
+	record, err := getMaintenanceRecord(api, "cnvrg")
+	if err != nil || record != nil {
+		t.Fatalf("expected no record, got %v and %v", record, err)
+	}
+
+	// the configmap is created and later updates keep the existing keys
+	err = saveMaintenanceRecord(api, "cnvrg", map[string]string{keyEnabledBy: "admin@host"})
+	if err != nil {
+		t.Fatalf("error creating the record: %v", err)
+	}
+	err = saveMaintenanceRecord(api, "cnvrg", map[string]string{keyIngress: "app"})
+	if err != nil {
+		t.Fatalf("error updating the record: %v", err)
+	}
+
+	record, err = getMaintenanceRecord(api, "cnvrg")
+	if err != nil {
+		t.Fatalf("error getting the record: %v", err)
+	}
+	if record.Data[keyEnabledBy] != "admin@host" || record.Data[keyIngress] != "app" {
+		t.Fatalf("unexpected record data %v", record.Data)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package maintenance
+
+import (
+	"fmt"
+	"log"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// offCmd represents the maintenance off command
+var offCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Scale cnvrg.io back up and turn off maintenance mode",
+	Long: `Scales the workloads back to the replica counts saved by 'maintenance on',
+restores the app ingress if it was pointed at a maintenance page and removes the
+cnvrgctl-maintenance configmap.
+
+Examples:
+
+# Turn off maintenance mode in the cnvrg namespace.
+  cnvrgctl maintenance off -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("maintenance off command called")
+
+		// grab the namespace from the -n flag if not specified default is used
+		nsFlag, _ := cmd.Flags().GetString("namespace")
+
+		// connect to kubernetes and define clientset and rest client
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
+		record, err := getMaintenanceRecord(api, nsFlag)
+		if err != nil {
+			root.Fatalf("error checking if maintenance mode is on. %v", err)
+		}
+		if record == nil {
+			fmt.Println("maintenance mode is not on, scaling up any workloads with a saved replica count.")
+		}
+
+		err = root.ScaleDeployUp(api, nsFlag)
+		if err != nil {
+			root.Fatalf("there was a problem with scaling up the pods. %v", err)
+		}
+
+		if record == nil {
+			return
+		}
+
+		// only send traffic back to the app once it is available
+		if spec := record.Data[keyIngressSpec]; spec != "" {
+			err = restoreIngressBackend(api, nsFlag, record.Data[keyIngress], spec)
+			if err != nil {
+				root.Fatalf("error restoring the ingress. %v", err)
+			}
+			fmt.Printf("ingress %s is serving cnvrg.io again.\n", record.Data[keyIngress])
+		}
+
+		err = api.Client.CoreV1().ConfigMaps(nsFlag).Delete(api.Context(), configMapName, v1.DeleteOptions{})
+		if err != nil {
+			root.Fatalf("error removing the configmap %v. %v", configMapName, err)
+		}
+
+		fmt.Println("maintenance mode is off.")
+	},
+}
+
+func init() {
+	maintenanceCmd.AddCommand(offCmd)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package maintenance
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// onCmd represents the maintenance on command
+var onCmd = &cobra.Command{
+	Use:   "on",
+	Short: "Scale down cnvrg.io and record that maintenance mode is on",
+	Long: `Scales down the workloads in the quiesce set, saving their replica counts, and
+records who turned on maintenance mode and when in the cnvrgctl-maintenance
+configmap. Pass --ingress and --maintenance-service to point the app ingress at
+a maintenance page while cnvrg.io is down, the ingress is switched once the
+cnvrg-operator is scaled down so it can't switch it back. Running the command again is safe, the
+saved replica counts and ingress spec are not overwritten.
+
+Examples:
+
+# Turn on maintenance mode in the cnvrg namespace.
+  cnvrgctl maintenance on -n cnvrg --reason "storage migration"
+
+# Turn on maintenance mode and serve a maintenance page from the maintenance service.
+  cnvrgctl maintenance on -n cnvrg --ingress app --maintenance-service maintenance-page`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("maintenance on command called")
+
+		// grab the namespace from the -n flag if not specified default is used
+		nsFlag, _ := cmd.Flags().GetString("namespace")
+		reasonFlag, _ := cmd.Flags().GetString("reason")
+		ingressFlag, _ := cmd.Flags().GetString("ingress")
+		svcFlag, _ := cmd.Flags().GetString("maintenance-service")
+		portFlag, _ := cmd.Flags().GetInt32("maintenance-port")
+
+		if svcFlag != "" && ingressFlag == "" {
+			root.Fatalf("--ingress is required with --maintenance-service.")
+		}
+
+		// connect to kubernetes and define clientset and rest client
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
+		record, err := getMaintenanceRecord(api, nsFlag)
+		if err != nil {
+			root.Fatalf("error checking if maintenance mode is on. %v", err)
+		}
+
+		// keep who turned it on first when the command is run again
+		data := map[string]string{}
+		if record == nil {
+			data[keyEnabledBy] = root.Identity()
+			data[keyEnabledAt] = time.Now().UTC().Format(time.RFC3339)
+			data[keyReason] = reasonFlag
+		} else {
+			fmt.Printf("maintenance mode was already turned on by %s at %s.\n", record.Data[keyEnabledBy], record.Data[keyEnabledAt])
+		}
+
+		// scale down first, the cnvrg-operator is the first of the quiesce set and would switch
+		// the app ingress back to the app if it was still running when the ingress is swapped
+		err = root.ScaleDeployDown(api, nsFlag)
+		if err != nil {
+			root.Fatalf("there was a problem with scaling down the pods. %v", err)
+		}
+
+		// save the spec of the ingress before pointing it at the maintenance page, so maintenance
+		// off can always restore it. The spec saved the first time is kept when run again
+		if svcFlag != "" {
+			switch {
+			case record == nil || record.Data[keyIngressSpec] == "":
+				spec, err := getIngressSpec(api, nsFlag, ingressFlag)
+				if err != nil {
+					root.Fatalf("error saving the ingress spec. %v", err)
+				}
+				data[keyIngress] = ingressFlag
+				data[keyIngressSpec] = spec
+			case record.Data[keyIngress] != ingressFlag:
+				root.Fatalf("maintenance mode already switched the ingress %s, run 'cnvrgctl maintenance off' first.", record.Data[keyIngress])
+			}
+		}
+
+		// record maintenance mode before the ingress is changed so an interrupted swap still
+		// shows up in status and can be turned off
+		err = saveMaintenanceRecord(api, nsFlag, data)
+		if err != nil {
+			root.Fatalf("error recording maintenance mode. %v", err)
+		}
+
+		if svcFlag != "" {
+			err = swapIngressBackend(api, nsFlag, ingressFlag, svcFlag, portFlag)
+			if err != nil {
+				root.Fatalf("error switching the ingress to the maintenance page, run 'cnvrgctl maintenance off' to restore it. %v", err)
+			}
+			fmt.Printf("ingress %s is serving the maintenance page from service %s.\n", ingressFlag, svcFlag)
+		}
+
+		fmt.Println("maintenance mode is on, run 'cnvrgctl maintenance off' to bring cnvrg.io back.")
+	},
+}
+
+func init() {
+	maintenanceCmd.AddCommand(onCmd)
+
+	onCmd.Flags().StringP("reason", "", "", "Why maintenance mode was turned on, shown by maintenance status")
+	onCmd.Flags().StringP("ingress", "", "", "The app ingress to point at the maintenance page")
+	onCmd.Flags().StringP("maintenance-service", "", "", "The service serving the maintenance page")
+	onCmd.Flags().Int32P("maintenance-port", "", 80, "The port of the maintenance page service")
+}
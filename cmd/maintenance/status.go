@@ -0,0 +1,92 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package maintenance
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the maintenance status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show if maintenance mode is on and the saved replica counts",
+	Long: `Shows if maintenance mode is on, who turned it on, when and why, along with
+the replica counts saved on the scaled down workloads.
+
+Examples:
+
+# Show the maintenance mode status in the cnvrg namespace.
+  cnvrgctl maintenance status -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("maintenance status command called")
+
+		// grab the namespace from the -n flag if not specified default is used
+		nsFlag, _ := cmd.Flags().GetString("namespace")
+
+		// connect to kubernetes and define clientset and rest client
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to the cluster, check your connectivity. %v", err)
+			log.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		err = printStatus(api, nsFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting the maintenance status. %v", err)
+			log.Fatalf("error getting the maintenance status. %v", err)
+		}
+	},
+}
+
+func init() {
+	maintenanceCmd.AddCommand(statusCmd)
+}
+
+// prints the maintenance record and saved replica counts in namespace "ns"
+func printStatus(api *root.KubernetesAPI, ns string) error {
+	log.Println("printStatus function called.")
+
+	record, err := getMaintenanceRecord(api, ns)
+	if err != nil {
+		return err
+	}
+
+	if record == nil {
+		fmt.Printf("maintenance mode is off in namespace %s.\n", ns)
+	} else {
+		fmt.Printf("maintenance mode is on in namespace %s.\n", ns)
+		fmt.Printf("  enabled by: %s\n", record.Data[keyEnabledBy])
+		fmt.Printf("  enabled at: %s\n", record.Data[keyEnabledAt])
+		if reason := record.Data[keyReason]; reason != "" {
+			fmt.Printf("  reason:     %s\n", reason)
+		}
+		if ingress := record.Data[keyIngress]; ingress != "" {
+			fmt.Printf("  ingress:    %s is serving the maintenance page\n", ingress)
+		}
+	}
+
+	saved, err := root.GetSavedReplicas(api, ns)
+	if err != nil {
+		return err
+	}
+	if len(saved) == 0 {
+		fmt.Println("no workloads have a saved replica count.")
+		return nil
+	}
+
+	// print a table of the saved and current replica counts
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "WORKLOAD\tSAVED\tCURRENT")
+	for _, s := range saved {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", s.Workload, s.Saved, s.Current)
+	}
+	return w.Flush()
+}
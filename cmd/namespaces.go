@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// the cnvrg control plane custom resource, one per control plane namespace
+	CnvrgAppResource = schema.GroupVersionResource{Group: "mlops.cnvrg.io", Version: "v1", Resource: "cnvrgapps"}
+
+	// the cluster wide cnvrg services like monitoring, it is cluster scoped and names their namespace
+	// in spec.infraNamespace
+	CnvrgInfraResource = schema.GroupVersionResource{Group: "mlops.cnvrg.io", Version: "v1", Resource: "cnvrginfras"}
+)
+
+// the pods of the ingress controllers serving the cnvrg ingresses, found in any namespace
+var ingressSelectors = []string{"app.kubernetes.io/name=ingress-nginx", "app=istio-ingressgateway"}
+
+// NamespaceOptions are the namespaces picked with -n, --namespaces, --all-namespaces and --cnvrg
+type NamespaceOptions struct {
+	// the namespace set with -n, the only one when no other option is set
+	Namespace string
+
+	// the namespaces set with --namespaces
+	Namespaces []string
+
+	// every namespace of the cluster
+	All bool
+
+	// the namespaces of the cnvrg installation whose control plane is in Namespace
+	Cnvrg bool
+}
+
+// Adds the --namespaces, --all-namespaces and --cnvrg flags to the command, read them with
+// GetNamespaceOptions
+func AddNamespaceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("namespaces", []string{}, "The namespaces to collect, comma separated, defaults to the namespace set with -n")
+	cmd.Flags().Bool("all-namespaces", false, "Collect every namespace of the cluster")
+	cmd.Flags().Bool("cnvrg", false, "Collect every namespace of the cnvrg installation whose control plane is in the namespace set with -n")
+	cmd.MarkFlagsMutuallyExclusive("all-namespaces", "namespaces")
+	cmd.MarkFlagsMutuallyExclusive("all-namespaces", "cnvrg")
+}
+
+// Returns the namespace options set on the command with -n and the flags of AddNamespaceFlags
+func GetNamespaceOptions(cmd *cobra.Command) NamespaceOptions {
+	var o NamespaceOptions
+	o.Namespace, _ = cmd.Flags().GetString("namespace")
+	o.Namespaces, _ = cmd.Flags().GetStringSlice("namespaces")
+	o.All, _ = cmd.Flags().GetBool("all-namespaces")
+	o.Cnvrg, _ = cmd.Flags().GetBool("cnvrg")
+	return o
+}
+
+// true when more than the -n namespace may be collected, the output is then saved per namespace
+func (o NamespaceOptions) Multiple() bool {
+	return len(o.Namespaces) > 0 || o.All || o.Cnvrg
+}
+
+// Returns the sorted namespaces picked by the options. --cnvrg adds the namespaces of the
+// installation to the ones set with --namespaces, and -n is used when no option is set
+func ResolveNamespaces(ctx context.Context, clientset kubernetes.Interface, dyn dynamic.Interface, o NamespaceOptions) ([]string, error) {
+	log.Println("ResolveNamespaces function called.")
+
+	if !o.Multiple() {
+		return []string{o.Namespace}, nil
+	}
+
+	seen := map[string]bool{}
+	var namespaces []string
+	add := func(ns string) {
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	if o.All {
+		list, err := clientset.CoreV1().Namespaces().List(ctx, v1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing the namespaces. %w", err)
+		}
+		for _, ns := range list.Items {
+			add(ns.Name)
+		}
+	}
+
+	for _, ns := range o.Namespaces {
+		add(strings.TrimSpace(ns))
+	}
+
+	if o.Cnvrg {
+		found, err := DiscoverCnvrgNamespaces(ctx, clientset, dyn, o.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range sortedKeys(found) {
+			fmt.Printf("found cnvrg namespace %s: %s\n", ns, strings.Join(found[ns], ", "))
+			add(ns)
+		}
+	}
+
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// Returns the namespaces belonging to the cnvrg installation with the reasons each one was
+// picked. These are the control plane namespace, the namespaces holding a CnvrgApp or a
+// CnvrgInfra, the namespaces and the pods with cnvrg in their name, labels or annotations,
+// like the workload namespaces, and the namespaces of the ingress controllers.
+// A check that fails is reported as a warning and the namespaces found by the others are returned
+func DiscoverCnvrgNamespaces(ctx context.Context, clientset kubernetes.Interface, dyn dynamic.Interface, controlPlane string) (map[string][]string, error) {
+	log.Println("DiscoverCnvrgNamespaces function called.")
+
+	found := map[string][]string{}
+	add := func(ns string, reason string) {
+		if ns == "" || Contains(found[ns], reason) {
+			return
+		}
+		found[ns] = append(found[ns], reason)
+	}
+	add(controlPlane, "control plane")
+
+	// the custom resources, a cluster without the cnvrg operator doesn't have their CRDs
+	for _, cr := range []struct {
+		resource schema.GroupVersionResource
+		reason   string
+	}{{CnvrgAppResource, "CnvrgApp"}, {CnvrgInfraResource, "CnvrgInfra"}} {
+		list, err := dyn.Resource(cr.resource).Namespace(v1.NamespaceAll).List(ctx, v1.ListOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				fmt.Fprintf(os.Stderr, "warning: error listing the %s resources. %v\n", cr.reason, err)
+			}
+			continue
+		}
+		for _, item := range list.Items {
+			add(item.GetNamespace(), cr.reason)
+			infraNamespace, _, _ := unstructured.NestedString(item.Object, "spec", "infraNamespace")
+			add(infraNamespace, cr.reason)
+		}
+	}
+
+	// the namespaces created for cnvrg, like cnvrg-infra or the workload namespaces
+	nsList, err := clientset.CoreV1().Namespaces().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the namespaces. %w", err)
+	}
+	for _, ns := range nsList.Items {
+		if strings.Contains(ns.Name, "cnvrg") || mentionsCnvrg(ns.Labels) || mentionsCnvrg(ns.Annotations) {
+			add(ns.Name, "cnvrg namespace")
+		}
+	}
+
+	// the namespaces running cnvrg pods, the experiments, workspaces and endpoints
+	pods, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, v1.ListOptions{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: error listing the pods. %v\n", err)
+	} else {
+		for _, pod := range pods.Items {
+			if mentionsCnvrg(pod.Labels) {
+				add(pod.Namespace, "cnvrg pods")
+			}
+		}
+	}
+
+	// the ingress controllers, the requests to cnvrg go through them
+	for _, selector := range ingressSelectors {
+		pods, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, v1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: error listing the ingress controller pods. %v\n", err)
+			continue
+		}
+		for _, pod := range pods.Items {
+			add(pod.Namespace, "ingress controller")
+		}
+	}
+
+	return found, nil
+}
+
+// true when a key or a value of the labels or annotations has cnvrg in it
+func mentionsCnvrg(m map[string]string) bool {
+	for k, v := range m {
+		if strings.Contains(strings.ToLower(k), "cnvrg") || strings.Contains(strings.ToLower(v), "cnvrg") {
+			return true
+		}
+	}
+	return false
+}
+
+// true when "s" is in the list
+func Contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// the keys of the map, sorted
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveNamespaces(t *testing.T) {
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	pod := func(name string, ns string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: labels}}
+	}
+	clientset := fake.NewSimpleClientset(
+		namespace("cnvrg", nil),
+		namespace("cnvrg-infra", nil),
+		namespace("team-a", map[string]string{"owner": "Cnvrg"}),
+		namespace("gpu-jobs", nil),
+		namespace("ingress-nginx", nil),
+		namespace("monitoring", nil),
+		namespace("kube-system", nil),
+		pod("train-1", "gpu-jobs", map[string]string{"cnvrg.io/job-id": "abc"}),
+		pod("controller-1", "ingress-nginx", map[string]string{"app.kubernetes.io/name": "ingress-nginx"}),
+		pod("coredns-1", "kube-system", map[string]string{"k8s-app": "kube-dns"}),
+	)
+
+	// the CnvrgInfra is cluster scoped and names the namespace of the monitoring
+	infra := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"infraNamespace": "monitoring"}}}
+	infra.SetGroupVersionKind(schema.GroupVersionKind{Group: "mlops.cnvrg.io", Version: "v1", Kind: "CnvrgInfra"})
+	infra.SetName("cnvrg-infra")
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		CnvrgAppResource:   "CnvrgAppList",
+		CnvrgInfraResource: "CnvrgInfraList",
+	}, infra)
+
+	testCases := []struct {
+		name     string
+		opts     NamespaceOptions
+		expected string
+	}{
+		{"namespace_flag", NamespaceOptions{Namespace: "cnvrg"}, "cnvrg"},
+		{"namespaces_list", NamespaceOptions{Namespace: "cnvrg", Namespaces: []string{"b", " a", "b"}}, "a b"},
+		{"all_namespaces", NamespaceOptions{Namespace: "cnvrg", All: true}, "cnvrg cnvrg-infra gpu-jobs ingress-nginx kube-system monitoring team-a"},
+		{"cnvrg_preset", NamespaceOptions{Namespace: "cnvrg", Cnvrg: true}, "cnvrg cnvrg-infra gpu-jobs ingress-nginx monitoring team-a"},
+		{"cnvrg_preset_and_list", NamespaceOptions{Namespace: "cnvrg", Cnvrg: true, Namespaces: []string{"kube-system"}}, "cnvrg cnvrg-infra gpu-jobs ingress-nginx kube-system monitoring team-a"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			namespaces, err := ResolveNamespaces(context.Background(), clientset, dyn, tc.opts)
+			if err != nil {
+				t.Fatalf("namespaces not resolved: %v", err)
+			}
+			if got := strings.Join(namespaces, " "); got != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	// the reasons each namespace belongs to the installation
+	found, err := DiscoverCnvrgNamespaces(context.Background(), clientset, dyn, "cnvrg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"cnvrg":         "control plane, cnvrg namespace",
+		"monitoring":    "CnvrgInfra",
+		"gpu-jobs":      "cnvrg pods",
+		"ingress-nginx": "ingress controller",
+	}
+	for ns, reasons := range expected {
+		if got := strings.Join(found[ns], ", "); got != reasons {
+			t.Errorf("expected %s to be found for %q, got %q", ns, reasons, got)
+		}
+	}
+}
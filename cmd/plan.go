@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Plan is the list of steps a backup or restore would run, printed by --dry-run instead of changing anything
+type Plan struct {
+	Title string
+	Steps []*PlanStep
+}
+
+// PlanStep is a single action of a plan with the resources it touches
+type PlanStep struct {
+	Action  string
+	Details []string
+}
+
+// Creates an empty plan, the title names the command and namespace
+func NewPlan(format string, v ...interface{}) *Plan {
+	return &Plan{Title: fmt.Sprintf(format, v...)}
+}
+
+// Adds a step to the plan and returns it so details can be added
+func (p *Plan) Step(format string, v ...interface{}) *PlanStep {
+	step := &PlanStep{Action: fmt.Sprintf(format, v...)}
+	p.Steps = append(p.Steps, step)
+	return step
+}
+
+// Adds a detail line under the step
+func (s *PlanStep) Detail(format string, v ...interface{}) *PlanStep {
+	s.Details = append(s.Details, fmt.Sprintf(format, v...))
+	return s
+}
+
+// Prints the numbered steps of the plan to "w"
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s (dry run, nothing was changed):\n\n", p.Title)
+	for i, step := range p.Steps {
+		fmt.Fprintf(w, "%2d. %s\n", i+1, step.Action)
+		for _, detail := range step.Details {
+			fmt.Fprintf(w, "      - %s\n", detail)
+		}
+	}
+}
+
+// Adds a step for the hooks that would run at "phase", nothing is added if there are none
+func PlanHooks(plan *Plan, phase string) error {
+	hooks, err := GetHooks()
+	if err != nil {
+		return err
+	}
+
+	var step *PlanStep
+	for _, h := range hooks {
+		if h.Phase != phase {
+			continue
+		}
+		if step == nil {
+			step = plan.Step("run the %s hooks", phase)
+		}
+
+		switch h.Type {
+		case HookExec:
+			step.Detail("%s: exec %q in a pod matching %s (timeout %v, on error %s)", h.Name, strings.Join(h.Command, " "), h.Selector, h.Timeout, h.OnError)
+		case HookLocal:
+			step.Detail("%s: run %q locally (timeout %v, on error %s)", h.Name, strings.Join(h.Command, " "), h.Timeout, h.OnError)
+		case HookHTTP:
+			method := h.Method
+			if method == "" {
+				method = "POST"
+			}
+			step.Detail("%s: %s %s (timeout %v, on error %s)", h.Name, method, h.URL, h.Timeout, h.OnError)
+		}
+	}
+	return nil
+}
+
+// Adds the steps ScaleDeployDown would run in namespace "ns" to the plan, resolving the quiesce
+// set, the autoscalers to pause and the pods to wait for
+func PlanScaleDown(api *KubernetesAPI, ns string, plan *Plan) error {
+	log.Println("PlanScaleDown function called.")
+
+	workloads, err := ResolveQuiesceSet(api, ns, GetQuiesceConfig(), false)
+	if err != nil {
+		return err
+	}
+
+	err = PlanHooks(plan, PhasePreQuiesce)
+	if err != nil {
+		return err
+	}
+
+	// autoscalers targeting the workloads
+	hpas, err := api.Client.AutoscalingV2().HorizontalPodAutoscalers(ns).List(api.Context(), v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing the horizontal pod autoscalers in namespace %v. %w", ns, err)
+	}
+	targets := map[Workload]bool{}
+	for _, w := range workloads {
+		targets[w] = true
+	}
+	var step *PlanStep
+	for _, hpa := range hpas.Items {
+		ref := hpa.Spec.ScaleTargetRef
+		if !targets[Workload{Kind: ref.Kind, Name: ref.Name}] {
+			continue
+		}
+		if step == nil {
+			step = plan.Step("pause the horizontal pod autoscalers")
+		}
+		step.Detail("%s targeting %s/%s", hpa.Name, strings.ToLower(ref.Kind), ref.Name)
+	}
+
+	// the replicas that would be saved and the pods that would be stopped
+	step = plan.Step("save the replica counts and scale %d workload(s) to 0", len(workloads))
+	pods := 0
+	for _, w := range workloads {
+		s, err := getWorkloadScale(api, ns, w)
+		if err != nil {
+			return err
+		}
+		step.Detail("%s: %d -> 0", w, s.Spec.Replicas)
+
+		selector, err := getWorkloadSelector(api, ns, w)
+		if err != nil {
+			return err
+		}
+		if selector != "" {
+			list, err := api.Client.CoreV1().Pods(ns).List(api.Context(), v1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return fmt.Errorf("error listing the pods of %s. %w", w, err)
+			}
+			pods += len(list.Items)
+		}
+	}
+	plan.Step("wait up to %v for %d pod(s) to terminate", GetWaitTimeout(), pods)
+
+	return PlanHooks(plan, PhasePostQuiesce)
+}
+
+// Adds the steps ScaleDeployUp would run in namespace "ns" after a scale down to the plan
+func PlanScaleUp(api *KubernetesAPI, ns string, plan *Plan) error {
+	log.Println("PlanScaleUp function called.")
+
+	workloads, err := ResolveQuiesceSet(api, ns, GetQuiesceConfig(), true)
+	if err != nil {
+		return err
+	}
+
+	// scaled up in reverse so the operator comes back last
+	step := plan.Step("scale %d workload(s) back to their saved replica counts", len(workloads))
+	for i := len(workloads) - 1; i >= 0; i-- {
+		w := workloads[i]
+		annotations, err := getWorkloadAnnotations(api, ns, w)
+		if err != nil {
+			return err
+		}
+
+		// a saved count from an earlier run wins over the current count
+		if saved, ok := annotations[ReplicasAnnotation]; ok {
+			step.Detail("%s: 0 -> %s", w, saved)
+			continue
+		}
+		s, err := getWorkloadScale(api, ns, w)
+		if err != nil {
+			return err
+		}
+		step.Detail("%s: 0 -> %d", w, max(s.Spec.Replicas, 1))
+	}
+	plan.Step("resume the paused horizontal pod autoscalers")
+	plan.Step("wait up to %v for the workloads to become available", GetWaitTimeout())
+	return nil
+}
+
+// Formats a number of bytes for people, example: 1.5 GiB
+func HumanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlanScaleDownAndUp(t *testing.T) {
+	client := newScaleClientset(
+		newDeployment("app", 3),
+		newDeployment("cnvrg-operator", 1),
+		newHPA("app", KindDeployment, "app"),
+	)
+	api := &KubernetesAPI{Client: client}
+
+	plan := NewPlan("Plan for backup postgres in namespace %s", "cnvrg")
+	err := PlanScaleDown(api, "cnvrg", plan)
+	if err != nil {
+		t.Fatalf("error planning the scale down: %v", err)
+	}
+	err = PlanScaleUp(api, "cnvrg", plan)
+	if err != nil {
+		t.Fatalf("error planning the scale up: %v", err)
+	}
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	for _, expected := range []string{
+		"Plan for backup postgres in namespace cnvrg (dry run, nothing was changed):",
+		" 1. pause the horizontal pod autoscalers",
+		"      - app targeting deployment/app",
+		" 2. save the replica counts and scale 2 workload(s) to 0",
+		"      - deployment/cnvrg-operator: 1 -> 0",
+		"      - deployment/app: 3 -> 0",
+		// the operator comes back last
+		"      - deployment/app: 0 -> 3\n      - deployment/cnvrg-operator: 0 -> 1",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the plan to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	// nothing was changed
+	app, _ := client.AppsV1().Deployments("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if *app.Spec.Replicas != 3 || app.Annotations[ReplicasAnnotation] != "" {
+		t.Fatalf("expected app to be untouched, got %d replicas and %q saved", *app.Spec.Replicas, app.Annotations[ReplicasAnnotation])
+	}
+	hpa, _ := client.AutoscalingV2().HorizontalPodAutoscalers("cnvrg").Get(context.Background(), "app", metav1.GetOptions{})
+	if hpa.Spec.ScaleTargetRef.Name != "app" {
+		t.Fatalf("expected the autoscaler to be untouched, got target %q", hpa.Spec.ScaleTargetRef.Name)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		512:             "512 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	} {
+		if got := HumanBytes(n); got != expected {
+			t.Errorf("expected %q for %d, got %q", expected, n, got)
+		}
+	}
+}
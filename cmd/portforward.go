@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward is an open port forward from a local port to a pod
+type PortForward struct {
+	LocalPort uint16
+	stopCh    chan struct{}
+	once      sync.Once
+	pop       func()
+}
+
+// Forwards a random local port to the port "remotePort" on the pod "p" in namespace "ns".
+// Returns once the forward is ready, call Close to stop forwarding
+func ForwardPodPort(api *KubernetesAPI, ns string, p string, remotePort int) (*PortForward, error) {
+	log.Println("ForwardPodPort function called.")
+
+	var (
+		namespace = ns
+		podName   = p
+		stopCh    = make(chan struct{})
+		readyCh   = make(chan struct{})
+		errCh     = make(chan error, 1)
+	)
+
+	restClient := api.Client.CoreV1().RESTClient()
+	req := restClient.Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("portforward")
+
+	roundTripper, upgrader, err := spdy.RoundTripperFor(api.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating round tripper. %w", err)
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, req.URL())
+
+	// port 0 lets the os pick a free local port
+	ports := []string{fmt.Sprintf("0:%d", remotePort)}
+
+	// Create the port forwarder object, the output goes to the log file
+	pf, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, ports, stopCh, readyCh, log.Writer(), log.Writer())
+	if err != nil {
+		return nil, fmt.Errorf("error creating the forwarder object. %w", err)
+	}
+
+	// Start port forwarding in the background
+	go func() {
+		errCh <- pf.ForwardPorts()
+	}()
+
+	// wait for the forward to be ready or fail
+	select {
+	case err := <-errCh:
+		return nil, fmt.Errorf("error in port forwarding to pod %s. %w", podName, err)
+	case <-readyCh:
+	}
+
+	forwarded, err := pf.GetPorts()
+	if err != nil || len(forwarded) == 0 {
+		close(stopCh)
+		return nil, fmt.Errorf("error getting the forwarded port. %w", err)
+	}
+
+	log.Printf("forwarding 127.0.0.1:%d to pod %s port %d.\n", forwarded[0].Local, podName, remotePort)
+	forward := &PortForward{LocalPort: forwarded[0].Local, stopCh: stopCh}
+
+	// close the forward if the command fails or is interrupted
+	forward.pop = PushCleanup(forward.Close)
+	return forward, nil
+}
+
+// Returns the local address of the forward, example: 127.0.0.1:40213
+func (p *PortForward) Address() string {
+	return fmt.Sprintf("127.0.0.1:%d", p.LocalPort)
+}
+
+// Stops the port forward, safe to call more than once
+func (p *PortForward) Close() {
+	p.once.Do(func() {
+		p.pop()
+		close(p.stopCh)
+	})
+}
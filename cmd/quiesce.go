@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/viper"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// annotation used to save the target of a HorizontalPodAutoscaler while it is paused
+const HPATargetAnnotation = "cnvrgctl/scale-target"
+
+// prefix for the name the scale target of a paused HorizontalPodAutoscaler is pointed at
+const pausedHPAPrefix = "cnvrgctl-paused-"
+
+// kinds of workloads that can be scaled down
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+)
+
+// a deployment or statefulset scaled down during a backup or restore
+type Workload struct {
+	Kind string
+	Name string
+}
+
+// prints the workload the same way kubectl does, e.g. deployment/app
+func (w Workload) String() string {
+	return strings.ToLower(w.Kind) + "/" + w.Name
+}
+
+// the workloads scaled down during a backup or restore, read from the quiesce section of
+// the config file or the --quiesce-* flags
+type QuiesceConfig struct {
+	Operator     string
+	Deployments  []string
+	StatefulSets []string
+	Selector     string
+}
+
+// reads the quiesce set from viper, flags override the config file
+func GetQuiesceConfig() QuiesceConfig {
+	return QuiesceConfig{
+		Operator:     viper.GetString("quiesce.operator"),
+		Deployments:  viper.GetStringSlice("quiesce.deployments"),
+		StatefulSets: viper.GetStringSlice("quiesce.statefulsets"),
+		Selector:     viper.GetString("quiesce.selector"),
+	}
+}
+
+// builds the list of workloads to scale in the namespace "ns". The operator is always first in
+// the list so it can't scale the other workloads back up. Workloads that don't exist are skipped,
+// if saved is true workloads that still have a saved replica count are added as well.
+func ResolveQuiesceSet(api *KubernetesAPI, ns string, c QuiesceConfig, saved bool) ([]Workload, error) {
+	log.Println("ResolveQuiesceSet function called.")
+
+	var (
+		clientset  = api.Client
+		namespace  = ns
+		candidates = []Workload{}
+		workloads  = []Workload{}
+		seen       = map[Workload]bool{}
+	)
+
+	// the operator goes first so it is scaled down first and up last
+	if c.Operator != "" {
+		candidates = append(candidates, Workload{Kind: KindDeployment, Name: c.Operator})
+	}
+	for _, name := range c.Deployments {
+		candidates = append(candidates, Workload{Kind: KindDeployment, Name: name})
+	}
+	for _, name := range c.StatefulSets {
+		candidates = append(candidates, Workload{Kind: KindStatefulSet, Name: name})
+	}
+
+	// discover the deployments and statefulsets matching the label selector
+	if c.Selector != "" {
+		deploys, err := clientset.AppsV1().Deployments(namespace).List(api.Context(), v1.ListOptions{LabelSelector: c.Selector})
+		if err != nil {
+			return nil, fmt.Errorf("error listing the deployments matching the selector %v. %w", c.Selector, err)
+		}
+		for _, d := range deploys.Items {
+			candidates = append(candidates, Workload{Kind: KindDeployment, Name: d.Name})
+		}
+
+		sets, err := clientset.AppsV1().StatefulSets(namespace).List(api.Context(), v1.ListOptions{LabelSelector: c.Selector})
+		if err != nil {
+			return nil, fmt.Errorf("error listing the statefulsets matching the selector %v. %w", c.Selector, err)
+		}
+		for _, s := range sets.Items {
+			candidates = append(candidates, Workload{Kind: KindStatefulSet, Name: s.Name})
+		}
+	}
+
+	// add anything still scaled down by a previous run, even if it's no longer in the config
+	if saved {
+		savedReplicas, err := GetSavedReplicas(api, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range savedReplicas {
+			candidates = append(candidates, s.Workload)
+		}
+	}
+
+	// remove duplicates and skip the optional components that aren't installed
+	for _, w := range candidates {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+
+		_, err := getWorkloadAnnotations(api, namespace, w)
+		if errors.IsNotFound(err) {
+			fmt.Printf("%s not found in namespace %s, skipping.\n", w, namespace)
+			log.Printf("%s not found in namespace %s, skipping.\n", w, namespace)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, w)
+	}
+
+	// nothing to scale usually means the wrong namespace was passed
+	if len(workloads) == 0 {
+		return nil, fmt.Errorf("none of the workloads to scale were found in namespace %v, check the namespace specified is correct", namespace)
+	}
+	return workloads, nil
+}
+
+// gets the annotations of the deployment or statefulset "w"
+func getWorkloadAnnotations(api *KubernetesAPI, ns string, w Workload) (map[string]string, error) {
+	switch w.Kind {
+	case KindDeployment:
+		deploy, err := api.Client.AppsV1().Deployments(ns).Get(api.Context(), w.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+		return deploy.Annotations, nil
+	case KindStatefulSet:
+		set, err := api.Client.AppsV1().StatefulSets(ns).Get(api.Context(), w.Name, v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+		return set.Annotations, nil
+	}
+	return nil, fmt.Errorf("the workload kind %v is not supported", w.Kind)
+}
+
+// gets the scale subresource of the deployment or statefulset "w"
+func getWorkloadScale(api *KubernetesAPI, ns string, w Workload) (*autoscalingv1.Scale, error) {
+	var (
+		scale *autoscalingv1.Scale
+		err   = fmt.Errorf("the workload kind %v is not supported", w.Kind)
+	)
+
+	switch w.Kind {
+	case KindDeployment:
+		scale, err = api.Client.AppsV1().Deployments(ns).GetScale(api.Context(), w.Name, v1.GetOptions{})
+	case KindStatefulSet:
+		scale, err = api.Client.AppsV1().StatefulSets(ns).GetScale(api.Context(), w.Name, v1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error getting the number of replicas for %s. %w", w, err)
+	}
+	return scale, nil
+}
+
+// sets the number of replicas of the deployment or statefulset "w" to "replicas"
+func updateWorkloadScale(api *KubernetesAPI, ns string, w Workload, s *autoscalingv1.Scale, replicas int32) error {
+	var err = fmt.Errorf("the workload kind %v is not supported", w.Kind)
+
+	// create a copy of the scale object with the new number of replicas
+	sc := *s
+	sc.Spec.Replicas = replicas
+
+	switch w.Kind {
+	case KindDeployment:
+		_, err = api.Client.AppsV1().Deployments(ns).UpdateScale(api.Context(), w.Name, &sc, v1.UpdateOptions{})
+	case KindStatefulSet:
+		_, err = api.Client.AppsV1().StatefulSets(ns).UpdateScale(api.Context(), w.Name, &sc, v1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("there was an issue scaling %s. %w", w, err)
+	}
+	return nil
+}
+
+// applies the merge "patch" to the deployment or statefulset "w"
+func patchWorkload(api *KubernetesAPI, ns string, w Workload, patch []byte) error {
+	var err = fmt.Errorf("the workload kind %v is not supported", w.Kind)
+
+	switch w.Kind {
+	case KindDeployment:
+		_, err = api.Client.AppsV1().Deployments(ns).Patch(api.Context(), w.Name, types.MergePatchType, patch, v1.PatchOptions{})
+	case KindStatefulSet:
+		_, err = api.Client.AppsV1().StatefulSets(ns).Patch(api.Context(), w.Name, types.MergePatchType, patch, v1.PatchOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("there was an issue patching %s. %w", w, err)
+	}
+	return nil
+}
+
+// Pauses the HorizontalPodAutoscalers targeting any of the "workloads" so they don't scale them back up.
+// The scale target is pointed at a workload that doesn't exist and the original name is saved in the
+// cnvrgctl/scale-target annotation
+func PauseHPAs(api *KubernetesAPI, ns string, workloads []Workload) error {
+	log.Println("PauseHPAs function called.")
+
+	hpas, err := api.Client.AutoscalingV2().HorizontalPodAutoscalers(ns).List(api.Context(), v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing the horizontal pod autoscalers in namespace %v. %w", ns, err)
+	}
+
+	targets := map[Workload]bool{}
+	for _, w := range workloads {
+		targets[w] = true
+	}
+
+	for _, hpa := range hpas.Items {
+		// already paused by a previous run
+		if _, ok := hpa.Annotations[HPATargetAnnotation]; ok {
+			continue
+		}
+
+		ref := hpa.Spec.ScaleTargetRef
+		if !targets[Workload{Kind: ref.Kind, Name: ref.Name}] {
+			continue
+		}
+
+		err = patchHPATarget(api, ns, hpa.Name, pausedHPAPrefix+ref.Name, &ref.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("paused horizontal pod autoscaler %s targeting %s/%s.\n", hpa.Name, strings.ToLower(ref.Kind), ref.Name)
+	}
+	return nil
+}
+
+// Points the HorizontalPodAutoscalers paused by PauseHPAs back at their original scale target
+func ResumeHPAs(api *KubernetesAPI, ns string) error {
+	log.Println("ResumeHPAs function called.")
+
+	hpas, err := api.Client.AutoscalingV2().HorizontalPodAutoscalers(ns).List(api.Context(), v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing the horizontal pod autoscalers in namespace %v. %w", ns, err)
+	}
+
+	for _, hpa := range hpas.Items {
+		target, ok := hpa.Annotations[HPATargetAnnotation]
+		if !ok {
+			continue
+		}
+
+		err = patchHPATarget(api, ns, hpa.Name, target, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("resumed horizontal pod autoscaler %s targeting %s/%s.\n", hpa.Name, strings.ToLower(hpa.Spec.ScaleTargetRef.Kind), target)
+	}
+	return nil
+}
+
+// Sets the scale target of the HorizontalPodAutoscaler "name" to "target" and the cnvrgctl/scale-target
+// annotation to "saved", the annotation is removed if saved is nil
+func patchHPATarget(api *KubernetesAPI, ns string, name string, target string, saved *string) error {
+	annotations := map[string]interface{}{HPATargetAnnotation: nil}
+	if saved != nil {
+		annotations[HPATargetAnnotation] = *saved
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+		"spec": map[string]interface{}{
+			"scaleTargetRef": map[string]interface{}{
+				"name": target,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating the horizontal pod autoscaler patch. %w", err)
+	}
+
+	_, err = api.Client.AutoscalingV2().HorizontalPodAutoscalers(ns).Patch(api.Context(), name, types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		log.Printf("there was an issue updating the horizontal pod autoscaler %v. %v", name, err)
+		return fmt.Errorf("there was an issue updating the horizontal pod autoscaler %v. %w", name, err)
+	}
+	return nil
+}
+
+// a workload with a replica count saved by ScaleDeployDown
+type SavedReplicas struct {
+	Workload Workload
+	Saved    string
+	Current  int32
+}
+
+// Lists the deployments and statefulsets in namespace "ns" with a replica count saved in the
+// cnvrgctl/replicas annotation and the number of replicas they are running now
+func GetSavedReplicas(api *KubernetesAPI, ns string) ([]SavedReplicas, error) {
+	log.Println("GetSavedReplicas function called.")
+
+	saved := []SavedReplicas{}
+
+	deploys, err := api.Client.AppsV1().Deployments(ns).List(api.Context(), v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the deployments in namespace %v. %w", ns, err)
+	}
+	for _, d := range deploys.Items {
+		if count, ok := d.Annotations[ReplicasAnnotation]; ok {
+			s := SavedReplicas{Workload: Workload{Kind: KindDeployment, Name: d.Name}, Saved: count}
+			if d.Spec.Replicas != nil {
+				s.Current = *d.Spec.Replicas
+			}
+			saved = append(saved, s)
+		}
+	}
+
+	sets, err := api.Client.AppsV1().StatefulSets(ns).List(api.Context(), v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the statefulsets in namespace %v. %w", ns, err)
+	}
+	for _, st := range sets.Items {
+		if count, ok := st.Annotations[ReplicasAnnotation]; ok {
+			s := SavedReplicas{Workload: Workload{Kind: KindStatefulSet, Name: st.Name}, Saved: count}
+			if st.Spec.Replicas != nil {
+				s.Current = *st.Spec.Replicas
+			}
+			saved = append(saved, s)
+		}
+	}
+	return saved, nil
+}
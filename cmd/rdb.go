@@ -0,0 +1,647 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+// rdb opcodes, see rdb.h in the redis source
+const (
+	rdbOpSlotInfo  = 0xF4
+	rdbOpFunction2 = 0xF5
+	rdbOpFunction  = 0xF6
+	rdbOpModuleAux = 0xF7
+	rdbOpIdle      = 0xF8
+	rdbOpFreq      = 0xF9
+	rdbOpAux       = 0xFA
+	rdbOpResizeDB  = 0xFB
+	rdbOpExpireMs  = 0xFC
+	rdbOpExpire    = 0xFD
+	rdbOpSelectDB  = 0xFE
+	rdbOpEOF       = 0xFF
+)
+
+// rdb value types that are supported by the reader
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZsetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+	rdbTypeHashListpackEx   = 25
+)
+
+// RDBEntry is a single key read from an rdb file
+type RDBEntry struct {
+	// the database the key belongs to
+	DB int
+	// name of the key
+	Key string
+	// unix time in milliseconds the key expires, 0 if the key doesn't expire
+	ExpireAt int64
+	// the value serialized in the DUMP format, can be passed to RESTORE
+	Payload []byte
+}
+
+// RDBReader reads the keys from an rdb file, such as the dump.rdb saved by redis
+// or the payload of a SYNC, and converts them to RESTORE payloads
+type RDBReader struct {
+	r       *bufio.Reader
+	raw     *bytes.Buffer
+	Version int
+	db      int
+
+	// crc64 of every byte read so far, compared with the checksum at the end of the file
+	crc uint64
+}
+
+// Reads the rdb header from "r" and returns a reader for the keys
+func NewRDBReader(r io.Reader) (*RDBReader, error) {
+	log.Println("NewRDBReader function called.")
+
+	reader := &RDBReader{r: bufio.NewReaderSize(r, 64*1024)}
+
+	// the header is REDIS followed by a 4 digit version
+	header := make([]byte, 9)
+	_, err := io.ReadFull(reader.r, header)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the rdb header. %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("the file is not an rdb file, the header is %q", header)
+	}
+
+	reader.Version, err = strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the rdb version %q. %w", header[5:], err)
+	}
+	reader.crc = RedisCRC64(0, header)
+	return reader, nil
+}
+
+// Returns the next key in the rdb file, io.EOF is returned after the last key once the
+// checksum of the file is verified
+func (r *RDBReader) Next() (*RDBEntry, error) {
+	var expireAt int64
+
+	for {
+		op, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case rdbOpEOF:
+			err = r.verifyChecksum()
+			if err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+
+		case rdbOpSelectDB:
+			db, _, err := r.readLength()
+			if err != nil {
+				return nil, err
+			}
+			r.db = int(db)
+
+		case rdbOpExpire:
+			seconds, err := r.readUint32()
+			if err != nil {
+				return nil, err
+			}
+			expireAt = int64(seconds) * 1000
+
+		case rdbOpExpireMs:
+			ms, err := r.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			expireAt = int64(ms)
+
+		case rdbOpResizeDB:
+			err = r.skipLengths(2)
+
+		case rdbOpSlotInfo:
+			err = r.skipLengths(3)
+
+		case rdbOpAux:
+			_, err = r.readString()
+			if err == nil {
+				_, err = r.readString()
+			}
+
+		case rdbOpFreq:
+			_, err = r.readByte()
+
+		case rdbOpIdle:
+			_, _, err = r.readLength()
+
+		// functions are not keys, they are skipped
+		case rdbOpFunction2:
+			log.Println("skipping a redis function library in the rdb file.")
+			_, err = r.readString()
+
+		case rdbOpFunction, rdbOpModuleAux:
+			return nil, fmt.Errorf("the rdb file uses redis modules or functions which are not supported (opcode %#x)", op)
+
+		// anything else is the type of a key
+		default:
+			key, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			payload, err := r.readPayload(op)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the value of the key %q. %w", key, err)
+			}
+
+			return &RDBEntry{DB: r.db, Key: string(key), ExpireAt: expireAt, Payload: payload}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Reads the 8 byte checksum after the EOF opcode and compares it with the crc64 of the file.
+// Files before version 5 have no checksum and a checksum of 0 means redis was configured
+// with rdbchecksum no
+func (r *RDBReader) verifyChecksum() error {
+	if r.Version < 5 {
+		return nil
+	}
+	crc := r.crc
+	expected, err := r.readUint64()
+	if err != nil {
+		return fmt.Errorf("error reading the rdb checksum. %w", err)
+	}
+	if expected != 0 && expected != crc {
+		return fmt.Errorf("the rdb file is corrupted, the checksum is %#x but the content is %#x", expected, crc)
+	}
+	return nil
+}
+
+// Reads every key of the rdb file in "r" without keeping them, returns the number of keys or
+// an error for a key that can't be converted or a bad checksum. Run before changing redis so
+// a file that can't be fully loaded is rejected up front
+func ValidateRDB(r io.Reader) (int64, error) {
+	log.Println("ValidateRDB function called.")
+
+	rdb, err := NewRDBReader(r)
+	if err != nil {
+		return 0, err
+	}
+	var keys int64
+	for {
+		_, err := rdb.Next()
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return keys, err
+		}
+		keys++
+	}
+}
+
+// Reads the value of type "t" and returns it in the DUMP format:
+// type, value, 2 byte rdb version and the crc64 of everything before it
+func (r *RDBReader) readPayload(t byte) ([]byte, error) {
+	r.raw = bytes.NewBuffer([]byte{t})
+	defer func() { r.raw = nil }()
+
+	err := r.skipValue(t)
+	if err != nil {
+		return nil, err
+	}
+
+	binary.Write(r.raw, binary.LittleEndian, uint16(r.Version))
+	binary.Write(r.raw, binary.LittleEndian, RedisCRC64(0, r.raw.Bytes()))
+	return r.raw.Bytes(), nil
+}
+
+// Reads past the value of type "t", the bytes read are captured in r.raw
+func (r *RDBReader) skipValue(t byte) error {
+	switch t {
+	// values stored as a single string or blob
+	case rdbTypeString, rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZsetZiplist,
+		rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZsetListpack, rdbTypeSetListpack:
+		_, err := r.readString()
+		return err
+
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		return r.skipStrings(1)
+
+	case rdbTypeHash:
+		return r.skipStrings(2)
+
+	case rdbTypeZset:
+		n, _, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = r.readString(); err != nil {
+				return err
+			}
+			// old style double, 253-255 are nan and +/- inf
+			size, err := r.readByte()
+			if err != nil {
+				return err
+			}
+			if size < 253 {
+				if err = r.skipBytes(int(size)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case rdbTypeZset2:
+		n, _, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = r.readString(); err != nil {
+				return err
+			}
+			if err = r.skipBytes(8); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case rdbTypeListQuicklist2:
+		n, _, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// container type then the listpack
+			if _, _, err = r.readLength(); err != nil {
+				return err
+			}
+			if _, err = r.readString(); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case rdbTypeHashListpackEx:
+		// minimum expire time of the fields then the listpack
+		if err := r.skipBytes(8); err != nil {
+			return err
+		}
+		_, err := r.readString()
+		return err
+
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return r.skipStream(t)
+
+	default:
+		return fmt.Errorf("the rdb value type %d is not supported, use the exec mode instead", t)
+	}
+}
+
+// Reads past a stream value, the layout depends on the stream type version
+func (r *RDBReader) skipStream(t byte) error {
+	// listpacks with the stream entries, each is a node key and a listpack
+	err := r.skipStrings(2)
+	if err != nil {
+		return err
+	}
+
+	// number of elements and the last id
+	err = r.skipLengths(3)
+	if err != nil {
+		return err
+	}
+
+	// first id, max deleted id and entries added
+	if t >= rdbTypeStreamListpacks2 {
+		if err = r.skipLengths(5); err != nil {
+			return err
+		}
+	}
+
+	// consumer groups
+	groups, _, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	for g := uint64(0); g < groups; g++ {
+		// name and last delivered id
+		if _, err = r.readString(); err != nil {
+			return err
+		}
+		if err = r.skipLengths(2); err != nil {
+			return err
+		}
+
+		// entries read
+		if t >= rdbTypeStreamListpacks2 {
+			if err = r.skipLengths(1); err != nil {
+				return err
+			}
+		}
+
+		// pending entries list, 16 byte id, 8 byte delivery time and the delivery count
+		pending, _, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for p := uint64(0); p < pending; p++ {
+			if err = r.skipBytes(24); err != nil {
+				return err
+			}
+			if err = r.skipLengths(1); err != nil {
+				return err
+			}
+		}
+
+		// consumers, name, seen time, active time and the consumer pending ids
+		consumers, _, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for c := uint64(0); c < consumers; c++ {
+			if _, err = r.readString(); err != nil {
+				return err
+			}
+			if err = r.skipBytes(8); err != nil {
+				return err
+			}
+			if t >= rdbTypeStreamListpacks3 {
+				if err = r.skipBytes(8); err != nil {
+					return err
+				}
+			}
+			ids, _, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			if err = r.skipBytes(int(ids) * 16); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reads a length prefixed list of "n" strings per element, example: 2 for a hash field and value
+func (r *RDBReader) skipStrings(perElement int) error {
+	n, _, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n*uint64(perElement); i++ {
+		if _, err = r.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads past "n" lengths
+func (r *RDBReader) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		if _, _, err := r.readLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a length, returns true if the length is a special string encoding instead
+func (r *RDBReader) readLength() (uint64, bool, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := r.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		default:
+			return 0, false, fmt.Errorf("unknown rdb length encoding %#x", b)
+		}
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+// Reads a string, integer and lzf encoded strings are decoded
+func (r *RDBReader) readString() ([]byte, error) {
+	n, encoded, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return r.readBytes(int(n))
+	}
+
+	switch n {
+	case 0:
+		b, err := r.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b[0])))), nil
+	case 1:
+		b, err := r.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))), nil
+	case 2:
+		b, err := r.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b))))), nil
+	case 3:
+		compressedLen, _, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		length, _, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := r.readBytes(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return nil, fmt.Errorf("unknown rdb string encoding %d", n)
+	}
+}
+
+// Reads a 4 byte little endian integer
+func (r *RDBReader) readUint32() (uint32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// Reads an 8 byte little endian integer
+func (r *RDBReader) readUint64() (uint64, error) {
+	b, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *RDBReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *RDBReader) skipBytes(n int) error {
+	_, err := r.readBytes(n)
+	return err
+}
+
+// Reads "n" bytes, the bytes are captured when a value is being read
+func (r *RDBReader) readBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.r, buf)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("error reading the rdb file. %w", err)
+	}
+	if r.raw != nil {
+		r.raw.Write(buf)
+	}
+	r.crc = RedisCRC64(r.crc, buf)
+	return buf, nil
+}
+
+// Decompresses an lzf compressed string of the uncompressed size "length"
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// literal run of ctrl+1 bytes
+		if ctrl < 32 {
+			end := i + ctrl + 1
+			if end > len(in) {
+				return nil, fmt.Errorf("invalid lzf data, literal run past the end")
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// back reference
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("invalid lzf data, missing the reference length")
+			}
+			size += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("invalid lzf data, missing the reference offset")
+		}
+		ref := len(out) - ((ctrl & 0x1F) << 8) - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("invalid lzf data, reference before the start")
+		}
+
+		// copy byte by byte, the reference can overlap the output
+		for j := 0; j < size+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, fmt.Errorf("invalid lzf data, expected %d bytes got %d", length, len(out))
+	}
+	return out, nil
+}
+
+// crc64 jones table used by redis for the DUMP payload checksum
+var redisCRC64Table = func() *[256]uint64 {
+	const poly = 0x95AC9329AC4BC9B5
+
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return &table
+}()
+
+// Calculates the crc64 used by redis. The standard library crc64 can't be used since
+// it inverts the crc before and after, redis doesn't
+func RedisCRC64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = redisCRC64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRedisCRC64(t *testing.T) {
+	// check value from the redis crc64 tests
+	if crc := RedisCRC64(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("expected crc 0xe9c6d914c4b8d9ca, got %#x", crc)
+	}
+}
+
+func TestLzfDecompress(t *testing.T) {
+	// literal "a" followed by a back reference repeating it 9 times
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x00}, 10)
+	if err != nil {
+		t.Fatalf("error decompressing: %v", err)
+	}
+	if string(out) != "aaaaaaaaaa" {
+		t.Fatalf("expected 10 a's, got %q", out)
+	}
+
+	_, err = lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x00}, 5)
+	if err == nil {
+		t.Fatal("expected an error for the wrong length, got nil")
+	}
+}
+
+func TestRDBReader(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.WriteString("REDIS0009")
+
+	// aux field, db selector and resize hint
+	rdb.Write([]byte{rdbOpAux, 9})
+	rdb.WriteString("redis-ver")
+	rdb.Write([]byte{5})
+	rdb.WriteString("6.2.0")
+	rdb.Write([]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 3, 1})
+
+	// integer encoded string key with the value 10
+	rdb.Write([]byte{rdbTypeString, 3})
+	rdb.WriteString("num")
+	rdb.Write([]byte{0xC0, 0x0A})
+
+	// string with an expiry in db 2
+	rdb.Write([]byte{rdbOpSelectDB, 2, rdbOpExpireMs})
+	binary.Write(&rdb, binary.LittleEndian, uint64(1700000000000))
+	rdb.Write([]byte{rdbTypeString, 3})
+	rdb.WriteString("foo")
+	rdb.Write([]byte{3})
+	rdb.WriteString("bar")
+
+	// hash with one field
+	rdb.Write([]byte{rdbTypeHash, 1})
+	rdb.WriteString("h")
+	rdb.Write([]byte{1, 5})
+	rdb.WriteString("field")
+	rdb.Write([]byte{5})
+	rdb.WriteString("value")
+
+	// end of file and checksum
+	rdb.Write([]byte{rdbOpEOF, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	reader, err := NewRDBReader(&rdb)
+	if err != nil {
+		t.Fatalf("error reading the header: %v", err)
+	}
+	if reader.Version != 9 {
+		t.Fatalf("expected version 9, got %d", reader.Version)
+	}
+
+	// the payload matches the DUMP example from the redis docs
+	entry, err := reader.Next()
+	if err != nil {
+		t.Fatalf("error reading the first key: %v", err)
+	}
+	expected := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n")
+	if entry.Key != "num" || entry.DB != 0 || entry.ExpireAt != 0 || !bytes.Equal(entry.Payload, expected) {
+		t.Fatalf("unexpected entry %+v, expected payload %q", entry, expected)
+	}
+
+	entry, err = reader.Next()
+	if err != nil {
+		t.Fatalf("error reading the second key: %v", err)
+	}
+	if entry.Key != "foo" || entry.DB != 2 || entry.ExpireAt != 1700000000000 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	// the expiry only applies to the key after it
+	entry, err = reader.Next()
+	if err != nil {
+		t.Fatalf("error reading the third key: %v", err)
+	}
+	if entry.Key != "h" || entry.DB != 2 || entry.ExpireAt != 0 || entry.Payload[0] != rdbTypeHash {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	if _, err = reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRDBReaderErrors(t *testing.T) {
+	_, err := NewRDBReader(strings.NewReader("NOTREDIS0"))
+	if err == nil {
+		t.Fatal("expected an error for a bad header, got nil")
+	}
+
+	// module values can't be converted
+	reader, err := NewRDBReader(strings.NewReader("REDIS0009\x07\x01k"))
+	if err != nil {
+		t.Fatalf("error reading the header: %v", err)
+	}
+	_, err = reader.Next()
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected an unsupported type error, got %v", err)
+	}
+}
+
+func TestValidateRDB(t *testing.T) {
+	// a string key followed by the end of file and the checksum of everything before it
+	var rdb bytes.Buffer
+	rdb.WriteString("REDIS0009")
+	rdb.Write([]byte{rdbOpSelectDB, 0, rdbTypeString, 3})
+	rdb.WriteString("foo")
+	rdb.Write([]byte{3})
+	rdb.WriteString("bar")
+	rdb.WriteByte(rdbOpEOF)
+	valid := append([]byte{}, rdb.Bytes()...)
+	valid = binary.LittleEndian.AppendUint64(valid, RedisCRC64(0, valid))
+
+	keys, err := ValidateRDB(bytes.NewReader(valid))
+	if err != nil || keys != 1 {
+		t.Fatalf("expected 1 valid key, got %d %v", keys, err)
+	}
+
+	// a byte changed in the value
+	corrupted := append([]byte{}, valid...)
+	corrupted[18] = 'z'
+	if _, err := ValidateRDB(bytes.NewReader(corrupted)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+
+	// a file cut before the end
+	if _, err := ValidateRDB(bytes.NewReader(valid[:len(valid)-12])); err == nil {
+		t.Fatal("expected an error for a truncated file")
+	}
+
+	// a checksum of 0 is written when rdbchecksum is off
+	unchecked := append(rdb.Bytes(), 0, 0, 0, 0, 0, 0, 0, 0)
+	if _, err := ValidateRDB(bytes.NewReader(unchecked)); err != nil {
+		t.Fatalf("expected no checksum verification, got %v", err)
+	}
+
+	// module aux data after a valid key is rejected before anything is restored
+	withModule := append([]byte{}, valid[:len(valid)-9]...)
+	withModule = append(withModule, rdbOpModuleAux, 0)
+	if _, err := ValidateRDB(bytes.NewReader(withModule)); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected an unsupported module error, got %v", err)
+	}
+
+	// the stream types after 21 are not known
+	unknown := []byte("REDIS0012\x17\x01k")
+	if _, err := ValidateRDB(bytes.NewReader(unknown)); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected an unsupported type error, got %v", err)
+	}
+}
+
+func TestRedisConnSync(t *testing.T) {
+	testCases := []struct {
+		name  string
+		reply string
+	}{
+		{
+			name:  "disk_sync",
+			reply: "\n\n$14\r\nREDIS0009\xff1234",
+		},
+		{
+			name:  "diskless_sync",
+			reply: "$EOF:" + strings.Repeat("x", 40) + "\r\nREDIS0009\xff1234" + strings.Repeat("x", 40) + "*1\r\n$4\r\nPING\r\n",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			// fake redis server, rejects rdb-only and replies to SYNC with the payload
+			go func() {
+				defer server.Close()
+				srv := NewRedisConn(server)
+				if _, err := srv.Receive(); err != nil {
+					return
+				}
+				server.Write([]byte("-ERR Unrecognized REPLCONF option: rdb-only\r\n"))
+				if _, err := srv.Receive(); err != nil {
+					return
+				}
+				server.Write([]byte(test.reply))
+			}()
+
+			var out bytes.Buffer
+			n, err := NewRedisConn(client).Sync(&out)
+			if err != nil {
+				t.Fatalf("error syncing: %v", err)
+			}
+			if out.String() != "REDIS0009\xff1234" || n != 14 {
+				t.Fatalf("unexpected payload %q (%d bytes)", out.String(), n)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// error replies redis-cli prints to stdout when running with --raw
+var redisErrorPrefixes = []string{"ERR", "NOAUTH", "WRONGPASS", "NOPERM", "LOADING", "MISCONF"}
+
+// Executes a command in the redis pod "p" in namespace "ns". The redis password is passed
+// to the container using the REDISCLI_AUTH env variable so it never ends up in the command line.
+// stdin can be nil, stdout is returned as a string
+// used by the backup and restore redis commands
+func RedisExec(api *KubernetesAPI, ns string, p string, password string, stdin io.Reader, command ...string) (string, error) {
+	log.Println("RedisExec function called.")
+
+	var stdout bytes.Buffer
+	err := RedisStream(api, ns, p, password, stdin, &stdout, command...)
+	return stdout.String(), err
+}
+
+// Same as RedisExec but streams stdout to the writer "w" instead of buffering it,
+// used to copy the rdb file out of the pod
+func RedisStream(api *KubernetesAPI, ns string, p string, password string, stdin io.Reader, w io.Writer, command ...string) error {
+	var (
+		podName   = p
+		namespace = ns
+	)
+
+	// prefix the command with env so the password is set for redis-cli
+	command = append([]string{"env", "REDISCLI_AUTH=" + password}, command...)
+
+	// stream the output of the command to the writer, stderr is part of the error
+	err := PodExec(api.Context(), api, namespace, podName, "", stdin, w, nil, command...)
+	if err != nil {
+		log.Printf("there was an error running the command in the redis pod. %v\n", err)
+		return fmt.Errorf("there was an error running the command in the redis pod. %w", err)
+	}
+
+	return nil
+}
+
+// Runs redis-cli with the arguments passed in the redis pod and returns the raw output
+func RedisCLI(api *KubernetesAPI, ns string, p string, password string, args ...string) (string, error) {
+	log.Printf("RedisCLI function called with %v.\n", args)
+
+	// use --raw so the output is not quoted
+	command := append([]string{"redis-cli", "--raw"}, args...)
+
+	out, err := RedisExec(api, ns, p, password, nil, command...)
+	if err != nil {
+		return "", err
+	}
+
+	// redis-cli exits 0 on most command errors, check the reply for one
+	out = strings.TrimSpace(out)
+	for _, prefix := range redisErrorPrefixes {
+		if strings.HasPrefix(out, prefix) {
+			log.Printf("redis returned an error for the command %v. %s\n", args, out)
+			return "", fmt.Errorf("redis returned an error for the command %v. %s", args, out)
+		}
+	}
+	return out, nil
+}
+
+// Gets the value of the redis configuration parameter "param"
+// returns an empty string if the parameter doesn't exist in this version of redis
+func RedisConfigGet(api *KubernetesAPI, ns string, p string, password string, param string) (string, error) {
+	log.Println("RedisConfigGet function called.")
+
+	out, err := RedisCLI(api, ns, p, password, "CONFIG", "GET", param)
+	if err != nil {
+		return "", fmt.Errorf("error getting the redis config %s. %w", param, err)
+	}
+
+	// the reply is the name of the parameter followed by the value
+	lines := strings.Split(out, "\n")
+	if len(lines) < 2 {
+		return "", nil
+	}
+	return strings.TrimSpace(lines[1]), nil
+}
+
+// Returns the fields of the INFO section "section" as a map, example: rdb_bgsave_in_progress -> 0
+func RedisInfo(api *KubernetesAPI, ns string, p string, password string, section string) (map[string]string, error) {
+	log.Println("RedisInfo function called.")
+
+	out, err := RedisCLI(api, ns, p, password, "INFO", section)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the redis info %s. %w", section, err)
+	}
+	return ParseRedisInfo(out), nil
+}
+
+// Parses the key:value lines returned by INFO, comment lines starting with # are skipped
+func ParseRedisInfo(info string) map[string]string {
+	fields := map[string]string{}
+
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if found {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// Returns the total number of keys across all redis databases using INFO keyspace
+func RedisKeyCount(api *KubernetesAPI, ns string, p string, password string) (int64, error) {
+	log.Println("RedisKeyCount function called.")
+
+	keys, _, err := RedisKeyCounts(api, ns, p, password)
+	return keys, err
+}
+
+// Returns the total number of keys and the number of keys with a TTL across all redis databases
+// using INFO keyspace. The keys with a TTL can expire before a restore loads them
+func RedisKeyCounts(api *KubernetesAPI, ns string, p string, password string) (int64, int64, error) {
+	log.Println("RedisKeyCounts function called.")
+
+	out, err := RedisCLI(api, ns, p, password, "INFO", "keyspace")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error getting the redis keyspace. %w", err)
+	}
+	keys, err := ParseRedisKeyspace(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	expires, err := ParseRedisExpires(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	return keys, expires, nil
+}
+
+// Parses the output of INFO keyspace and sums the keys of every database
+// example line: db0:keys=1234,expires=0,avg_ttl=0
+func ParseRedisKeyspace(info string) (int64, error) {
+	return sumRedisKeyspace(info, "keys")
+}
+
+// Parses the output of INFO keyspace and sums the keys with a TTL of every database
+func ParseRedisExpires(info string) (int64, error) {
+	return sumRedisKeyspace(info, "expires")
+}
+
+// Sums the field "name" of every database in the output of INFO keyspace
+func sumRedisKeyspace(info string, name string) (int64, error) {
+	var total int64
+
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+
+		_, fields, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		// find the <name>=<n> field
+		for _, field := range strings.Split(fields, ",") {
+			value, ok := strings.CutPrefix(field, name+"=")
+			if !ok {
+				continue
+			}
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing the number of %s from %q. %w", name, line, err)
+			}
+			total += count
+		}
+	}
+	return total, nil
+}
+
+// Returns the name of the file used to record the number of redis keys at backup time, followed by
+// the number of keys with a TTL. The restore redis command compares the restored keys against these values
+func RedisKeyCountFile(fileName string) string {
+	return fileName + ".keys"
+}
@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisConn is a minimal redis protocol (RESP) client used to talk to redis over a port forward
+// when the redis container doesn't have redis-cli or a shell
+type RedisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// RedisError is an error reply returned by redis, example: ERR unknown command
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// Connects to redis at "addr" and authenticates if a password "password" is set
+func DialRedis(addr string, password string, timeout time.Duration) (*RedisConn, error) {
+	log.Println("DialRedis function called.")
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		log.Printf("error connecting to redis at %s. %v", addr, err)
+		return nil, fmt.Errorf("error connecting to redis at %s. %w", addr, err)
+	}
+	c := NewRedisConn(conn)
+
+	// authenticate with the password from the redis secret
+	if password != "" {
+		_, err = c.Do("AUTH", password)
+		if err != nil {
+			c.Close()
+			log.Printf("error authenticating to redis. %v", err)
+			return nil, fmt.Errorf("error authenticating to redis. %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Wraps an existing connection, used by DialRedis and the tests
+func NewRedisConn(conn net.Conn) *RedisConn {
+	return &RedisConn{
+		conn: conn,
+		r:    bufio.NewReaderSize(conn, 64*1024),
+		w:    bufio.NewWriterSize(conn, 64*1024),
+	}
+}
+
+// Closes the connection to redis
+func (c *RedisConn) Close() error {
+	return c.conn.Close()
+}
+
+// Sends a command and waits for the reply. Replies are returned as string (simple strings),
+// int64, []byte (bulk strings, nil if missing) or []interface{} (arrays). Error replies are returned as RedisError
+func (c *RedisConn) Do(args ...string) (interface{}, error) {
+	err := c.Send(args...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Buffers a command without waiting for the reply, used to pipeline commands. Call Flush
+// to send the buffered commands and Receive once for each command to read the replies
+func (c *RedisConn) Send(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(arg))
+		c.w.WriteString(arg)
+		_, err := c.w.WriteString("\r\n")
+		if err != nil {
+			return fmt.Errorf("error writing the command to redis. %w", err)
+		}
+	}
+	return nil
+}
+
+// Sends any buffered commands
+func (c *RedisConn) Flush() error {
+	err := c.w.Flush()
+	if err != nil {
+		return fmt.Errorf("error sending the commands to redis. %w", err)
+	}
+	return nil
+}
+
+// Reads the next reply from redis
+func (c *RedisConn) Receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply from redis")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the integer reply %q. %w", line, err)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the bulk reply %q. %w", line, err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the bulk reply. %w", err)
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the array reply %q. %w", line, err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = c.Receive()
+			if err != nil {
+				var redisErr RedisError
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				items[i] = redisErr
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply from redis %q", line)
+	}
+}
+
+// Reads a line without the trailing \r\n
+func (c *RedisConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("error reading the reply from redis. %w", err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Requests a full copy of the dataset with SYNC, the same way a replica does, and writes the
+// rdb payload to "w". The connection can't be used for other commands afterwards. Returns the bytes written
+func (c *RedisConn) Sync(w io.Writer) (int64, error) {
+	log.Println("Sync function called.")
+
+	// redis 7 can send only the rdb and skip the replication stream, older versions return an error
+	_, err := c.Do("REPLCONF", "rdb-only", "1")
+	if err != nil {
+		log.Printf("redis doesn't support rdb-only syncs. %v", err)
+	}
+
+	err = c.Send("SYNC")
+	if err == nil {
+		err = c.Flush()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// redis sends new lines as a keep alive while it saves the rdb
+	var header string
+	for header == "" {
+		header, err = c.readLine()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(header, "-"):
+		return 0, RedisError(header[1:])
+
+	// diskless sync, the payload ends with a 40 byte delimiter instead of having a length
+	case strings.HasPrefix(header, "$EOF:"):
+		mark := []byte(header[len("$EOF:"):])
+		return copyUntilMark(w, c.r, mark)
+
+	case strings.HasPrefix(header, "$"):
+		n, err := strconv.ParseInt(header[1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing the sync reply %q. %w", header, err)
+		}
+		written, err := io.CopyN(w, c.r, n)
+		if err != nil {
+			return written, fmt.Errorf("error reading the rdb payload. %w", err)
+		}
+		return written, nil
+
+	default:
+		return 0, fmt.Errorf("unknown sync reply from redis %q", header)
+	}
+}
+
+// Copies from "r" to "w" until the delimiter "mark" is read, the delimiter is not written
+func copyUntilMark(w io.Writer, r *bufio.Reader, mark []byte) (int64, error) {
+	var (
+		written int64
+		window  = make([]byte, 0, 64*1024+len(mark))
+		buf     = make([]byte, 64*1024)
+	)
+
+	for {
+		n, err := r.Read(buf)
+		window = append(window, buf[:n]...)
+
+		// the payload is complete once the mark is found, anything after it is the replication stream
+		if i := bytes.Index(window, mark); i >= 0 {
+			out, werr := w.Write(window[:i])
+			return written + int64(out), werr
+		}
+
+		// keep the last bytes in case the mark is split between reads
+		if len(window) > len(mark) {
+			flush := len(window) - len(mark)
+			out, werr := w.Write(window[:flush])
+			written += int64(out)
+			if werr != nil {
+				return written, werr
+			}
+			window = append(window[:0], window[flush:]...)
+		}
+
+		if err != nil {
+			return written, fmt.Errorf("error reading the rdb payload. %w", err)
+		}
+	}
+}
+
+// Returns the total number of keys across all redis databases using INFO keyspace
+func (c *RedisConn) KeyCount() (int64, error) {
+	keys, _, err := c.KeyCounts()
+	return keys, err
+}
+
+// Returns the total number of keys and the number of keys with a TTL across all redis databases
+func (c *RedisConn) KeyCounts() (int64, int64, error) {
+	reply, err := c.Do("INFO", "keyspace")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error getting the redis keyspace. %w", err)
+	}
+
+	info, ok := reply.([]byte)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected reply to INFO keyspace %v", reply)
+	}
+	keys, err := ParseRedisKeyspace(string(info))
+	if err != nil {
+		return 0, 0, err
+	}
+	expires, err := ParseRedisExpires(string(info))
+	if err != nil {
+		return 0, 0, err
+	}
+	return keys, expires, nil
+}
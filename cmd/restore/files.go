@@ -70,9 +70,35 @@ to quickly create a Cobra application.`,
 		// connect to kubernetes and define clientset and rest client
 		api, err := root.ConnectToK8s()
 		if err != nil {
-			fmt.Printf("error connecting to the cluster, check your connectivity. %v", err)
-			log.Printf("error connecting to the cluster, check your connectivity. %v", err)
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
 		}
+
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planFilesRestore(api, nsFlag, s3SecretName, &o, skFlag != "" || akFlag != "", sourceFlag)
+			if err != nil {
+				root.Fatalf("error planning the restore. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
+		// run the user defined hooks before the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePreRestore)
+		if err != nil {
+			root.Fatalf("error running the pre-restore hooks. %v", err)
+		}
+
 		// get the object data and store in the ObjectStorage struct
 		if skFlag == "" && akFlag == "" {
 			objectData, err := root.GetObjectSecret(api, s3SecretName, nsFlag)
@@ -80,7 +106,7 @@ to quickly create a Cobra application.`,
 				fmt.Printf("failed to get the S3 secret. %v ", err)
 				log.Printf("failed to get the S3 secret. %v", err)
 			}
-			success, err = uploadFilesMinio(objectData, sourceFlag)
+			success, err = uploadFilesMinio(api.Context(), objectData, sourceFlag)
 			if err != nil {
 				log.Printf("failed to upload files. %v", err)
 				fmt.Printf("failed to upload files. %v ", err)
@@ -89,12 +115,18 @@ to quickly create a Cobra application.`,
 
 		// upload files from minio using info from the flags
 		if !success {
-			_, err = uploadFilesMinio(&o, sourceFlag)
+			_, err = uploadFilesMinio(api.Context(), &o, sourceFlag)
 			if err != nil {
 				log.Printf("failed to upload files. %v", err)
 				fmt.Printf("failed to upload files. %v ", err)
 			}
 		}
+
+		// run the user defined hooks after the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePostRestore)
+		if err != nil {
+			root.Fatalf("error running the post-restore hooks. %v", err)
+		}
 	},
 }
 
@@ -125,7 +157,8 @@ func init() {
 
 // TODO: check if useSSL = false, conslidate with get bucket function
 // TODO: add in getting cp-object-secret
-func uploadFilesMinio(o *root.ObjectStorage, s string) (bool, error) {
+// Uploads the files in the folder "s" to the bucket, stops when "ctx" is cancelled
+func uploadFilesMinio(ctx context.Context, o *root.ObjectStorage, s string) (bool, error) {
 	log.Println("uploadFiles Minio function called.")
 	// Walk all the subdirectories of a directory
 	dir := s
@@ -158,7 +191,7 @@ func uploadFilesMinio(o *root.ObjectStorage, s string) (bool, error) {
 			log.Println("The path trimmed is: ", pathTrimmed)
 
 			// Upload all files
-			ui, err := minioClient.FPutObject(context.Background(), o.BucketName, pathTrimmed, path, minio.PutObjectOptions{})
+			ui, err := minioClient.FPutObject(ctx, o.BucketName, pathTrimmed, path, minio.PutObjectOptions{})
 			if err != nil {
 				log.Printf("failed to upload files to minio bucket. %v\n", err)
 				return fmt.Errorf("failed to upload files to minio bucket. %w", err)
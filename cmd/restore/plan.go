@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package restore
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+)
+
+// Resolves the target bucket and counts the local files for a dry run of restore files.
+// The bucket from the flags "o" is used when the keys are passed, otherwise the storage secret
+func planFilesRestore(api *root.KubernetesAPI, ns string, secretName string, o *root.ObjectStorage, useFlags bool, source string) (*root.Plan, error) {
+	log.Println("planFilesRestore function called.")
+
+	plan := root.NewPlan("Plan for restore files in namespace %s", ns)
+
+	target := o
+	if !useFlags {
+		objectData, err := root.GetObjectSecret(api, secretName, ns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the S3 secret. %w", err)
+		}
+		target = objectData
+	}
+
+	count, size, err := countLocalFiles(source)
+	if err != nil {
+		return nil, err
+	}
+
+	err = root.PlanHooks(plan, root.PhasePreRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Step("upload the files in %s to the bucket %s at %s", source, target.BucketName, target.Endpoint).
+		Detail("%d file(s), %s", count, root.HumanBytes(size)).
+		Detail("objects with the same key are overwritten")
+
+	err = root.PlanHooks(plan, root.PhasePostRestore)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Resolves the postgres pod, the local dump and the workloads to scale for a dry run of restore postgres
+func planPostgresRestore(api *root.KubernetesAPI, ns string, target string, label string) (*root.Plan, error) {
+	log.Println("planPostgresRestore function called.")
+
+	plan := root.NewPlan("Plan for restore postgres in namespace %s", ns)
+	backupFile := "./cnvrg-db-backup.sql"
+
+	info, err := os.Stat(backupFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the backup file %s. %w", backupFile, err)
+	}
+
+	podName, err := root.GetDeployPod(api, target, ns, label)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the postgres pod. %w", err)
+	}
+
+	err = root.PlanScaleDown(api, ns, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	err = root.PlanHooks(plan, root.PhasePreRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Step("copy %s (%s) to /opt/app-root/src/cnvrg-db-backup.sql in pod %s", backupFile, root.HumanBytes(info.Size()), podName)
+	plan.Step("forward port 5432 of pod %s and recreate the database", podName).
+		Detail("block new connections and terminate the sessions to cnvrg_production").
+		Detail("DROP DATABASE cnvrg_production").
+		Detail("CREATE DATABASE cnvrg_production")
+	plan.Step("run pg_restore of cnvrg-db-backup.sql into cnvrg_production in pod %s with 8 jobs", podName)
+
+	err = root.PlanHooks(plan, root.PhasePostRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	err = root.PlanScaleUp(api, ns, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Resolves the redis pod and data files, the keys that would be replaced and the workloads to scale
+// for a dry run of restore redis
+func planRedisRestore(api *root.KubernetesAPI, ns string, target string, label string, secretName string, disableScale bool, mode string, port int, backupFile string) (*root.Plan, error) {
+	log.Println("planRedisRestore function called.")
+
+	plan := root.NewPlan("Plan for restore redis in namespace %s", ns)
+
+	info, err := os.Stat(backupFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the backup file %s. %w", backupFile, err)
+	}
+
+	// the password is checked but never printed
+	password, err := root.GetRedisPassword(api, secretName, ns)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing the redis password. %w", err)
+	}
+
+	podName, err := root.GetDeployPod(api, target, ns, label)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the redis pod. %w", err)
+	}
+
+	if !disableScale {
+		err = root.PlanScaleDown(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = root.PlanHooks(plan, root.PhasePreRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	// the number of keys recorded by backup redis, if it exists
+	expected := "no key count was recorded with the backup"
+	if b, err := os.ReadFile(root.RedisKeyCountFile(backupFile)); err == nil {
+		expected = fmt.Sprintf("the backup recorded %s key(s)", string(b))
+	}
+
+	switch mode {
+	case "sync":
+		plan.Step("forward port %d of pod %s", port, podName)
+		plan.Step("FLUSHALL every redis database").
+			Detail("all the keys in redis are deleted")
+		plan.Step("load the keys from %s (%s) with RESTORE", backupFile, root.HumanBytes(info.Size())).
+			Detail(expected)
+
+	default:
+		keys, err := root.RedisKeyCount(api, ns, podName, password)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := root.RedisConfigGet(api, ns, podName, password, "dir")
+		if err != nil {
+			return nil, err
+		}
+		dbFilename, err := root.RedisConfigGet(api, ns, podName, password, "dbfilename")
+		if err != nil {
+			return nil, err
+		}
+		appendOnly, err := root.RedisConfigGet(api, ns, podName, password, "appendonly")
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Step("disable redis snapshots with CONFIG SET save \"\"")
+		plan.Step("copy %s (%s) to %s in pod %s", backupFile, root.HumanBytes(info.Size()), path.Join(dir, dbFilename), podName)
+		if appendOnly == "yes" {
+			plan.Step("replace the append only file in %s with the backup", dir)
+		}
+		plan.Step("restart redis with SHUTDOWN NOSAVE").
+			Detail("the %d key(s) in redis now are replaced", keys)
+		plan.Step("wait for redis to load the backup and check the key count").
+			Detail(expected)
+	}
+
+	err = root.PlanHooks(plan, root.PhasePostRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	if !disableScale {
+		err = root.PlanScaleUp(api, ns, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// counts the files and their total size under the local folder "dir"
+func countLocalFiles(dir string) (int, int64, error) {
+	var (
+		count int
+		size  int64
+	)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			count++
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading the files in %s. %w", dir, err)
+	}
+	return count, size, nil
+}
@@ -4,24 +4,14 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package restore
 
 import (
-	"bytes"
-	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"sync"
-	"time"
 
 	root "github.com/dilerous/cnvrgctl/cmd"
 	"github.com/spf13/cobra"
-	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/tools/remotecommand"
-	"k8s.io/client-go/transport/spdy"
 )
 
 // postgresCmd represents the postgres command
@@ -53,42 +43,71 @@ Examples:
 		// connect to the kubernetes api and set clientset and rest client
 		api, err := root.ConnectToK8s()
 		if err != nil {
-			fmt.Printf("error connecting to the cluster, check your connectivity. %v", err)
-			log.Printf("error connecting to the cluster, check your connectivity. %v", err)
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
 		}
 
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planPostgresRestore(api, nsFlag, targetFlag, labelFlag)
+			if err != nil {
+				root.Fatalf("error planning the restore. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
 		// get the postgres pod name
 		podName, err := root.GetDeployPod(api, targetFlag, nsFlag, labelFlag)
 		if err != nil {
-			fmt.Printf("Error getting pod name: %v", err)
-			log.Printf("Error getting pod name: %v", err)
+			root.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
 		}
 
+		// the database is dropped below, the app must not be running
 		err = root.ScaleDeployDown(api, nsFlag)
 		if err != nil {
-			fmt.Printf("there was a problem with scaling down the pods. %v ", err)
-			log.Printf("there was a problem with scaling down the pods. %v", err)
+			root.Fatalf("error scaling down the pods, the database was not changed. %v", err)
+		}
+
+		// run the user defined hooks before the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePreRestore)
+		if err != nil {
+			root.Fatalf("error running the pre-restore hooks. %v", err)
 		}
 
 		// copy the local sql backup to the postgres pod
-		copyDBRemotely(api, nsFlag, podName)
+		err = copyDBRemotely(api, nsFlag, podName)
 		if err != nil {
-			fmt.Printf("there was a problem copying the local backup to the pod. %v ", err)
-			log.Printf("there was a problem copying the local backup to the pod. %v", err)
+			root.Fatalf("error copying the local backup to the pod, the database was not changed. %v", err)
 		}
 
-		// forward the postgres service and execute the sql commands
+		// forward the postgres service and drop and create the database, the app is kept scaled
+		// down from the drop on if anything fails
 		err = portForwardSvc(api, nsFlag, podName)
 		if err != nil {
-			fmt.Printf("error forwarding the service. %v ", err)
-			log.Printf("error forwarding the service. %v", err)
+			root.Fatalf("error resetting the postgres database. %v", err)
 		}
 
 		// restore the postgres backup from the dump file
 		err = restorePostgresBackup(api, nsFlag, podName)
 		if err != nil {
-			fmt.Printf("error restoring the backup, check the logs. %v ", err)
-			log.Printf("error restoring the backup, check the logs. %v", err)
+			root.KeepScaledDown()
+			root.Fatalf("error restoring the backup, the app will not be scaled up. %v", err)
+		}
+
+		// run the user defined hooks after the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePostRestore)
+		if err != nil {
+			root.Fatalf("error running the post-restore hooks. %v", err)
 		}
 
 		err = root.ScaleDeployUp(api, nsFlag)
@@ -109,7 +128,7 @@ func init() {
 	postgresCmd.Flags().StringP("selector", "l", "app", "Define the deployment label for the postgres deployment. example: app.kubernetes.io/name")
 }
 
-func dropPgDB(a root.KubernetesAPI, n string, name string) error {
+func dropPgDB(a root.KubernetesAPI, n string, name string, port uint16) error {
 	log.Println("dropPgDB function called.")
 
 	var (
@@ -120,7 +139,7 @@ func dropPgDB(a root.KubernetesAPI, n string, name string) error {
 
 	// TODO: reference the struct for targetFlag and labelFlag
 	// Connect to the PostgreSQL database
-	db, err := connectToPostgreSQL(&api, namespace, podName)
+	db, err := connectToPostgreSQL(&api, namespace, podName, port)
 	if err != nil {
 		log.Printf("error connecting to postgresql. %v", err)
 		return fmt.Errorf("error connecting to postgresql. %w", err)
@@ -135,23 +154,33 @@ func dropPgDB(a root.KubernetesAPI, n string, name string) error {
 		"DROP DATABASE cnvrg_production;",
 		"CREATE DATABASE cnvrg_production;",
 	}
+	// sql.Open doesn't connect, check the connection before anything is changed
+	err = db.PingContext(api.Context())
+	if err != nil {
+		log.Printf("error connecting to postgresql. %v", err)
+		return fmt.Errorf("error connecting to postgresql, the database was not changed. %w", err)
+	}
+
+	// the first command already stops the app from connecting, keep it scaled down if the
+	// database is left dropped or half restored
+	root.KeepScaledDown()
 	for _, cmd := range sqlCommands {
-		if _, err := db.Exec(cmd); err != nil {
-			fmt.Fprintf(os.Stderr, "error executing SQL command: %v", err)
-			log.Fatalf("error executing SQL command: %v", err)
+		if _, err := db.ExecContext(api.Context(), cmd); err != nil {
+			log.Printf("error executing the SQL command %q. %v", cmd, err)
+			return fmt.Errorf("error executing the SQL command %q, the app will not be scaled up. %w", cmd, err)
 		}
 	}
 	fmt.Println("SQL commands executed successfully.")
 	return nil
 }
 
-// Connect to the PostgreSQL database
-func connectToPostgreSQL(clientset *root.KubernetesAPI, namespace, podName string) (*sql.DB, error) {
+// Connect to the PostgreSQL database through the port forwarded to the local "port"
+func connectToPostgreSQL(clientset *root.KubernetesAPI, namespace, podName string, port uint16) (*sql.DB, error) {
 	log.Println("connectToPostgreSQL function called.")
 
 	api := clientset
 	// Get PostgreSQL pod IP address
-	pod, err := api.Client.CoreV1().Pods(namespace).Get(context.Background(), podName, v1.GetOptions{})
+	pod, err := api.Client.CoreV1().Pods(namespace).Get(api.Context(), podName, v1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pod: %v", err)
 	}
@@ -162,7 +191,7 @@ func connectToPostgreSQL(clientset *root.KubernetesAPI, namespace, podName strin
 	ip := "localhost"
 
 	// Connect to PostgreSQL database
-	db, err := sql.Open("postgres", fmt.Sprintf("host=%s dbname=postgres user=cnvrg sslmode=disable", ip))
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d dbname=postgres user=cnvrg sslmode=disable", ip, port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
@@ -174,90 +203,36 @@ func portForwardSvc(api *root.KubernetesAPI, n string, p string) error {
 	log.Println("portForwardSvc function called")
 
 	var (
-		namespace = n
-		podName   = p
-		localPort = 5432
+		namespace  = n
+		podName    = p
+		remotePort = 5432
 	)
 	fmt.Println("the pod name is " + podName)
 
-	// Port forward the service
-	stopCh := make(chan struct{})
-	readyChan := make(chan struct{})
-	errChan := make(chan error, 1)
-	go func() {
-
-		// convert the int of port to a string to pass later
-		portAsString := fmt.Sprintf("%d", localPort)
-
-		restClient := api.Client.CoreV1().RESTClient()
-		req := restClient.Post().
-			Resource("pods").
-			Name(podName).
-			Namespace(namespace).
-			SubResource("portforward")
-
-		url := req.URL()
-
-		roundTripper, upgrader, err := spdy.RoundTripperFor(api.Config)
-		if err != nil {
-			errChan <- fmt.Errorf("error creating round tripper: %v", err)
-			return
-		}
-
-		var (
-			dialer = spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, url)
-			port   = []string{portAsString}
-		)
-
-		// Create the port forwarder object
-		pf, err := portforward.New(dialer, port, stopCh, readyChan, os.Stdout, os.Stderr)
-		if err != nil {
-			errChan <- fmt.Errorf("error creating the forwarder object: %v", err)
-			return
-		}
-
-		// Start port forwarding in the background
-		fmt.Println("Starting the port forwarding...")
-		err = pf.ForwardPorts()
-		if err != nil {
-			errChan <- fmt.Errorf("error in port forwarding: %v", err)
-			return
-		}
-	}()
-	time.Sleep(5 * time.Second)
+	// Port forward the pod, returns once the forward is ready
+	fmt.Println("Starting the port forwarding...")
+	pf, err := root.ForwardPodPort(api, namespace, podName, remotePort)
+	if err != nil {
+		log.Printf("error port forwarding: %v", err)
+		return fmt.Errorf("error port forwarding. %w", err)
+	}
+	defer pf.Close()
 
 	// execute the sql commands against the postgres DB
-	err := dropPgDB(*api, namespace, podName)
+	err = dropPgDB(*api, namespace, podName, pf.LocalPort)
 	if err != nil {
-		fmt.Printf("error restoring the postgres database. %v ", err)
-		log.Printf("error restoring the postgres database. %v", err)
+		log.Printf("error resetting the postgres database. %v", err)
+		return fmt.Errorf("error resetting the postgres database. %w", err)
 	}
 	fmt.Println("Changes made closing the connection...")
-
-	// close the channel
-	close(stopCh)
-
-	select {
-	case err := <-errChan:
-		if err != nil {
-			log.Printf("error port forwarding: %v", err)
-			return fmt.Errorf("error port forwarding. %w", err)
-		}
-		fmt.Println("Port forwarding completed successfully.")
-		log.Println("Port forwarding completed successfully.")
-	case <-readyChan:
-		fmt.Println("forwarding successfully closed.")
-		log.Println("forwarding successfully closed.")
-	}
 	return nil
 }
 
 func restorePostgresBackup(api *root.KubernetesAPI, n string, p string) error {
 	log.Println("restorePostgresBackup function called.")
 
-	// set variables for the clientset and pod name
+	// set variables for the pod name and namespace
 	var (
-		clientset = api.Client
 		podName   = p
 		namespace = n
 	)
@@ -269,42 +244,11 @@ func restorePostgresBackup(api *root.KubernetesAPI, n string, p string) error {
 		"export PGPASSWORD=$POSTGRESQL_PASSWORD; pg_restore -h postgres -p 5432 -U cnvrg -d cnvrg_production -j 8 --verbose cnvrg-db-backup.sql",
 	}
 
-	// rest request to send command to pod
-	req := clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
-			Stdin:   false,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     false,
-		}, scheme.ParameterCodec)
-
-	// Execute the command in the pod
-	executor, err := remotecommand.NewSPDYExecutor(api.Config, "POST", req.URL())
-	if err != nil {
-		log.Printf("here was an error executing the commands in the pod. %v\n", err)
-		return fmt.Errorf("here was an error executing the commands in the pod. %w", err)
-	}
-
-	// Prepare the streams for stdout and stderr
-	stdout := os.Stdout
-	stderr := os.Stderr
-
-	// stream the output of the command to stdout and stderr
-	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-		Stdin:  nil,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    false,
-	})
+	// stream the output of the command to stdout and stderr, a failed pg_restore returns its exit status
+	err := root.PodExec(api.Context(), api, namespace, podName, "", nil, os.Stdout, os.Stderr, command...)
 	if err != nil {
-		log.Printf("there was an error streaming the output of the command to stdout, stderr. %v\n", err)
-		return fmt.Errorf("there was an error streaming the output of the command to stdout, stderr. %w", err)
+		log.Printf("there was an error running pg_restore in the pod. %v\n", err)
+		return fmt.Errorf("there was an error running pg_restore in the pod. %w", err)
 	}
 
 	//TODO add in a check if the file exits here cnvrg-db-backup.sql
@@ -315,7 +259,7 @@ func restorePostgresBackup(api *root.KubernetesAPI, n string, p string) error {
 
 // TODO: add flags to define the backup file name and path
 func copyDBRemotely(api *root.KubernetesAPI, ns string, pod string) error {
-	log.Println("copyDBLocally function called.")
+	log.Println("copyDBRemotely function called.")
 
 	//TODO: add flag to specify location of file
 	var ( // Set the pod and namespace
@@ -323,9 +267,7 @@ func copyDBRemotely(api *root.KubernetesAPI, ns string, pod string) error {
 		namespace  = ns
 		filePath   = "./"
 		backupFile = "cnvrg-db-backup.sql"
-		clientset  = api.Client
 		command    = []string{"cp", "/dev/stdin", "/opt/app-root/src/cnvrg-db-backup.sql"}
-		config     = api.Config
 	)
 
 	// open the file that was just created
@@ -336,49 +278,11 @@ func copyDBRemotely(api *root.KubernetesAPI, ns string, pod string) error {
 	}
 	defer file.Close()
 
-	// Create a REST client
-	log.Println("Creating the rest client call")
-	req := clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Command: command,
-			Stdin:   true,
-			Stdout:  true,
-			Stderr:  true,
-			TTY:     false,
-		}, scheme.ParameterCodec)
-
-	// execute the command
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	// stream the file to the pod
+	err = root.PodExec(api.Context(), api, namespace, podName, "", file, nil, nil, command...)
 	if err != nil {
-		log.Printf("error executing the remote command. %v\n", err)
-		return fmt.Errorf("error execuuting the remote command. %w", err)
+		log.Printf("error copying %s to the pod. %v\n", backupFile, err)
+		return fmt.Errorf("error copying %s to the pod. %w", backupFile, err)
 	}
-
-	// set the variables to type byte and stream the output to those variables
-	var stdout, stderr bytes.Buffer
-
-	// Create a waitgroup to synchronize the completion of streaming
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	// execute the command
-	go func() {
-		exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-			Stdin:  file,
-			Stdout: &stdout,
-			Stderr: &stderr,
-		})
-		wg.Done() // Signal that streaming is complete
-	}()
-
-	// Wait for streaming to finish
-	wg.Wait()
-
-	//no errors return nil
 	return nil
 }
@@ -0,0 +1,44 @@
+package restore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+)
+
+func TestCopyDBRemotely(t *testing.T) {
+	// the backup is read from the working directory
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	err := os.WriteFile("cnvrg-db-backup.sql", []byte("PGDMP"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec := &root.FakeExecutor{}
+	api := &root.KubernetesAPI{Exec: exec}
+
+	err = copyDBRemotely(api, "cnvrg", "postgres-0")
+	if err != nil {
+		t.Fatalf("error copying the backup: %v", err)
+	}
+	calls := exec.Calls()
+	if len(calls) != 1 || string(calls[0].Stdin) != "PGDMP" || calls[0].Pod != "postgres-0" {
+		t.Fatalf("expected the backup to be streamed to postgres-0, got %+v", calls)
+	}
+
+	// a failed copy in the pod is returned
+	exec.Handler = func(call root.FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+		return 1
+	}
+	err = copyDBRemotely(api, "cnvrg", "postgres-0")
+	var exitErr *root.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+}
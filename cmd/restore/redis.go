@@ -0,0 +1,618 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// redisCmd represents the redis command
+var redisCmd = &cobra.Command{
+	Use:   "redis",
+	Short: "Restore the Redis database backup.",
+	Long: `This command will scale down the application and supporting pods and
+restore the Redis database from a local RDB file. The writes to Redis are paused
+(Redis 6.2 or later) and the RDB file is streamed into the Redis data directory,
+Redis is restarted to load it and the number of keys
+is compared against the number recorded by 'cnvrgctl backup redis'. Keys with a
+TTL at backup time may expire before they are loaded and are allowed to be missing.
+
+Hardened redis images without redis-cli or a shell can be restored with '--mode sync'.
+The redis port is forwarded, the database is flushed and every key in the RDB file
+is loaded with RESTORE, nothing is executed in the container and redis isn't restarted.
+The whole RDB file and its checksum are read before anything is scaled down or flushed,
+a file with module data, functions or an unknown type is rejected without changing redis.
+
+Examples:
+
+# Restores the ./dump.rdb backup to the redis pod in the cnvrg namespace.
+  cnvrgctl restore redis -n cnvrg
+
+# Specify the backup file, deployment label key and deployment name.
+  cnvrgctl restore redis --file-location ./backups --file-name dump.rdb --target redis --selector app -n cnvrg
+
+# Restore redis over a port-forward without executing anything in the pod.
+  cnvrgctl restore redis --mode sync -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("restore redis command called")
+
+		// target deployment of the redis restore
+		targetFlag, _ := cmd.Flags().GetString("target")
+
+		// grab the namespace from the -n flag if not specified default is used
+		nsFlag, _ := cmd.Flags().GetString("namespace")
+
+		// Define the key of the deployment label for the redis deployment
+		labelFlag, _ := cmd.Flags().GetString("selector")
+
+		// name of the secret with the redis password
+		redisSecretName, _ := cmd.Flags().GetString("secret-name")
+
+		// local location and name of the backup file
+		fileLocationFlag, _ := cmd.Flags().GetString("file-location")
+		fileNameFlag, _ := cmd.Flags().GetString("file-name")
+
+		// flag to disable scaling the pods before the restore
+		disableScaleFlag, _ := cmd.Flags().GetBool("disable-scale")
+
+		// time to wait for redis to restart
+		timeoutFlag, _ := cmd.Flags().GetDuration("timeout")
+
+		// restore using exec or RESTORE commands over a port-forward
+		modeFlag, _ := cmd.Flags().GetString("mode")
+		portFlag, _ := cmd.Flags().GetInt("port")
+		if modeFlag != "exec" && modeFlag != "sync" {
+			root.Fatalf("unknown mode %s, the mode must be exec or sync.", modeFlag)
+		}
+
+		backupFile := filepath.Join(fileLocationFlag, fileNameFlag)
+
+		// make sure the backup exists before scaling anything down
+		if _, err := os.Stat(backupFile); err != nil {
+			root.Fatalf("error reading the backup file %s. %v", backupFile, err)
+		}
+
+		// the sync mode flushes redis, the whole file is read first so nothing is changed
+		// when a key can't be restored
+		if modeFlag == "sync" {
+			if err := validateRedisBackup(backupFile); err != nil {
+				root.Fatalf("error validating the backup, nothing was changed. %v", err)
+			}
+		}
+
+		// connect to kubernetes and define clientset and rest client
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		// print what would be done without changing anything
+		if dryRunFlag, _ := cmd.Flags().GetBool("dry-run"); dryRunFlag {
+			plan, err := planRedisRestore(api, nsFlag, targetFlag, labelFlag, redisSecretName, disableScaleFlag, modeFlag, portFlag, backupFile)
+			if err != nil {
+				root.Fatalf("error planning the restore. %v", err)
+			}
+			plan.Print(os.Stdout)
+			return
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
+		// capture the redis password
+		password, err := root.GetRedisPassword(api, redisSecretName, nsFlag)
+		if err != nil {
+			root.Fatalf("error capturing the redis password, check the namespace and secret exists. %v", err)
+		}
+
+		// get the name of the running redis pod
+		podName, err := root.GetDeployPod(api, targetFlag, nsFlag, labelFlag)
+		if err != nil {
+			root.Fatalf("error getting the pod name check the deployment label, namespace and target. %v", err)
+		}
+
+		// scale down sidekiq and the app so nothing writes to redis during the restore
+		if !disableScaleFlag {
+			err = root.ScaleDeployDown(api, nsFlag)
+			if err != nil {
+				root.Fatalf("error scaling the deployment. %v", err)
+			}
+		}
+
+		// run the user defined hooks before the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePreRestore)
+		if err != nil {
+			root.Fatalf("error running the pre-restore hooks. %v", err)
+		}
+
+		switch modeFlag {
+		case "sync":
+			// load the keys with RESTORE over a port-forward
+			err = syncRedisRestore(api, nsFlag, podName, password, portFlag, backupFile)
+			if err != nil {
+				root.KeepScaledDown()
+				root.Fatalf("error restoring the redis backup, the app will not be scaled up. %v", err)
+			}
+
+		default:
+			// copy the rdb file into the redis data volume and restart redis
+			err = restoreRedisBackup(api, nsFlag, podName, password, backupFile)
+			if err != nil {
+				root.Fatalf("error restoring the redis backup, check the logs. %v", err)
+			}
+
+			// wait for redis to load the rdb file
+			err = waitForRedis(api, nsFlag, podName, password, timeoutFlag)
+			if err != nil {
+				root.KeepScaledDown()
+				root.Fatalf("error waiting for redis to restart, the app will not be scaled up. %v", err)
+			}
+
+			// compare the number of keys against the backup
+			keys, err := root.RedisKeyCount(api, nsFlag, podName, password)
+			if err == nil {
+				err = verifyRedisKeyCount(keys, 0, backupFile)
+			}
+			if err != nil {
+				root.KeepScaledDown()
+				root.Fatalf("error verifying the redis restore, the app will not be scaled up. %v", err)
+			}
+		}
+
+		// run the user defined hooks after the restore
+		err = root.RunHooks(api, nsFlag, root.PhasePostRestore)
+		if err != nil {
+			root.Fatalf("error running the post-restore hooks. %v", err)
+		}
+
+		// scale the app back up
+		if !disableScaleFlag {
+			err = root.ScaleDeployUp(api, nsFlag)
+			if err != nil {
+				fmt.Printf("there was a problem with scaling up the pods. %v ", err)
+				log.Printf("there was a problem with scaling up the pods. %v", err)
+			}
+		}
+	},
+}
+
+func init() {
+	restoreCmd.AddCommand(redisCmd)
+
+	// flag to define the deployment name
+	redisCmd.Flags().StringP("target", "t", "redis", "Name of redis deployment to restore.")
+
+	// flag to define the app label key
+	redisCmd.Flags().StringP("selector", "l", "app", "Define the deployment label for the redis deployment. example: app.kubernetes.io/name")
+
+	// flag to define the secret with the redis password
+	redisCmd.Flags().StringP("secret-name", "", "redis-creds", "Define the secret name for the Redis credentials.")
+
+	// flag to define the backup location
+	redisCmd.Flags().StringP("file-location", "f", ".", "Local location of the redis backup file.")
+
+	// flag to define backup file name
+	redisCmd.Flags().StringP("file-name", "", "dump.rdb", "Name of the redis backup file.")
+
+	// flag to disable scaling the pods before the restore
+	redisCmd.Flags().BoolP("disable-scale", "", false, "Disable scaling the app, cnvrg-operator and 'kiq' pods to 0 before the restore.")
+
+	// flag to define how long to wait for redis to restart
+	redisCmd.Flags().DurationP("timeout", "", 5*time.Minute, "Time to wait for redis to restart and load the backup.")
+
+	// flag to select how the restore is done
+	redisCmd.Flags().StringP("mode", "", "exec", "How to restore redis. exec copies the RDB into the pod and restarts redis, sync loads the keys with RESTORE over a port-forward.")
+
+	// flag to define the redis port used in sync mode
+	redisCmd.Flags().IntP("port", "", 6379, "The redis port in the pod, used with --mode sync.")
+}
+
+// Streams the local rdb file "f" into the redis data directory and restarts redis so it loads the file.
+// If AOF is enabled the rdb is also written as the AOF base so redis doesn't start from the old AOF.
+// how long the writes to redis are paused during the restore, redis lifts the pause
+// when it restarts so this only bounds a restore that is stopped without the cleanup
+const redisWritePause = time.Hour
+
+func restoreRedisBackup(api *root.KubernetesAPI, ns string, p string, password string, f string) error {
+	log.Println("restoreRedisBackup function called.")
+
+	// find where redis stores the rdb file
+	dir, err := root.RedisConfigGet(api, ns, p, password, "dir")
+	if err != nil {
+		return err
+	}
+	dbFilename, err := root.RedisConfigGet(api, ns, p, password, "dbfilename")
+	if err != nil {
+		return err
+	}
+	appendOnly, err := root.RedisConfigGet(api, ns, p, password, "appendonly")
+	if err != nil {
+		return err
+	}
+	save, err := root.RedisConfigGet(api, ns, p, password, "save")
+	if err != nil {
+		return err
+	}
+
+	// the cleanups reach redis after an interrupt
+	cleanupAPI := api.WithContext(context.Background())
+
+	// block the writes to redis while the file is replaced, the pause ends when redis restarts
+	// or with CLIENT UNPAUSE if the restore fails. Redis older than 6.2 can only pause every
+	// client, the writes are then only stopped by the scale down
+	popUnpause := func() {}
+	_, err = root.RedisCLI(api, ns, p, password, "CLIENT", "PAUSE", strconv.FormatInt(redisWritePause.Milliseconds(), 10), "WRITE")
+	if err != nil {
+		fmt.Printf("warning: redis can't pause the writes during the restore, it needs redis 6.2 or later. %v\n", err)
+		log.Printf("redis can't pause the writes during the restore. %v\n", err)
+	} else {
+		popUnpause = root.PushCleanup(func() {
+			_, err := root.RedisCLI(cleanupAPI, ns, p, password, "CLIENT", "UNPAUSE")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error resuming the writes to redis, they resume after %v. %v\n", redisWritePause, err)
+			}
+		})
+	}
+
+	// stop redis from saving snapshots over the file while it is copied, the save
+	// points are set back if the restore fails before redis restarts
+	_, err = root.RedisCLI(api, ns, p, password, "CONFIG", "SET", "save", "")
+	if err != nil {
+		return fmt.Errorf("error disabling redis snapshots. %w", err)
+	}
+	popSave := root.PushCleanup(func() {
+		_, err := root.RedisCLI(cleanupAPI, ns, p, password, "CONFIG", "SET", "save", save)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error restoring the redis save points %q, set them with CONFIG SET save. %v\n", save, err)
+		}
+	})
+
+	rdbPath := path.Join(dir, dbFilename)
+	err = copyRDBRemotely(api, ns, p, password, f, rdbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("copied %s to %s in pod %s.\n", f, rdbPath, p)
+	log.Printf("copied %s to %s in pod %s.\n", f, rdbPath, p)
+
+	// redis loads the AOF instead of the rdb on startup when it is enabled
+	if appendOnly == "yes" {
+		err = replaceRedisAOF(api, ns, p, password, f, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	// shutdown without saving, kubernetes restarts the container and redis loads the copied file.
+	// the connection is closed by the shutdown so the error is expected
+	fmt.Println("restarting redis to load the backup...")
+	_, err = root.RedisCLI(api, ns, p, password, "SHUTDOWN", "NOSAVE")
+	if err != nil {
+		log.Printf("redis shutdown returned, this is expected. %v", err)
+	}
+
+	// redis restarts with its own save points and without the pause
+	popSave()
+	popUnpause()
+	return nil
+}
+
+// Replaces the append only file with the rdb backup. Redis 7 keeps a manifest in the
+// appenddirname folder, older versions use a single appendfilename file. Both accept
+// an rdb formatted file as the AOF base.
+func replaceRedisAOF(api *root.KubernetesAPI, ns string, p string, password string, f string, dir string) error {
+	log.Println("replaceRedisAOF function called.")
+
+	appendFilename, err := root.RedisConfigGet(api, ns, p, password, "appendfilename")
+	if err != nil {
+		return err
+	}
+
+	// appenddirname only exists in redis 7 and above
+	appendDirname, err := root.RedisConfigGet(api, ns, p, password, "appenddirname")
+	if err != nil {
+		log.Printf("appenddirname is not supported, using a single AOF file. %v", err)
+		appendDirname = ""
+	}
+
+	if appendDirname == "" {
+		aofPath := path.Join(dir, appendFilename)
+		err = copyRDBRemotely(api, ns, p, password, f, aofPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("AOF is enabled, replaced %s with the backup.\n", aofPath)
+		log.Printf("AOF is enabled, replaced %s with the backup.\n", aofPath)
+		return nil
+	}
+
+	// move the current AOF folder aside and create a new one with the backup as the base
+	aofDir := path.Join(dir, appendDirname)
+	baseName := appendFilename + ".1.base.rdb"
+	script := `set -e
+mv "$1" "$1.bak-$(date +%s)"
+mkdir -p "$1"
+printf 'file %s seq 1 type b\n' "$2" > "$1/$3.manifest"`
+	_, err = root.RedisExec(api, ns, p, password, nil, "sh", "-c", script, "sh", aofDir, baseName, appendFilename)
+	if err != nil {
+		return fmt.Errorf("error replacing the AOF directory %s. %w", aofDir, err)
+	}
+
+	err = copyRDBRemotely(api, ns, p, password, f, path.Join(aofDir, baseName))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("AOF is enabled, replaced %s with the backup.\n", aofDir)
+	log.Printf("AOF is enabled, replaced %s with the backup.\n", aofDir)
+	return nil
+}
+
+// Streams the local file "f" to the path "dst" in the redis pod. The file is written
+// to a temporary name first and then moved so redis never sees a partial file.
+func copyRDBRemotely(api *root.KubernetesAPI, ns string, p string, password string, f string, dst string) error {
+	log.Println("copyRDBRemotely function called.")
+
+	file, err := os.Open(f)
+	if err != nil {
+		log.Printf("opening the file failed. %s\n", err)
+		return fmt.Errorf("opening the file failed. %w", err)
+	}
+	defer file.Close()
+
+	// remove the partial file from the pod if the copy fails or is interrupted
+	tmp := dst + ".tmp"
+	removeTmp := root.PushCleanup(func() {
+		_, err := root.RedisExec(api.WithContext(context.Background()), ns, p, password, nil, "rm", "-f", tmp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error removing %s from pod %s. %v\n", tmp, p, err)
+			log.Printf("error removing %s from pod %s. %v\n", tmp, p, err)
+		}
+	})
+
+	script := `cat > "$1.tmp" && mv "$1.tmp" "$1"`
+	_, err = root.RedisExec(api, ns, p, password, file, "sh", "-c", script, "sh", dst)
+	if err != nil {
+		log.Printf("error copying %s to %s in the pod. %v", f, dst, err)
+		return fmt.Errorf("error copying %s to %s in the pod. %w", f, dst, err)
+	}
+
+	// the file was moved into place, there is nothing to remove
+	removeTmp()
+	return nil
+}
+
+// Waits for redis to come back after the restart and finish loading the dataset
+func waitForRedis(api *root.KubernetesAPI, ns string, p string, password string, timeout time.Duration) error {
+	log.Println("waitForRedis function called.")
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+
+		// fails while the container restarts or redis is still loading the rdb
+		out, err := root.RedisCLI(api, ns, p, password, "PING")
+		if err == nil && out == "PONG" {
+			fmt.Println("redis is running.")
+			log.Println("redis is running.")
+			return nil
+		}
+		log.Printf("redis is not ready yet. %v", err)
+	}
+	return fmt.Errorf("redis did not become ready within %v", timeout)
+}
+
+// Compares the number of keys "keys" in redis against the number recorded at backup time
+// for the backup file "f". "expired" is the number of keys that expired since the backup and were not restored.
+// The keys with a TTL recorded at backup time can also expire before redis loads them, so the restore is
+// accepted when only those keys are missing
+func verifyRedisKeyCount(keys int64, expired int64, f string) error {
+	log.Println("verifyRedisKeyCount function called.")
+
+	// older backups don't have the key count recorded
+	data, err := os.ReadFile(root.RedisKeyCountFile(f))
+	if err != nil {
+		fmt.Printf("no key count recorded for the backup, skipping verification. redis has %d keys.\n", keys)
+		log.Printf("no key count recorded for the backup, skipping verification. %v", err)
+		return nil
+	}
+
+	// the file holds the number of keys followed by the number of keys with a TTL,
+	// older backups only have the number of keys and must match exactly
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("error reading the key count from %s, unexpected content %q", root.RedisKeyCountFile(f), string(data))
+	}
+	expected, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("error reading the key count from %s. %w", root.RedisKeyCountFile(f), err)
+	}
+	volatile := expired
+	if len(fields) == 2 {
+		volatile, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("error reading the number of keys with a TTL from %s. %w", root.RedisKeyCountFile(f), err)
+		}
+		volatile = max(volatile, expired)
+	}
+
+	if keys > expected-expired || keys < expected-volatile {
+		log.Printf("redis has %d keys, the backup recorded %d keys, %d with a TTL, and %d expired.", keys, expected, volatile, expired)
+		return fmt.Errorf("redis has %d keys, the backup recorded %d keys, %d with a TTL, and %d expired", keys, expected, volatile, expired)
+	}
+
+	if missing := expected - expired - keys; missing > 0 {
+		fmt.Printf("%d keys with a TTL expired before redis loaded them.\n", missing)
+		log.Printf("%d keys with a TTL expired before redis loaded them.\n", missing)
+	}
+	fmt.Printf("Redis DB Restore successful! %d keys restored.\n", keys)
+	log.Printf("Redis DB Restore successful! %d keys restored.\n", keys)
+	return nil
+}
+
+// Reads every key of the rdb file "f" and its checksum without changing redis. The sync mode
+// flushes redis before loading the keys, a key that can't be restored or a corrupted file
+// must be found before that so redis is never left empty or partly restored
+func validateRedisBackup(f string) error {
+	log.Println("validateRedisBackup function called.")
+
+	file, err := os.Open(f)
+	if err != nil {
+		log.Printf("opening the file failed. %s\n", err)
+		return fmt.Errorf("opening the file failed. %w", err)
+	}
+	defer file.Close()
+
+	fmt.Printf("validating %s...\n", f)
+	keys, err := root.ValidateRDB(file)
+	if err != nil {
+		return fmt.Errorf("the backup %s can't be restored in sync mode. %w", f, err)
+	}
+	fmt.Printf("%s is valid, %d keys to restore.\n", f, keys)
+	log.Printf("%s is valid, %d keys to restore.\n", f, keys)
+	return nil
+}
+
+// Restores redis without executing anything in the pod. The redis port "port" is forwarded,
+// the databases are flushed and every key in the rdb file "f" is loaded with RESTORE. The file
+// must be checked with validateRedisBackup first
+func syncRedisRestore(api *root.KubernetesAPI, ns string, p string, password string, port int, f string) error {
+	log.Println("syncRedisRestore function called.")
+
+	file, err := os.Open(f)
+	if err != nil {
+		log.Printf("opening the file failed. %s\n", err)
+		return fmt.Errorf("opening the file failed. %w", err)
+	}
+	defer file.Close()
+
+	rdb, err := root.NewRDBReader(file)
+	if err != nil {
+		return err
+	}
+
+	// forward a local port to the redis pod
+	pf, err := root.ForwardPodPort(api, ns, p, port)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+
+	conn, err := root.DialRedis(pf.Address(), password, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// remove the current keys so redis matches the backup
+	_, err = conn.Do("FLUSHALL")
+	if err != nil {
+		return fmt.Errorf("error flushing redis. %w", err)
+	}
+
+	fmt.Printf("restoring the keys from %s to redis pod %s...\n", f, p)
+	restored, expired, err := restoreRDBKeys(conn, rdb, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d keys, skipped %d expired keys.\n", restored, expired)
+	log.Printf("restored %d keys, skipped %d expired keys.\n", restored, expired)
+
+	// compare the number of keys against the backup
+	keys, err := conn.KeyCount()
+	if err != nil {
+		return err
+	}
+	if keys != restored {
+		return fmt.Errorf("redis has %d keys, %d keys were restored", keys, restored)
+	}
+	return verifyRedisKeyCount(keys, expired, f)
+}
+
+// Sends a RESTORE for every key read from "rdb", keys that expired before "now" are skipped.
+// The commands are pipelined in batches. Returns the number of keys restored and expired
+func restoreRDBKeys(conn *root.RedisConn, rdb *root.RDBReader, now time.Time) (int64, int64, error) {
+	const batchSize = 1000
+
+	var (
+		restored int64
+		expired  int64
+		pending  int
+		db       = 0
+	)
+
+	// reads the replies for the commands sent in the batch
+	receive := func() error {
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := conn.Receive(); err != nil {
+				return fmt.Errorf("error restoring a key. %w", err)
+			}
+		}
+		return nil
+	}
+
+	for {
+		entry, err := rdb.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, expired, err
+		}
+
+		if entry.ExpireAt > 0 && entry.ExpireAt <= now.UnixMilli() {
+			expired++
+			continue
+		}
+
+		// switch database when the key belongs to a different one
+		if entry.DB != db {
+			if err = conn.Send("SELECT", strconv.Itoa(entry.DB)); err != nil {
+				return restored, expired, err
+			}
+			pending++
+			db = entry.DB
+		}
+
+		// keys with an expiry are restored with the absolute expire time
+		args := []string{"RESTORE", entry.Key, "0", string(entry.Payload), "REPLACE"}
+		if entry.ExpireAt > 0 {
+			args = []string{"RESTORE", entry.Key, strconv.FormatInt(entry.ExpireAt, 10), string(entry.Payload), "REPLACE", "ABSTTL"}
+		}
+		if err = conn.Send(args...); err != nil {
+			return restored, expired, err
+		}
+		pending++
+		restored++
+
+		if pending >= batchSize {
+			if err = receive(); err != nil {
+				return restored, expired, err
+			}
+		}
+	}
+
+	return restored, expired, receive()
+}
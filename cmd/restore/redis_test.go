@@ -0,0 +1,106 @@
+package restore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+)
+
+func TestVerifyRedisKeyCount(t *testing.T) {
+	testCases := []struct {
+		name    string
+		counts  string
+		keys    int64
+		expired int64
+		ok      bool
+	}{
+		{"exact", "100 10\n", 100, 0, true},
+		{"ttl_keys_expired_before_load", "100 10\n", 95, 0, true},
+		{"every_ttl_key_expired", "100 10\n", 90, 0, true},
+		{"keys_without_ttl_missing", "100 10\n", 89, 0, false},
+		{"more_keys_than_the_backup", "100 10\n", 101, 0, false},
+		{"expired_in_sync_mode", "100 10\n", 96, 4, true},
+		{"expired_key_restored", "100 10\n", 100, 4, false},
+		{"old_file_exact", "100\n", 100, 0, true},
+		{"old_file_missing_keys", "100\n", 99, 0, false},
+		{"old_file_expired", "100\n", 96, 4, true},
+		{"not_a_number", "many\n", 100, 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "redis-backup.rdb")
+			if err := os.WriteFile(root.RedisKeyCountFile(f), []byte(tc.counts), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := verifyRedisKeyCount(tc.keys, tc.expired, f)
+			if tc.ok && err != nil {
+				t.Fatalf("expected the restore to be verified: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatal("expected a key count error")
+			}
+		})
+	}
+
+	// a backup without a key count isn't verified
+	if err := verifyRedisKeyCount(5, 0, filepath.Join(t.TempDir(), "redis-backup.rdb")); err != nil {
+		t.Fatalf("expected no verification without a key count: %v", err)
+	}
+}
+
+func TestRestoreRedisBackupCleanup(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(f, []byte("REDIS0011"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// answers the CONFIG GET calls and fails the copy into the pod
+	replies := map[string]string{"dir": "/data", "dbfilename": "dump.rdb", "appendonly": "no", "save": "3600 1 300 100"}
+	exec := &root.FakeExecutor{Handler: func(call root.FakeExecCall, stdout io.Writer, stderr io.Writer) int {
+		args := strings.Join(call.Command, " ")
+		if strings.Contains(args, "CONFIG GET") {
+			key := call.Command[len(call.Command)-1]
+			io.WriteString(stdout, key+"\n"+replies[key]+"\n")
+		}
+		if strings.Contains(args, "sh -c") {
+			return 1
+		}
+		return 0
+	}}
+	api := &root.KubernetesAPI{Exec: exec}
+
+	if err := restoreRedisBackup(api, "cnvrg", "redis-0", "secret", f); err == nil {
+		t.Fatal("expected the failed copy to be returned")
+	}
+	root.RunCleanups()
+
+	var commands []string
+	for _, call := range exec.Calls() {
+		commands = append(commands, strings.Join(call.Command, " "))
+	}
+	expected := []string{
+		"CLIENT PAUSE 3600000 WRITE",
+		"CONFIG SET save ",
+		"rm -f /data/dump.rdb.tmp",
+		"CONFIG SET save 3600 1 300 100",
+		"CLIENT UNPAUSE",
+	}
+	i := 0
+	for _, c := range commands {
+		if i < len(expected) && strings.HasSuffix(c, expected[i]) {
+			i++
+		}
+	}
+	if i != len(expected) {
+		t.Fatalf("expected the commands %q in order, got %q", expected, commands)
+	}
+	for _, c := range commands {
+		if strings.HasSuffix(c, "SHUTDOWN NOSAVE") {
+			t.Fatal("expected redis not to be restarted after a failed copy")
+		}
+	}
+}
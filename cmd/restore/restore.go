@@ -19,11 +19,17 @@ specify these values using flags. You
 Examples:
 	
 # Restore the backups to the bucket 'cnvrg-backups'.
-  cnvrgctl migrate restore -a minio -k minio123 -u minio.aws.dilerous.cloud -b cnvrg-backups`,
+  cnvrgctl migrate restore -a minio -k minio123 -u minio.aws.dilerous.cloud -b cnvrg-backups
+
+# Show what a postgres restore would scale, drop and copy without changing anything.
+  cnvrgctl restore postgres -n cnvrg --dry-run`,
 	Run: func(cmd *cobra.Command, args []string) {
 	},
 }
 
 func init() {
 	root.RootCmd.AddCommand(restoreCmd)
+
+	// flag to print the plan of the restore without changing anything
+	restoreCmd.PersistentFlags().BoolP("dry-run", "", false, "Print the steps of the restore without scaling, executing, dropping or copying anything.")
 }
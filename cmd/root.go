@@ -5,17 +5,18 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/user"
+	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	restapi "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // TODO: roll cfgFile into the KubernetesAPI struct
@@ -53,6 +54,9 @@ Examples:
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// cancel the running command and clean up on Ctrl-C
+	handleSignals()
+
 	err := RootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -69,17 +73,43 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cnvrgctl.yaml)")
 
 	// Persistent flag to define the namespace
-	RootCmd.PersistentFlags().StringP("namespace", "n", "default", "If present, the namespace scope for this CLI request")
+	RootCmd.PersistentFlags().StringVarP(kubeConfigFlags.Namespace, "namespace", "n", "default", "If present, the namespace scope for this CLI request")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	// Persistent flag for setting the kubeconfig
-	RootCmd.PersistentFlags().StringP("kubeconfig", "", "", "Path to the kubeconfig file to use for CLI requests")
+	RootCmd.PersistentFlags().StringVar(kubeConfigFlags.KubeConfig, "kubeconfig", "", "Path to the kubeconfig file to use for CLI requests")
 
 	// Persistent flag for setting the context
-	RootCmd.PersistentFlags().StringP("context", "", "", "The name of the kubeconfig context to use")
+	RootCmd.PersistentFlags().StringVar(kubeConfigFlags.Context, "context", "", "The name of the kubeconfig context to use")
+
+	// Persistent flags to impersonate a user or groups, like kubectl --as and --as-group
+	RootCmd.PersistentFlags().StringVar(kubeConfigFlags.Impersonate, "as", "", "Username to impersonate for the operation")
+	RootCmd.PersistentFlags().StringArrayVar(kubeConfigFlags.ImpersonateGroup, "as-group", []string{}, "Group to impersonate for the operation, this flag can be repeated to specify multiple groups")
+
+	// Persistent flag to limit how long a single request to the api server can take
+	RootCmd.PersistentFlags().StringVar(kubeConfigFlags.Timeout, "request-timeout", "0", "The length of time to wait before giving up on a single server request, e.g. 30s. A value of zero means don't timeout requests")
+
+	// Persistent flags for the workloads scaled down during a backup or restore, can also be set in the
+	// quiesce section of the config file
+	RootCmd.PersistentFlags().String("quiesce-operator", "cnvrg-operator", "The operator deployment, scaled down first and up last")
+	RootCmd.PersistentFlags().StringSlice("quiesce-deployments", []string{"app", "sidekiq", "systemkiq", "searchkiq"}, "The deployments to scale down during a backup or restore")
+	RootCmd.PersistentFlags().StringSlice("quiesce-statefulsets", []string{}, "The statefulsets to scale down during a backup or restore")
+	RootCmd.PersistentFlags().String("quiesce-selector", "", "Also scale down the deployments and statefulsets matching this label selector")
+	viper.BindPFlag("quiesce.operator", RootCmd.PersistentFlags().Lookup("quiesce-operator"))
+	viper.BindPFlag("quiesce.deployments", RootCmd.PersistentFlags().Lookup("quiesce-deployments"))
+	viper.BindPFlag("quiesce.statefulsets", RootCmd.PersistentFlags().Lookup("quiesce-statefulsets"))
+	viper.BindPFlag("quiesce.selector", RootCmd.PersistentFlags().Lookup("quiesce-selector"))
+
+	// Persistent flag for how long to wait for pods to terminate after a scale down or become available after a scale up
+	RootCmd.PersistentFlags().Duration("wait-timeout", 5*time.Minute, "How long to wait for pods to terminate or become available when scaling")
+	viper.BindPFlag("wait.timeout", RootCmd.PersistentFlags().Lookup("wait-timeout"))
+
+	// Persistent flag to take the namespace lock even if another run holds it
+	RootCmd.PersistentFlags().Bool("break-lock", false, "Take the cnvrgctl-lock lease even if another backup, restore or maintenance run holds it")
+	viper.BindPFlag("lock.break", RootCmd.PersistentFlags().Lookup("break-lock"))
 
 	// Start the logging for the cli
 	err := setLogger()
@@ -113,57 +143,24 @@ func initConfig() {
 	}
 }
 
+// Connects to the cluster chosen with the root flags and creates the typed, dynamic and
+// controller-runtime clients from the same rest config
 func ConnectToK8s() (*KubernetesAPI, error) {
 
 	// Create Kubernetes client variable from the struct KubernetesAPI
 	api := KubernetesAPI{}
 
-	kubeContextFlag, err := RootCmd.Flags().GetString("context")
-	if err != nil {
-		return nil, fmt.Errorf("error reading the kubeconfig context. %w", err)
-	}
-
-	kubeConfigFlag, err := RootCmd.Flags().GetString("kubeconfig")
-	if err != nil {
-		return nil, fmt.Errorf("error getting the kubeconfig path. %w", err)
-	}
-
-	// defining the rest api client used in creating argocd applications
-	api.Rest, err = restapi.New(config.GetConfigOrDie(), restapi.Options{})
+	// honors --kubeconfig, --context, --as, --as-group and --request-timeout
+	config, err := RESTConfig()
 	if err != nil {
-		return nil, fmt.Errorf("error getting the kubeconfig path. %w", err)
+		return nil, err
 	}
-
-	// If KUBECONFIG is not set, use default path
-	//TODO look at making this a case statement
-	envKubeConfig := os.Getenv("KUBECONFIG")
-	kubeconfig := kubeConfigFlag
-
-	if kubeConfigFlag == "" {
-		kubeconfig = envKubeConfig
-		if kubeconfig == "" {
-			kubeconfig = homeDir() + "/.kube/config"
-		}
-	}
-
-	// Use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	api.Config = config
 
+	// defining the rest api client used in creating argocd applications
+	api.Rest, err = restapi.New(config, restapi.Options{})
 	if err != nil {
-		// If building config fails, try in-cluster config
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("error building the kubeconfig. %w", err)
-		}
-	}
-
-	// Use context inputed by context flag
-	if kubeConfigFlag != "" {
-		config, err = buildConfigWithContextFromFlags(kubeContextFlag, kubeconfig)
-		if err != nil {
-			return nil, fmt.Errorf("the context doesn't exists. %w", err)
-		}
+		return nil, fmt.Errorf("error creating the controller-runtime client. %w", err)
 	}
 
 	api.Client, err = kubernetes.NewForConfig(config)
@@ -173,41 +170,51 @@ func ConnectToK8s() (*KubernetesAPI, error) {
 
 	// create the dynamic client
 	//TODO understand why this is created
-	api.Dynamic = *dynamic.NewForConfigOrDie(config)
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the dynamic client. %w", err)
+	}
+	api.Dynamic = *dynamicClient
+
+	// requests stop when cnvrgctl is interrupted
+	api.Ctx = Context()
+
+	// run commands in pods through the api server
+	api.Exec = &SPDYExecutor{Client: api.Client, Config: config}
 
 	return &api, nil
 }
 
-// Gets the home env variable for linux/windows
-func homeDir() string {
-	if h := os.Getenv("HOME"); h != "" {
-		return h
+// Returns who is running cnvrgctl as user@hostname, recorded on the cluster by maintenance mode
+func Identity() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
 	}
-	return os.Getenv("USERPROFILE") // Windows
-}
-
-// Build the client config when a context is specified.
-func buildConfigWithContextFromFlags(context string, kubeconfigPath string) (*rest.Config, error) {
-	fmt.Println(kubeconfigPath)
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: context,
-		}).ClientConfig()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
 }
 
 // TODO add flag to define logs file and path
 func setLogger() error {
 	LOG_FILE_PATH := "cnvrgctl-logs.txt"
 
-	file, err := os.OpenFile(LOG_FILE_PATH, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal(err)
-		return fmt.Errorf("there was an issue creating the log file. %v", err)
+	// the tests don't write a log file into the package folders
+	var out io.Writer = io.Discard
+	if !testing.Testing() {
+		file, err := os.OpenFile(LOG_FILE_PATH, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			log.Fatal(err)
+			return fmt.Errorf("there was an issue creating the log file. %v", err)
+		}
+		out = file
 	}
-	log.SetOutput(file)
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	WarningLogger = log.New(file, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	log.SetOutput(out)
+	InfoLogger = log.New(out, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+	WarningLogger = log.New(out, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ErrorLogger = log.New(out, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 	return nil
 }
@@ -0,0 +1,59 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package scale
+
+import (
+	"log"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the scale restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Scale the workloads back to the replica counts saved before a backup or restore",
+	Long: `Scales the deployments and statefulsets in the quiesce set back to the number of
+replicas saved in the cnvrgctl/replicas annotation when they were scaled down and
+resumes any paused HorizontalPodAutoscalers. Workloads still carrying the annotation
+are restored even if they are no longer in the quiesce set. Use this command to
+bring cnvrg.io back after a backup or restore crashed or was interrupted.
+
+Examples:
+
+# Restore the saved replica counts in the cnvrg namespace.
+  cnvrgctl scale restore -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("scale restore command called")
+
+		// grab the namespace from the -n flag if not specified default is used
+		nsFlag, _ := cmd.Flags().GetString("namespace")
+
+		// connect to kubernetes and define clientset and rest client
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			root.Fatalf("error connecting to the cluster, check your connectivity. %v", err)
+		}
+
+		// lock the namespace so no other backup, restore or maintenance runs at the same time
+		lock, err := root.AcquireLock(api, nsFlag, cmd.CommandPath())
+		if err != nil {
+			root.Fatalf("error locking the namespace. %v", err)
+		}
+		defer lock.Release()
+
+		// run the on-failure hooks if anything below fails
+		root.RunHooksOnFailure(api, nsFlag)
+
+		// scale the deployments back to the saved replica counts
+		err = root.ScaleDeployUp(api, nsFlag)
+		if err != nil {
+			root.Fatalf("there was a problem with scaling up the pods. %v", err)
+		}
+	},
+}
+
+func init() {
+	scaleCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,32 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package scale
+
+import (
+	"log"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// scaleCmd represents the scale command
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Manage the scale of the cnvrg.io application deployments",
+	Long: `The backup and restore commands scale the app, cnvrg-operator and 'kiq'
+deployments to 0 and save the original number of replicas on each deployment.
+Use the scale commands to recover if a backup or restore was interrupted.
+
+Examples:
+
+# Scale the deployments in the cnvrg namespace back to their saved replica counts.
+  cnvrgctl scale restore -n cnvrg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("called the scale command")
+	},
+}
+
+func init() {
+	root.RootCmd.AddCommand(scaleCmd)
+}
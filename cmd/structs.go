@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -12,6 +14,12 @@ type KubernetesAPI struct {
 	Client  kubernetes.Interface
 	Dynamic dynamic.DynamicClient
 	Config  *rest.Config
+
+	// cancelled when cnvrgctl is interrupted, read it with Context()
+	Ctx context.Context
+
+	// runs commands in pods, read it with Executor()
+	Exec PodExecutor
 }
 
 type ObjectStorage struct {
@@ -25,6 +33,13 @@ type ObjectStorage struct {
 	BucketName string
 	UseSSL     bool
 	Namespace  string
+
+	// don't verify the TLS certificate
+	Insecure bool
+
+	// the PEM certificates the TLS certificate is verified with, like the CA of the MinIO
+	// tenant, the system CAs when empty
+	CACerts []byte
 }
 
 type Flags struct {
@@ -0,0 +1,249 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/dilerous/cnvrgctl/cmd/logs"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Index is written to index.json at the root of the bundle and lists every file collected
+type Index struct {
+	CreatedAt  time.Time    `json:"createdAt"`
+	Version    string       `json:"cnvrgctlVersion"`
+	Namespaces []string     `json:"namespaces"`
+	Files      []IndexEntry `json:"files"`
+}
+
+// IndexEntry is a file of the bundle, or the error that kept it from being collected
+type IndexEntry struct {
+	Path        string `json:"path"`
+	Description string `json:"description"`
+	Namespace   string `json:"namespace,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	// the number of secrets replaced with [REDACTED] in the file, or in all the files of a folder
+	Redactions int `json:"redactions,omitempty"`
+}
+
+// helmRelease is the part of a Helm release support needs, the values are the ones set by the user
+type helmRelease struct {
+	Name       string                 `json:"name"`
+	Namespace  string                 `json:"namespace"`
+	Revision   int                    `json:"revision"`
+	Status     string                 `json:"status"`
+	Chart      string                 `json:"chart"`
+	AppVersion string                 `json:"appVersion"`
+	Updated    time.Time              `json:"updated"`
+	Values     map[string]interface{} `json:"values"`
+}
+
+// bundle collects the cluster state into a folder, recording each file in the index
+type bundle struct {
+	dir       string
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+
+	// lists the Helm releases of a namespace, helmReleases unless a test replaces it
+	releases func(ns string) ([]*release.Release, error)
+
+	// the number of lines of each log, 0 for the whole log
+	tailLines int64
+
+	// replaces the secrets in every file written, nil writes them as is
+	redactor *root.Redactor
+
+	index Index
+}
+
+// Collects the cluster information, then for every namespace the events, pods, PVCs, CnvrgApps,
+// Helm releases and container logs into the folder "dir". Something that can't be collected is
+// recorded in the index with its error and the next item is collected
+func (b *bundle) collect(ctx context.Context, namespaces []string) error {
+	log.Println("collect function called.")
+
+	err := os.MkdirAll(b.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating the folder. %w", err)
+	}
+	b.index = Index{CreatedAt: time.Now().UTC(), Version: root.Version, Namespaces: namespaces}
+
+	fmt.Println("Collecting the cluster information.")
+	b.add("cluster/version.yaml", "Kubernetes server version", "", func() (interface{}, error) {
+		return b.clientset.Discovery().ServerVersion()
+	})
+	b.add("cluster/nodes.yaml", "nodes with their capacity, conditions and taints", "", func() (interface{}, error) {
+		return b.clientset.CoreV1().Nodes().List(ctx, v1.ListOptions{})
+	})
+	b.add("cluster/cnvrginfras.yaml", "CnvrgInfra custom resources", "", func() (interface{}, error) {
+		return b.dynamic.Resource(root.CnvrgInfraResource).List(ctx, v1.ListOptions{})
+	})
+
+	for _, ns := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("collecting the support bundle was interrupted. %w", err)
+		}
+		fmt.Printf("Collecting namespace %s.\n", ns)
+
+		b.add(ns+"/events.yaml", "events sorted by time", ns, func() (interface{}, error) {
+			events, err := b.clientset.CoreV1().Events(ns).List(ctx, v1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			sort.SliceStable(events.Items, func(i, j int) bool {
+				return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+			})
+			return events, nil
+		})
+		b.add(ns+"/pods.yaml", "pod specs and statuses", ns, func() (interface{}, error) {
+			return b.clientset.CoreV1().Pods(ns).List(ctx, v1.ListOptions{})
+		})
+		b.add(ns+"/pvcs.yaml", "persistent volume claims and their status", ns, func() (interface{}, error) {
+			return b.clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, v1.ListOptions{})
+		})
+		b.add(ns+"/cnvrgapps.yaml", "CnvrgApp custom resources", ns, func() (interface{}, error) {
+			return b.dynamic.Resource(root.CnvrgAppResource).Namespace(ns).List(ctx, v1.ListOptions{})
+		})
+		b.add(ns+"/helm-releases.yaml", "Helm releases with their user supplied values", ns, func() (interface{}, error) {
+			releases, err := b.releases(ns)
+			if err != nil {
+				return nil, err
+			}
+			var out []helmRelease
+			for _, r := range releases {
+				hr := helmRelease{Name: r.Name, Namespace: r.Namespace, Revision: r.Version, Values: r.Config}
+				if r.Info != nil {
+					hr.Status = r.Info.Status.String()
+					hr.Updated = r.Info.LastDeployed.Time
+				}
+				if r.Chart != nil && r.Chart.Metadata != nil {
+					hr.Chart = r.Chart.Metadata.Name + "-" + r.Chart.Metadata.Version
+					hr.AppVersion = r.Chart.Metadata.AppVersion
+				}
+				out = append(out, hr)
+			}
+			return out, nil
+		})
+
+		// the logs are saved by the logs command, one file per container
+		entry := IndexEntry{Path: ns + "/logs/", Description: "container logs, one file per container and previous instance", Namespace: ns}
+		err := logs.SaveLogs(ctx, b.clientset, ns, filepath.Join(b.dir, ns, "logs"), b.tailLines, b.redactor)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		b.record(entry)
+	}
+
+	b.countRedactions()
+	return b.writeIndex()
+}
+
+// Adds the redactions of each file to its index entry, the log files are counted in their folder
+func (b *bundle) countRedactions() {
+	for file, count := range b.redactor.Counts() {
+		path, err := filepath.Rel(b.dir, file)
+		if err != nil {
+			path = file
+		}
+		path = filepath.ToSlash(path)
+
+		for i, entry := range b.index.Files {
+			if entry.Path == path || (strings.HasSuffix(entry.Path, "/") && strings.HasPrefix(path, entry.Path)) {
+				b.index.Files[i].Redactions += count
+				break
+			}
+		}
+	}
+}
+
+// Writes the object returned by "get" to "path" in the bundle as YAML and records it in the index
+func (b *bundle) add(path string, description string, ns string, get func() (interface{}, error)) {
+	entry := IndexEntry{Path: path, Description: description, Namespace: ns}
+
+	obj, err := get()
+	if err == nil {
+		err = b.writeYAML(path, obj)
+	}
+	if err != nil {
+		log.Printf("error collecting %s. %v\n", path, err)
+		fmt.Fprintf(os.Stderr, "error collecting %s: %v\n", path, err)
+		entry.Error = err.Error()
+	}
+	b.record(entry)
+}
+
+func (b *bundle) record(entry IndexEntry) {
+	b.index.Files = append(b.index.Files, entry)
+}
+
+// the secrets in the object, like the env of the pods or the values of the releases, are redacted
+func (b *bundle) writeYAML(path string, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("error converting %s to yaml. %w", path, err)
+	}
+	return b.writeFile(path, []byte(b.redactor.Redact(filepath.Join(b.dir, filepath.FromSlash(path)), string(data))))
+}
+
+func (b *bundle) writeFile(path string, data []byte) error {
+	file := filepath.Join(b.dir, filepath.FromSlash(path))
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating the folder. %w", err)
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// the index is written last so it lists every file
+func (b *bundle) writeIndex() error {
+	data, err := json.MarshalIndent(b.index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error converting the index to json. %w", err)
+	}
+	return b.writeFile("index.json", data)
+}
+
+// Lists every Helm release of the namespace, including the failed and superseded ones
+func helmReleases(ns string) ([]*release.Release, error) {
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(root.RESTClientGetter(), ns, os.Getenv("HELM_DRIVER"), log.Printf)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up helm. %w", err)
+	}
+
+	client := action.NewList(actionConfig)
+	client.All = true
+	client.StateMask = action.ListAll
+	return client.Run()
+}
+
+// Writes the bundle folder to an archive next to it in the format, tar.gz or zip, and returns its
+// path. The paths in the archive start with the name of the folder so it extracts into one folder
+func (b *bundle) archive(format string) (string, error) {
+	log.Println("archive function called.")
+
+	archive, err := root.CreateArchive(filepath.Dir(b.dir), filepath.Base(b.dir), format)
+	if err != nil {
+		return "", err
+	}
+	err = archive.AddDir(b.dir)
+	if err != nil {
+		archive.Close()
+		return "", fmt.Errorf("error writing the archive %v. %w", archive.Path, err)
+	}
+	return archive.Path, archive.Close()
+}
@@ -0,0 +1,161 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// reads the names and contents of the files in the tar.gz archive
+func readArchive(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("archive not created: %v", err)
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("archive is not gzipped: %v", err)
+	}
+	tr := tar.NewReader(gzr)
+
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("error reading the archive: %v", err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestCollectBundle(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "REDIS_URL", Value: "redis://:s3cr3t-redis@redis:6379"},
+				{Name: "CNVRG_STORAGE_SECRET_KEY", Value: "literal-storage-secret"},
+				{Name: "CNVRG_STORAGE_REGION", Value: "us-east-1"},
+			},
+		}}},
+	}
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1.oom", Namespace: "cnvrg"},
+		Reason:     "OOMKilling",
+	}
+	clientset := fake.NewSimpleClientset(pod, event)
+
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(schema.GroupVersionKind{Group: "mlops.cnvrg.io", Version: "v1", Kind: "CnvrgApp"})
+	app.SetName("cnvrg-app")
+	app.SetNamespace("cnvrg")
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		root.CnvrgAppResource:   "CnvrgAppList",
+		root.CnvrgInfraResource: "CnvrgInfraList",
+	}, app)
+
+	// the cnvrg namespace has a release, listing the releases of the other namespace fails
+	releases := func(ns string) ([]*release.Release, error) {
+		if ns != "cnvrg" {
+			return nil, errors.New("fake helm error")
+		}
+		return []*release.Release{{
+			Name:      "cnvrg",
+			Namespace: ns,
+			Version:   2,
+			Info:      &release.Info{Status: release.StatusDeployed},
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "cnvrg", Version: "5.0.0"}},
+			Config:    map[string]interface{}{"clusterDomain": "cnvrg.example.com"},
+		}}, nil
+	}
+
+	name := root.ArchiveName("support", "kind-dev", []string{"cnvrg", "other"}, time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	b := &bundle{dir: filepath.Join(t.TempDir(), name), clientset: clientset, dynamic: dynamic, releases: releases}
+	b.redactor = root.NewRedactor("s3cr3t-redis", "fake logs")
+
+	err := b.collect(context.Background(), []string{"cnvrg", "other"})
+	if err != nil {
+		t.Fatalf("bundle not collected: %v", err)
+	}
+	archive, err := b.archive(root.ArchiveTarGz)
+	if err != nil {
+		t.Fatalf("archive not created: %v", err)
+	}
+	if archive != b.dir+".tar.gz" {
+		t.Fatalf("expected the archive next to the folder, got %v", archive)
+	}
+
+	files := readArchive(t, archive)
+	for _, path := range []string{"index.json", "cluster/version.yaml", "cluster/nodes.yaml", "cluster/cnvrginfras.yaml",
+		"cnvrg/events.yaml", "cnvrg/pods.yaml", "cnvrg/pvcs.yaml", "cnvrg/cnvrgapps.yaml", "cnvrg/helm-releases.yaml",
+		"cnvrg/logs/app-1_app.txt", "other/pods.yaml"} {
+		if _, ok := files[name+"/"+path]; !ok {
+			t.Errorf("expected %s in the archive", path)
+		}
+	}
+	if !strings.Contains(files[name+"/cnvrg/events.yaml"], "OOMKilling") {
+		t.Errorf("expected the event in events.yaml, got %q", files[name+"/cnvrg/events.yaml"])
+	}
+	if !strings.Contains(files[name+"/cnvrg/cnvrgapps.yaml"], "cnvrg-app") {
+		t.Errorf("expected the CnvrgApp in cnvrgapps.yaml, got %q", files[name+"/cnvrg/cnvrgapps.yaml"])
+	}
+	if pods := files[name+"/cnvrg/pods.yaml"]; strings.Contains(pods, "s3cr3t-redis") || !strings.Contains(pods, "redis://:[REDACTED]@redis:6379") {
+		t.Errorf("expected the password redacted in pods.yaml, got %q", pods)
+	}
+	// a literal env value is on the line after its name
+	if pods := files[name+"/cnvrg/pods.yaml"]; strings.Contains(pods, "literal-storage-secret") || !strings.Contains(pods, "value: us-east-1") {
+		t.Errorf("expected the storage secret key redacted in pods.yaml, got %q", pods)
+	}
+	if files[name+"/cnvrg/logs/app-1_app.txt"] != "[REDACTED]" {
+		t.Errorf("expected the log redacted, got %q", files[name+"/cnvrg/logs/app-1_app.txt"])
+	}
+	if !strings.Contains(files[name+"/cnvrg/helm-releases.yaml"], "clusterDomain: cnvrg.example.com") {
+		t.Errorf("expected the release values in helm-releases.yaml, got %q", files[name+"/cnvrg/helm-releases.yaml"])
+	}
+
+	// the index lists every file and the error of the release that couldn't be listed
+	var index Index
+	if err := json.Unmarshal([]byte(files[name+"/index.json"]), &index); err != nil {
+		t.Fatalf("index.json not valid: %v", err)
+	}
+	var failed []string
+	redactions := map[string]int{}
+	for _, f := range index.Files {
+		if f.Error != "" {
+			failed = append(failed, f.Path)
+		}
+		redactions[f.Path] = f.Redactions
+	}
+	if redactions["cnvrg/pods.yaml"] != 2 || redactions["cnvrg/logs/"] != 1 || redactions["cnvrg/events.yaml"] != 0 {
+		t.Errorf("unexpected redactions in the index %v", redactions)
+	}
+	if len(index.Files) != 15 || len(failed) != 1 || failed[0] != "other/helm-releases.yaml" {
+		t.Fatalf("expected 15 entries with other/helm-releases.yaml failed, got %d entries and %v failed", len(index.Files), failed)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 NAME HERE BRADLEY.SOPER@CNVRG.IO
+*/
+package support
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	root "github.com/dilerous/cnvrgctl/cmd"
+	"github.com/spf13/cobra"
+)
+
+// supportCmd represents the support-bundle command
+var supportCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "Collect the logs, events, pod specs, cnvrg custom resources and Helm releases in one archive",
+	Long: `Collect everything support asks for into a single timestamped archive,
+cnvrg-support-<cluster>-<namespaces>-<time>.tar.gz, or .zip with --archive=zip. The archive has an index.json listing every
+file collected, the errors of anything that could not be collected and the number
+of secrets replaced with [REDACTED] in each file. The values of the secrets in the
+namespaces, AWS keys, JWTs, passwords in URLs and CNVRG_*_KEY values are redacted
+unless --no-redact is set.
+
+For the cluster:
+  cluster/version.yaml, cluster/nodes.yaml and cluster/cnvrginfras.yaml
+
+For every namespace:
+  <namespace>/events.yaml, pods.yaml, pvcs.yaml, cnvrgapps.yaml, helm-releases.yaml
+  and the container logs in <namespace>/logs/
+
+The namespaces are the one set with -n, the ones listed with --namespaces, every
+namespace with --all-namespaces, or with --cnvrg the namespaces of the installation:
+the control plane, the CnvrgApp and CnvrgInfra namespaces, the namespaces and pods
+labeled for cnvrg and the ingress controllers. --cnvrg and --namespaces can be combined.
+
+--upload=s3://bucket/prefix uploads the archive and prints a download link valid for
+--upload-expiry to share with support. The credentials are read from the MinIO tenant
+created by install minio unless --upload-secret or --upload-access-key and
+--upload-secret-key are set. The tenant found from its ingress is verified with its
+certificate and the cluster CA, add --upload-insecure to upload without verifying it.
+
+Usage:
+  cnvrgctl support-bundle [flags]
+
+Examples:
+  # Collect the support bundle of the cnvrg namespace.
+  cnvrgctl -n cnvrg support-bundle
+
+  # Collect the cnvrg and cnvrg-jobs namespaces with the last 1000 lines of each log.
+  cnvrgctl support-bundle --namespaces=cnvrg,cnvrg-jobs -l=1000
+
+  # Collect every namespace of the cnvrg installation whose control plane is in cnvrg.
+  cnvrgctl -n cnvrg support-bundle --cnvrg
+
+  # Upload the bundle to the MinIO backup bucket created by install minio and print a link valid for 2 days.
+  cnvrgctl -n cnvrg support-bundle --upload=s3://cnvrg-backups/support --upload-expiry=48h
+
+  # Upload the bundle to an S3 bucket.
+  cnvrgctl -n cnvrg support-bundle --upload=s3://my-bucket/cnvrg --upload-endpoint=s3.amazonaws.com \
+    --upload-access-key=AKIA... --upload-secret-key=... --upload-region=us-west-2
+
+  # Save the bundle as a zip archive for support tools that don't read tar.gz.
+  cnvrgctl -n cnvrg support-bundle --archive=zip
+
+  # Save the archive to /tmp and keep the folder it was made from.
+  cnvrgctl -n cnvrg support-bundle --output-dir=/tmp --keep-dir`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("called the support-bundle command.")
+
+		outputDir, _ := cmd.Flags().GetString("output-dir")
+		lines, _ := cmd.Flags().GetInt("lines")
+		keepDir, _ := cmd.Flags().GetBool("keep-dir")
+
+		// calls connect function to set the clientset for kubectl access
+		api, err := root.ConnectToK8s()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to cluster, check your connectivity. %v\n", err)
+			return
+		}
+
+		// the namespaces collected, the namespace from -n unless --namespaces, --all-namespaces or --cnvrg is set
+		namespaces, err := root.ResolveNamespaces(api.Context(), api.Client, &api.Dynamic, root.GetNamespaceOptions(cmd))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error finding the namespaces to collect. %v\n", err)
+			return
+		}
+
+		name := root.ArchiveName("support", root.ClusterName(), namespaces, time.Now())
+		b := &bundle{
+			dir:       filepath.Join(outputDir, name),
+			clientset: api.Client,
+			dynamic:   &api.Dynamic,
+			releases:  helmReleases,
+			tailLines: int64(lines),
+		}
+
+		// replace the secrets of the namespaces and the built-in patterns unless --no-redact is set
+		if noRedact, _ := cmd.Flags().GetBool("no-redact"); !noRedact {
+			b.redactor, err = root.NewNamespaceRedactor(api.Context(), api.Client, namespaces...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+		}
+
+		// the folder is only needed to build the archive
+		if !keepDir {
+			root.OnFailure(func() { os.RemoveAll(b.dir) })
+		}
+
+		err = b.collect(api.Context(), namespaces)
+		if err != nil {
+			root.Fatalf("error collecting the support bundle. %v\n", err)
+		}
+
+		format, _ := cmd.Flags().GetString("archive")
+		archive, err := b.archive(format)
+		if err != nil {
+			root.Fatalf("error creating the support bundle archive. %v\n", err)
+		}
+		if !keepDir {
+			os.RemoveAll(b.dir)
+		}
+
+		// share the archive through a bucket when --upload is set
+		root.UploadAndPrintLink(api.Context(), api.Client, root.GetUploadOptions(cmd), archive, "")
+
+		failed := 0
+		for _, f := range b.index.Files {
+			if f.Error != "" {
+				failed++
+			}
+		}
+		b.redactor.PrintReport(os.Stdout)
+		fmt.Printf("support bundle saved to %s, %d items collected, %d could not be collected, see index.json.\n", archive, len(b.index.Files)-failed, failed)
+	},
+}
+
+func init() {
+	root.RootCmd.AddCommand(supportCmd)
+
+	// the namespaces to collect with --namespaces, --all-namespaces or --cnvrg, the -n namespace when not set
+	root.AddNamespaceFlags(supportCmd)
+
+	// where the archive is saved
+	supportCmd.Flags().StringP("output-dir", "o", ".", "The directory the archive is saved to")
+
+	// the number of log lines of each container
+	supportCmd.Flags().IntP("lines", "l", 0, "The number of lines of each container log, 0 for the whole log")
+
+	// write the files without replacing the secrets
+	supportCmd.Flags().Bool("no-redact", false, "Don't replace the secrets of the namespaces, keys, tokens and passwords with [REDACTED]")
+
+	// upload the archive with --upload and print a download link
+	root.AddUploadFlags(supportCmd)
+
+	// the format of the archive
+	supportCmd.Flags().String("archive", root.ArchiveTarGz, "The archive format, tar.gz or zip")
+
+	// keep the folder the archive was made from
+	supportCmd.Flags().Bool("keep-dir", false, "Keep the bundle folder next to the archive")
+}
@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/spf13/cobra"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the service of the MinIO tenant api, its ingress is the upload endpoint when none is set
+const minioTenantService = "minio"
+
+// the secret of the certificate the MinIO operator creates for the tenant with requestAutoCert,
+// signed with the cluster CA
+const minioTenantTLSSecret = minioTenantService + "-tls"
+
+// a presigned url is valid for at most 7 days
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// UploadOptions are the flags of AddUploadFlags, where to upload an archive and the credentials
+type UploadOptions struct {
+	// s3://bucket/prefix, empty to not upload
+	URL string
+
+	// the S3 api, host[:port] or a url, http:// turns off TLS. Found in the secret or the
+	// ingress of the MinIO tenant when not set
+	Endpoint string
+
+	// the credentials, read from Secret in Namespace when not set
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	// a secret holding the credentials, the config.env of a MinIO tenant or the
+	// CNVRG_STORAGE_* keys of cp-object-storage
+	Secret    string
+	Namespace string
+
+	// how long the download link is valid
+	Expiry time.Duration
+
+	// don't verify the certificate of the endpoint. The endpoint found from the MinIO tenant
+	// ingress is verified with the certificates of the tenant and the cluster CA
+	Insecure bool
+}
+
+// Adds the --upload flags to the command, read them with GetUploadOptions
+func AddUploadFlags(cmd *cobra.Command) {
+	cmd.Flags().String("upload", "", "Upload the archive to s3://bucket/prefix and print a download link, e.g. s3://cnvrg-backups/support for the bucket created by install minio")
+	cmd.Flags().String("upload-endpoint", "", "The S3 api to upload to, e.g. s3.amazonaws.com or http://minio.example.com:9000, found from the secret or the MinIO tenant ingress when not set")
+	cmd.Flags().String("upload-access-key", "", "The access key of the bucket, read from --upload-secret when not set")
+	cmd.Flags().String("upload-secret-key", "", "The secret key of the bucket, read from --upload-secret when not set")
+	cmd.Flags().String("upload-region", "us-east-1", "The region of the bucket")
+	cmd.Flags().String("upload-secret", "myminio-env-configuration", "The secret with the bucket credentials, a MinIO tenant config.env or the CNVRG_STORAGE_* keys of cp-object-storage")
+	cmd.Flags().String("upload-namespace", "minio-operator", "The namespace of --upload-secret and of the MinIO tenant")
+	cmd.Flags().Duration("upload-expiry", 24*time.Hour, "How long the download link is valid, at most 168h")
+	cmd.Flags().Bool("upload-insecure", false, "Don't verify the TLS certificate of the upload endpoint, needed when the certificate of the MinIO tenant can't be verified with its CA")
+	cmd.MarkFlagsRequiredTogether("upload-access-key", "upload-secret-key")
+}
+
+// Returns the upload options set on the command with the flags of AddUploadFlags
+func GetUploadOptions(cmd *cobra.Command) UploadOptions {
+	var o UploadOptions
+	o.URL, _ = cmd.Flags().GetString("upload")
+	o.Endpoint, _ = cmd.Flags().GetString("upload-endpoint")
+	o.AccessKey, _ = cmd.Flags().GetString("upload-access-key")
+	o.SecretKey, _ = cmd.Flags().GetString("upload-secret-key")
+	o.Region, _ = cmd.Flags().GetString("upload-region")
+	o.Secret, _ = cmd.Flags().GetString("upload-secret")
+	o.Namespace, _ = cmd.Flags().GetString("upload-namespace")
+	o.Expiry, _ = cmd.Flags().GetDuration("upload-expiry")
+	o.Insecure, _ = cmd.Flags().GetBool("upload-insecure")
+	return o
+}
+
+// Splits s3://bucket/prefix into the bucket and the prefix, the prefix may be empty
+func ParseUploadURL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing the upload url %v. %w", s, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("the upload url %v must look like s3://bucket/prefix", s)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// Uploads the file to the bucket of the options as <prefix>/<name>, the name of the file when
+// "name" is empty, and returns a presigned download link valid for o.Expiry. The credentials and
+// the endpoint that aren't set are read from the cluster with "clientset"
+func UploadFile(ctx context.Context, clientset kubernetes.Interface, o UploadOptions, file string, name string) (string, error) {
+	log.Println("UploadFile function called.")
+
+	bucket, prefix, err := ParseUploadURL(o.URL)
+	if err != nil {
+		return "", err
+	}
+	if o.Expiry < time.Second || o.Expiry > maxPresignExpiry {
+		return "", fmt.Errorf("the link expiry must be between 1s and %v, got %v", maxPresignExpiry, o.Expiry)
+	}
+
+	storage, err := o.storage(ctx, clientset)
+	if err != nil {
+		return "", err
+	}
+
+	transport, err := minio.DefaultTransport(storage.UseSSL)
+	if err != nil {
+		return "", fmt.Errorf("error creating the http transport. %w", err)
+	}
+	switch {
+	case storage.Insecure:
+		fmt.Printf("the TLS certificate of %s is not verified.\n", storage.Endpoint)
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	case len(storage.CACerts) > 0:
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(storage.CACerts) {
+			return "", fmt.Errorf("no valid certificate found to verify %v, add --upload-insecure to upload without verifying it", storage.Endpoint)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client, err := minio.New(storage.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(storage.AccessKey, storage.SecretKey, storage.SessionKey),
+		Secure:    storage.UseSSL,
+		Region:    storage.Region,
+		Transport: transport,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error connecting to %v. %w", storage.Endpoint, err)
+	}
+
+	if name == "" {
+		name = filepath.Base(file)
+	}
+	key := path.Join(prefix, name)
+	fmt.Printf("uploading %s to s3://%s/%s on %s\n", file, bucket, key, storage.Endpoint)
+	_, err = client.FPutObject(ctx, bucket, key, file, minio.PutObjectOptions{ContentType: contentType(file)})
+	if err != nil && len(storage.CACerts) > 0 {
+		return "", fmt.Errorf("error uploading %v to bucket %v, the certificate of %v was verified with the certificates of the MinIO tenant, set --upload-endpoint or add --upload-insecure if it doesn't match them. %w", file, bucket, storage.Endpoint, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error uploading %v to bucket %v. %w", file, bucket, err)
+	}
+
+	link, err := client.PresignedGetObject(ctx, bucket, key, o.Expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating the download link of %v. %w", key, err)
+	}
+	return link.String(), nil
+}
+
+// Returns the endpoint and the credentials to upload with, the flags first, then the secret,
+// then the ingress of the MinIO tenant for the endpoint. The endpoint found from the ingress is
+// verified with the certificates of the tenant, the credentials are only sent to it unverified
+// with --upload-insecure
+func (o UploadOptions) storage(ctx context.Context, clientset kubernetes.Interface) (*ObjectStorage, error) {
+	storage := &ObjectStorage{AccessKey: o.AccessKey, SecretKey: o.SecretKey, Region: o.Region, Endpoint: o.Endpoint, Insecure: o.Insecure}
+
+	if storage.AccessKey == "" {
+		secret, err := clientset.CoreV1().Secrets(o.Namespace).Get(ctx, o.Secret, v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting the upload credentials from secret %v in namespace %v, set them with --upload-access-key and --upload-secret-key. %w", o.Secret, o.Namespace, err)
+		}
+
+		switch {
+		case len(secret.Data["CNVRG_STORAGE_ACCESS_KEY"]) > 0:
+			// the cnvrg object storage secret
+			object, err := GetObjectSecret(&KubernetesAPI{Client: clientset, Ctx: ctx}, o.Secret, o.Namespace)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the upload credentials from secret %v in namespace %v. %w", o.Secret, o.Namespace, err)
+			}
+			storage.AccessKey = object.AccessKey
+			storage.SecretKey = object.SecretKey
+			if object.Region != "" {
+				storage.Region = object.Region
+			}
+			if storage.Endpoint == "" {
+				storage.Endpoint = object.Endpoint
+			}
+		case len(secret.Data["config.env"]) > 0:
+			// the MinIO tenant configuration, export MINIO_ROOT_USER="..." lines
+			env := parseEnvFile(string(secret.Data["config.env"]))
+			storage.AccessKey = env["MINIO_ROOT_USER"]
+			storage.SecretKey = env["MINIO_ROOT_PASSWORD"]
+		default:
+			storage.AccessKey = string(secret.Data["accesskey"])
+			storage.SecretKey = string(secret.Data["secretkey"])
+		}
+		if storage.AccessKey == "" || storage.SecretKey == "" {
+			return nil, fmt.Errorf("secret %v in namespace %v has no upload credentials, set them with --upload-access-key and --upload-secret-key", o.Secret, o.Namespace)
+		}
+	}
+
+	if storage.Endpoint == "" {
+		ingress, host, err := minioIngress(ctx, clientset, o.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		storage.Endpoint = host
+
+		// the tenant certificate isn't signed by a public CA
+		if !o.Insecure {
+			storage.CACerts, err = minioTenantCerts(ctx, clientset, o.Namespace, ingress)
+			if err != nil {
+				return nil, err
+			}
+			if len(storage.CACerts) == 0 {
+				return nil, fmt.Errorf("no certificate found to verify the MinIO tenant %v in namespace %v, add --upload-insecure to upload without verifying it", host, o.Namespace)
+			}
+		}
+	}
+
+	// http:// turns off TLS, a host without a scheme uses it like S3
+	storage.UseSSL = !strings.HasPrefix(storage.Endpoint, "http://")
+	storage.Endpoint = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(storage.Endpoint, "http://"), "https://"), "/")
+	return storage, nil
+}
+
+// Returns the ingress routing to the MinIO tenant api in namespace "ns" and its host
+func minioIngress(ctx context.Context, clientset kubernetes.Interface, ns string) (*networkingv1.Ingress, string, error) {
+	ingresses, err := clientset.NetworkingV1().Ingresses(ns).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing the ingresses in namespace %v. %w", ns, err)
+	}
+	for i, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil || rule.Host == "" {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				if p.Backend.Service != nil && p.Backend.Service.Name == minioTenantService {
+					return &ingresses.Items[i], rule.Host, nil
+				}
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("no ingress to the MinIO tenant found in namespace %v, set the endpoint with --upload-endpoint", ns)
+}
+
+// Returns the PEM certificates the MinIO tenant in namespace "ns" is verified with: the certificate
+// of the tenant, the TLS secrets of its ingress "ingress" and the cluster CA the operator signs the
+// tenant certificate with. Empty when none of them exist
+func minioTenantCerts(ctx context.Context, clientset kubernetes.Interface, ns string, ingress *networkingv1.Ingress) ([]byte, error) {
+	log.Println("minioTenantCerts function called.")
+
+	var certs []byte
+	secrets := []string{minioTenantTLSSecret}
+	for _, t := range ingress.Spec.TLS {
+		if t.SecretName != "" {
+			secrets = append(secrets, t.SecretName)
+		}
+	}
+	for _, name := range secrets {
+		secret, err := clientset.CoreV1().Secrets(ns).Get(ctx, name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting the certificate secret %v in namespace %v. %w", name, ns, err)
+		}
+		for _, key := range []string{"public.crt", "tls.crt", "ca.crt"} {
+			if len(secret.Data[key]) > 0 {
+				certs = append(append(certs, secret.Data[key]...), '\n')
+			}
+		}
+	}
+
+	// the cluster CA, published in every namespace
+	cm, err := clientset.CoreV1().ConfigMaps(ns).Get(ctx, "kube-root-ca.crt", v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting the cluster CA in namespace %v. %w", ns, err)
+	}
+	if err == nil && cm.Data["ca.crt"] != "" {
+		certs = append(append(certs, cm.Data["ca.crt"]...), '\n')
+	}
+	return certs, nil
+}
+
+// parses the KEY=value and export KEY="value" lines of an env file
+func parseEnvFile(s string) map[string]string {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		env[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return env
+}
+
+// the content type of the archive, the download keeps the right type
+func contentType(file string) string {
+	switch {
+	case strings.HasSuffix(file, ".zip"):
+		return "application/zip"
+	case strings.HasSuffix(file, ".gz"):
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
+
+// Uploads the file as "name" when o.URL is set and prints the download link, the errors are
+// printed as the archive is still saved locally
+func UploadAndPrintLink(ctx context.Context, clientset kubernetes.Interface, o UploadOptions, file string, name string) {
+	if o.URL == "" {
+		return
+	}
+	link, err := UploadFile(ctx, clientset, o, file, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error uploading the archive, it is still saved to %s. %v\n", file, err)
+		return
+	}
+	fmt.Printf("uploaded %s, download it for the next %v from:\n%s\n", file, o.Expiry, link)
+}
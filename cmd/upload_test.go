@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseUploadURL(t *testing.T) {
+	testCases := []struct {
+		in, bucket, prefix string
+		expectSuccess      bool
+	}{
+		{"s3://cnvrg-backups/support/2024", "cnvrg-backups", "support/2024", true},
+		{"s3://cnvrg-backups", "cnvrg-backups", "", true},
+		{"s3://cnvrg-backups/", "cnvrg-backups", "", true},
+		{"https://cnvrg-backups/support", "", "", false},
+		{"cnvrg-backups/support", "", "", false},
+	}
+	for _, tc := range testCases {
+		bucket, prefix, err := ParseUploadURL(tc.in)
+		if (err == nil) != tc.expectSuccess || bucket != tc.bucket || prefix != tc.prefix {
+			t.Errorf("%s: expected %q %q success=%v, got %q %q %v", tc.in, tc.bucket, tc.prefix, tc.expectSuccess, bucket, prefix, err)
+		}
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	// a fake S3 api that keeps the objects put
+	var (
+		mu      sync.Mutex
+		objects = map[string]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Path] = string(b)
+		mu.Unlock()
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// the credentials of the MinIO tenant created by install minio
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "myminio-env-configuration", Namespace: "minio-operator"},
+		Data:       map[string][]byte{"config.env": []byte("export MINIO_ROOT_USER=\"minio\"\nexport MINIO_ROOT_PASSWORD=\"minio123\"\n")},
+	}
+	clientset := fake.NewSimpleClientset(secret)
+
+	file := filepath.Join(t.TempDir(), "cnvrg-support-cnvrg-20240601-100000.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := UploadOptions{
+		URL:       "s3://cnvrg-backups/support",
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Secret:    "myminio-env-configuration",
+		Namespace: "minio-operator",
+		Expiry:    time.Hour,
+	}
+	link, err := UploadFile(context.Background(), clientset, o, file, "")
+	if err != nil {
+		t.Fatalf("file not uploaded: %v", err)
+	}
+
+	// without TLS the body is sent in signed chunks around the content
+	key := "/cnvrg-backups/support/cnvrg-support-cnvrg-20240601-100000.tar.gz"
+	if !strings.Contains(objects[key], "archive") {
+		t.Fatalf("expected the archive uploaded to %s, got %v", key, objects)
+	}
+
+	// the link is signed with the tenant credentials and expires
+	u, err := url.Parse(link)
+	if err != nil || u.Path != key || u.Query().Get("X-Amz-Expires") != "3600" || !strings.HasPrefix(u.Query().Get("X-Amz-Credential"), "minio/") {
+		t.Fatalf("unexpected download link %v %v", link, err)
+	}
+
+	// the expiry of presigned links is limited to 7 days
+	o.Expiry = 8 * 24 * time.Hour
+	if _, err := UploadFile(context.Background(), clientset, o, file, ""); err == nil {
+		t.Error("expected an error for an expiry over 7 days")
+	}
+}
+
+func TestUploadStorage(t *testing.T) {
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio", Namespace: "minio-operator"},
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+			Host: "minio.example.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{
+				Path:     "/",
+				PathType: &pathType,
+				Backend:  networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "minio"}},
+			}}}},
+		}}},
+	}
+	cnvrgSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp-object-storage", Namespace: "cnvrg"},
+		Data: map[string][]byte{
+			"CNVRG_STORAGE_ACCESS_KEY": []byte("cnvrg-key"),
+			"CNVRG_STORAGE_SECRET_KEY": []byte("cnvrg-secret"),
+			"CNVRG_STORAGE_ENDPOINT":   []byte("http://minio.cnvrg.example.com"),
+			"CNVRG_STORAGE_REGION":     []byte("eu-west-1"),
+			"CNVRG_STORAGE_TYPE":       []byte("minio"),
+			"CNVRG_STORAGE_BUCKET":     []byte("cnvrg-storage"),
+		},
+	}
+	tenantSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "myminio-env-configuration", Namespace: "minio-operator"},
+		Data:       map[string][]byte{"config.env": []byte("export MINIO_ROOT_USER=minio\nexport MINIO_ROOT_PASSWORD='minio123'\n")},
+	}
+	clientset := fake.NewSimpleClientset(ingress, cnvrgSecret, tenantSecret)
+	tenant := UploadOptions{Secret: "myminio-env-configuration", Namespace: "minio-operator", Region: "us-east-1"}
+
+	// without a certificate to verify the tenant with, skipping the check must be asked for
+	if _, err := tenant.storage(context.Background(), clientset); err == nil || !strings.Contains(err.Error(), "--upload-insecure") {
+		t.Fatalf("expected an error asking for --upload-insecure, got %v", err)
+	}
+	insecure := tenant
+	insecure.Insecure = true
+	s, err := insecure.storage(context.Background(), clientset)
+	if err != nil || !s.Insecure || len(s.CACerts) != 0 {
+		t.Fatalf("unexpected storage %+v %v", s, err)
+	}
+
+	// the MinIO tenant credentials and the host of its ingress, verified with the tenant certificate
+	// and the cluster CA
+	clientset.CoreV1().Secrets("minio-operator").Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio-tls", Namespace: "minio-operator"},
+		Data:       map[string][]byte{"public.crt": []byte("tenant-cert"), "private.key": []byte("tenant-key")},
+	}, metav1.CreateOptions{})
+	clientset.CoreV1().ConfigMaps("minio-operator").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "minio-operator"},
+		Data:       map[string]string{"ca.crt": "cluster-ca"},
+	}, metav1.CreateOptions{})
+	s, err = tenant.storage(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("storage not found: %v", err)
+	}
+	if s.AccessKey != "minio" || s.SecretKey != "minio123" || s.Endpoint != "minio.example.com" || !s.UseSSL || s.Insecure {
+		t.Fatalf("unexpected storage %+v", s)
+	}
+	if string(s.CACerts) != "tenant-cert\ncluster-ca\n" {
+		t.Fatalf("expected the tenant certificate and the cluster CA, got %q", s.CACerts)
+	}
+
+	// the cnvrg object storage secret has its own endpoint and region
+	s, err = UploadOptions{Secret: "cp-object-storage", Namespace: "cnvrg", Region: "us-east-1"}.storage(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("storage not found: %v", err)
+	}
+	if s.AccessKey != "cnvrg-key" || s.SecretKey != "cnvrg-secret" || s.Endpoint != "minio.cnvrg.example.com" || s.UseSSL || s.Region != "eu-west-1" || s.Insecure {
+		t.Fatalf("unexpected storage %+v", s)
+	}
+
+	// the flags win over the secret
+	s, err = UploadOptions{AccessKey: "a", SecretKey: "b", Endpoint: "https://s3.amazonaws.com/", Secret: "missing"}.storage(context.Background(), clientset)
+	if err != nil || s.AccessKey != "a" || s.Endpoint != "s3.amazonaws.com" || !s.UseSSL || s.Insecure {
+		t.Fatalf("unexpected storage %+v %v", s, err)
+	}
+
+	// no credentials
+	if _, err := (UploadOptions{Secret: "missing", Namespace: "minio-operator"}).storage(context.Background(), clientset); err == nil {
+		t.Error("expected an error for a missing secret")
+	}
+}
+
+func TestUploadFileTenantTLS(t *testing.T) {
+	// the MinIO tenant api behind its ingress, with a certificate no public CA signed
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio", Namespace: "minio-operator"},
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{
+				Path:     "/",
+				PathType: &pathType,
+				Backend:  networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "minio"}},
+			}}}},
+		}}},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "myminio-env-configuration", Namespace: "minio-operator"},
+		Data:       map[string][]byte{"config.env": []byte("export MINIO_ROOT_USER=\"minio\"\nexport MINIO_ROOT_PASSWORD=\"minio123\"\n")},
+	}
+	// a CA that didn't sign the server certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "cluster-ca"}, NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+	ca, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterCA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "minio-operator"},
+		Data:       map[string]string{"ca.crt": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca}))},
+	}
+
+	file := filepath.Join(t.TempDir(), "cnvrg-logs.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := UploadOptions{URL: "s3://cnvrg-backups/support", Region: "us-east-1", Secret: "myminio-env-configuration", Namespace: "minio-operator", Expiry: time.Hour}
+
+	// the certificate isn't trusted, the credentials are not sent
+	clientset := fake.NewSimpleClientset(ingress, credentials, clusterCA)
+	_, err = UploadFile(context.Background(), clientset, o, file, "")
+	if err == nil || !strings.Contains(err.Error(), "--upload-insecure") {
+		t.Fatalf("expected the unverified certificate to fail the upload, got %v", err)
+	}
+
+	// the certificate of the tenant verifies the endpoint
+	tenantCert := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio-tls", Namespace: "minio-operator"},
+		Data:       map[string][]byte{"public.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})},
+	}
+	clientset = fake.NewSimpleClientset(ingress, credentials, clusterCA, tenantCert)
+	if _, err := UploadFile(context.Background(), clientset, o, file, ""); err != nil {
+		t.Fatalf("expected the upload verified with the tenant certificate: %v", err)
+	}
+
+	// skipping the verification is an explicit choice
+	o.Insecure = true
+	clientset = fake.NewSimpleClientset(ingress, credentials)
+	if _, err := UploadFile(context.Background(), clientset, o, file, ""); err != nil {
+		t.Fatalf("expected the upload with --upload-insecure: %v", err)
+	}
+}
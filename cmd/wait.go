@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// how long to wait for pods to terminate or workloads to become available, set with --wait-timeout
+func GetWaitTimeout() time.Duration {
+	return viper.GetDuration("wait.timeout")
+}
+
+// Waits until every pod of the "workloads" in namespace "ns" is gone, returns an error if they are
+// still running after "timeout". Used after scaling down so a backup never starts while the app is
+// still writing
+func WaitForPodsGone(api *KubernetesAPI, ns string, workloads []Workload, timeout time.Duration) error {
+	log.Println("WaitForPodsGone function called.")
+
+	ctx, cancel := context.WithTimeout(api.Context(), timeout)
+	defer cancel()
+
+	for _, w := range workloads {
+		selector, err := getWorkloadSelector(api, ns, w)
+		if err != nil {
+			return err
+		}
+
+		// a workload without a selector has no pods to wait for
+		if selector == "" {
+			continue
+		}
+
+		err = waitForNoPods(ctx, api, ns, w, selector)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("all pods finished terminating.")
+	return nil
+}
+
+// lists the pods matching "selector" and watches for deletes until none are left
+func waitForNoPods(ctx context.Context, api *KubernetesAPI, ns string, w Workload, selector string) error {
+	for {
+		pods, err := api.Client.CoreV1().Pods(ns).List(ctx, v1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("the pods of %s to terminate", w), err)
+		}
+		if len(pods.Items) == 0 {
+			return nil
+		}
+		fmt.Printf("waiting for %d pod(s) of %s to terminate...\n", len(pods.Items), w)
+
+		// watch from the list so no delete is missed, then list again
+		watcher, err := api.Client.CoreV1().Pods(ns).Watch(ctx, v1.ListOptions{
+			LabelSelector:   selector,
+			ResourceVersion: pods.ResourceVersion,
+		})
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("the pods of %s to terminate", w), err)
+		}
+
+		err = waitForEvent(ctx, watcher, watch.Deleted)
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("the pods of %s to terminate", w), err)
+		}
+	}
+}
+
+// Waits until every one of the "workloads" in namespace "ns" has all of its replicas updated and
+// available, like kubectl rollout status. Returns an error if they aren't available after "timeout"
+func WaitForRollout(api *KubernetesAPI, ns string, workloads []Workload, timeout time.Duration) error {
+	log.Println("WaitForRollout function called.")
+
+	ctx, cancel := context.WithTimeout(api.Context(), timeout)
+	defer cancel()
+
+	for _, w := range workloads {
+		err := waitForAvailable(ctx, api, ns, w)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("all workloads are available.")
+	return nil
+}
+
+// gets the rollout status of "w" and watches it for changes until every replica is available
+func waitForAvailable(ctx context.Context, api *KubernetesAPI, ns string, w Workload) error {
+	var last string
+
+	for {
+		done, status, resourceVersion, err := getRolloutStatus(ctx, api, ns, w)
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("%s to become available", w), err)
+		}
+
+		// only print the status when it changes
+		if status != last {
+			fmt.Printf("%s: %s\n", w, status)
+			log.Printf("%s: %s\n", w, status)
+			last = status
+		}
+		if done {
+			return nil
+		}
+
+		// watch only this workload, starting from the version just read
+		opts := v1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", w.Name).String(),
+			ResourceVersion: resourceVersion,
+		}
+
+		var watcher watch.Interface
+		switch w.Kind {
+		case KindDeployment:
+			watcher, err = api.Client.AppsV1().Deployments(ns).Watch(ctx, opts)
+		case KindStatefulSet:
+			watcher, err = api.Client.AppsV1().StatefulSets(ns).Watch(ctx, opts)
+		}
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("%s to become available", w), err)
+		}
+
+		err = waitForEvent(ctx, watcher, watch.Modified)
+		if err != nil {
+			return waitError(ctx, fmt.Sprintf("%s to become available", w), err)
+		}
+	}
+}
+
+// returns true once every replica of "w" is updated and available, along with a status message
+// and the resource version to watch from
+func getRolloutStatus(ctx context.Context, api *KubernetesAPI, ns string, w Workload) (bool, string, string, error) {
+	switch w.Kind {
+	case KindDeployment:
+		deploy, err := api.Client.AppsV1().Deployments(ns).Get(ctx, w.Name, v1.GetOptions{})
+		if err != nil {
+			return false, "", "", fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
+		}
+		status := deploy.Status
+
+		// same checks as kubectl rollout status, old replicas must be gone as well
+		done := status.ObservedGeneration >= deploy.Generation &&
+			status.UpdatedReplicas == replicas &&
+			status.Replicas == replicas &&
+			status.AvailableReplicas == replicas
+		message := fmt.Sprintf("%d of %d replica(s) available", status.AvailableReplicas, replicas)
+		return done, message, deploy.ResourceVersion, nil
+
+	case KindStatefulSet:
+		set, err := api.Client.AppsV1().StatefulSets(ns).Get(ctx, w.Name, v1.GetOptions{})
+		if err != nil {
+			return false, "", "", fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+
+		replicas := int32(1)
+		if set.Spec.Replicas != nil {
+			replicas = *set.Spec.Replicas
+		}
+		status := set.Status
+
+		done := status.ObservedGeneration >= set.Generation &&
+			status.Replicas == replicas &&
+			status.ReadyReplicas == replicas
+		message := fmt.Sprintf("%d of %d replica(s) ready", status.ReadyReplicas, replicas)
+		return done, message, set.ResourceVersion, nil
+	}
+	return false, "", "", fmt.Errorf("the workload kind %v is not supported", w.Kind)
+}
+
+// gets the pod label selector of the deployment or statefulset "w"
+func getWorkloadSelector(api *KubernetesAPI, ns string, w Workload) (string, error) {
+	var selector *v1.LabelSelector
+
+	switch w.Kind {
+	case KindDeployment:
+		deploy, err := api.Client.AppsV1().Deployments(ns).Get(api.Context(), w.Name, v1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+		selector = deploy.Spec.Selector
+	case KindStatefulSet:
+		set, err := api.Client.AppsV1().StatefulSets(ns).Get(api.Context(), w.Name, v1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("there was an error getting %s. %w", w, err)
+		}
+		selector = set.Spec.Selector
+	default:
+		return "", fmt.Errorf("the workload kind %v is not supported", w.Kind)
+	}
+
+	// an empty selector would match every pod in the namespace
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return "", nil
+	}
+
+	s, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", fmt.Errorf("the selector of %s is not valid. %w", w, err)
+	}
+	return s.String(), nil
+}
+
+// blocks until the watcher sends an event of type "eventType" or is closed by the api server,
+// the caller checks the state again either way
+func waitForEvent(ctx context.Context, watcher watch.Interface, eventType watch.EventType) error {
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("error watching the resource. %v", event.Object)
+			}
+			if event.Type == eventType {
+				return nil
+			}
+		}
+	}
+}
+
+// turns a deadline exceeded into a readable timeout error
+func waitError(ctx context.Context, waitingFor string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+		return fmt.Errorf("timed out waiting for %s, increase --wait-timeout if it needs longer. %w", waitingFor, err)
+	}
+	return fmt.Errorf("error waiting for %s. %w", waitingFor, err)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitForPodsGone(t *testing.T) {
+	replicas := int32(0)
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "cnvrg"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+			},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "cnvrg", Labels: map[string]string{"app": "app"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "cnvrg", Labels: map[string]string{"app": "other"}}},
+	)
+	api := &KubernetesAPI{Client: client}
+	workloads := []Workload{{Kind: KindDeployment, Name: "app"}}
+
+	// the pod is still running
+	err := WaitForPodsGone(api, "cnvrg", workloads, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+
+	// the pod terminates while waiting, pods of other workloads are ignored
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.CoreV1().Pods("cnvrg").Delete(context.Background(), "app-1", metav1.DeleteOptions{})
+	}()
+	err = WaitForPodsGone(api, "cnvrg", workloads, 5*time.Second)
+	if err != nil {
+		t.Fatalf("error waiting for the pods: %v", err)
+	}
+}
+
+func TestWaitForRollout(t *testing.T) {
+	replicas := int32(2)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "cnvrg"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1},
+	}
+	client := fake.NewSimpleClientset(deploy)
+	api := &KubernetesAPI{Client: client}
+	workloads := []Workload{{Kind: KindDeployment, Name: "app"}}
+
+	// one replica isn't available yet
+	err := WaitForRollout(api, "cnvrg", workloads, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+
+	// the second replica becomes available while waiting
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		available := deploy.DeepCopy()
+		available.Status.AvailableReplicas = 2
+		client.AppsV1().Deployments("cnvrg").UpdateStatus(context.Background(), available, metav1.UpdateOptions{})
+	}()
+	err = WaitForRollout(api, "cnvrg", workloads, 5*time.Second)
+	if err != nil {
+		t.Fatalf("error waiting for the rollout: %v", err)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	_ "github.com/dilerous/cnvrgctl/cmd/backup"
 	_ "github.com/dilerous/cnvrgctl/cmd/install"
 	_ "github.com/dilerous/cnvrgctl/cmd/logs"
+	_ "github.com/dilerous/cnvrgctl/cmd/maintenance"
 	_ "github.com/dilerous/cnvrgctl/cmd/restore"
+	_ "github.com/dilerous/cnvrgctl/cmd/scale"
+	_ "github.com/dilerous/cnvrgctl/cmd/support"
 )
 
 func main() {